@@ -11,8 +11,25 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// Collections holds every MongoDB collection used by the application
+type Collections struct {
+	Users                *mongo.Collection
+	Notes                *mongo.Collection
+	Notebooks            *mongo.Collection
+	NoteRevisions        *mongo.Collection
+	Sessions             *mongo.Collection
+	RefreshTokens        *mongo.Collection
+	RevokedTokens        *mongo.Collection
+	UserTokens           *mongo.Collection
+	PersonalAccessTokens *mongo.Collection
+	LoginAttempts        *mongo.Collection
+	RateLimits           *mongo.Collection
+	OIDCLogins           *mongo.Collection
+	ExternalIdentities   *mongo.Collection
+}
+
 // connect establishes a connection to MongoDB and returns the client and collections
-func Connect() (*mongo.Client, *mongo.Collection, *mongo.Collection) {
+func Connect() (*mongo.Client, *Collections) {
 
 	// connect to MongoDB
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -28,16 +45,29 @@ func Connect() (*mongo.Client, *mongo.Collection, *mongo.Collection) {
 
 	// Initialize Collections
 	db := client.Database("GoGoNotes")
-	userCollection := db.Collection("users")
-	noteCollection := db.Collection("notes")
+	collections := &Collections{
+		Users:                db.Collection("users"),
+		Notes:                db.Collection("notes"),
+		Notebooks:            db.Collection("notebooks"),
+		NoteRevisions:        db.Collection("note_revisions"),
+		Sessions:             db.Collection("sessions"),
+		RefreshTokens:        db.Collection("refresh_tokens"),
+		RevokedTokens:        db.Collection("revoked_tokens"),
+		UserTokens:           db.Collection("user_tokens"),
+		PersonalAccessTokens: db.Collection("personal_access_tokens"),
+		LoginAttempts:        db.Collection("login_attempts"),
+		RateLimits:           db.Collection("rate_limits"),
+		OIDCLogins:           db.Collection("oidc_logins"),
+		ExternalIdentities:   db.Collection("external_identities"),
+	}
 
 	// Check connection by Running a Query
-	err = userCollection.FindOne(ctx, bson.M{}).Err()
+	err = collections.Users.FindOne(ctx, bson.M{}).Err()
 	if err != nil && err != mongo.ErrNoDocuments {
 		log.Fatalf("Failed to query user collection: %v", err)
 	}
 
 	log.Println("Successfully Connected to the MongoDB database ;)")
-	return client, userCollection, noteCollection
+	return client, collections
 
 }
@@ -0,0 +1,357 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/suraj/GoGoNotes/mailer"
+	"github.com/suraj/GoGoNotes/middleware"
+	"github.com/suraj/GoGoNotes/models"
+	"github.com/suraj/GoGoNotes/utils"
+)
+
+type AccountHandler struct {
+	accountModel      *models.AccountModel
+	userModel         *models.UserModel
+	userTokenModel    *models.UserTokenModel
+	sessionModel      *models.SessionModel
+	refreshTokenModel *models.RefreshTokenModel
+	patModel          *models.PersonalAccessTokenModel
+	tokenIssuer       *TokenIssuer
+	mailer            mailer.Mailer
+	passwordPolicy    *utils.PasswordPolicy
+	emailTokenTTL     time.Duration
+	appBaseURL        string
+}
+
+func NewAccountHandler(accountModel *models.AccountModel, userModel *models.UserModel, userTokenModel *models.UserTokenModel, sessionModel *models.SessionModel, refreshTokenModel *models.RefreshTokenModel, patModel *models.PersonalAccessTokenModel, tokenIssuer *TokenIssuer, mailer mailer.Mailer, passwordPolicy *utils.PasswordPolicy, emailTokenTTL time.Duration, appBaseURL string) *AccountHandler {
+	return &AccountHandler{
+		accountModel:      accountModel,
+		userModel:         userModel,
+		userTokenModel:    userTokenModel,
+		sessionModel:      sessionModel,
+		refreshTokenModel: refreshTokenModel,
+		patModel:          patModel,
+		tokenIssuer:       tokenIssuer,
+		mailer:            mailer,
+		passwordPolicy:    passwordPolicy,
+		emailTokenTTL:     emailTokenTTL,
+		appBaseURL:        appBaseURL,
+	}
+}
+
+// ChangePassword replaces the password after checking the current one. Every other
+// session is signed out, personal access tokens are revoked and the caller gets a
+// fresh pair of tokens.
+func (h *AccountHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserIDFromContext(r.Context())
+
+	var input struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil || input.NewPassword == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "current_password and new_password are required",
+		})
+		return
+	}
+
+	user, err := h.userModel.GetByID(userID)
+	if err != nil || !h.userModel.VerifyPassword(user, input.CurrentPassword) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Current password is incorrect",
+		})
+		return
+	}
+
+	problem, err := h.passwordPolicy.Validate(input.NewPassword)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Failed to check password: " + err.Error(),
+		})
+		return
+	}
+	if problem != "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Validation failed",
+			"errors":  utils.ValidationErrors{"new_password": problem},
+		})
+		return
+	}
+
+	if err := h.userModel.UpdatePassword(userID, input.NewPassword); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Failed to change password: " + err.Error(),
+		})
+		return
+	}
+
+	if err := h.patModel.RevokeAllForUser(userID); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	h.respondWithNewSession(w, r, "Password changed successfully")
+}
+
+// ChangeEmail starts an email change. The new address only takes effect once the
+// link sent to it is opened, but other sessions are signed out straight away.
+func (h *AccountHandler) ChangeEmail(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserIDFromContext(r.Context())
+
+	var input struct {
+		Password string `json:"password"`
+		NewEmail string `json:"new_email"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil || input.NewEmail == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "new_email is required",
+		})
+		return
+	}
+
+	newEmail, err := utils.NormalizeEmail(input.NewEmail)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Validation failed",
+			"errors":  utils.ValidationErrors{"new_email": err.Error()},
+		})
+		return
+	}
+
+	user, err := h.userModel.GetByID(userID)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Password is incorrect",
+		})
+		return
+	}
+	if ok, message := confirmIdentity(r, h.userModel, h.sessionModel, user, input.Password); !ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": message,
+		})
+		return
+	}
+
+	if strings.EqualFold(newEmail, user.Email) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "New email is the same as the current one",
+		})
+		return
+	}
+
+	if _, err := h.userModel.GetByEmail(newEmail); err == nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": models.ErrEmailInUse.Error(),
+		})
+		return
+	}
+
+	if err := h.sendEmailChangeEmail(user, newEmail); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Failed to send verification email: " + err.Error(),
+		})
+		return
+	}
+
+	if err := h.userModel.IncrementTokenGeneration(userID); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	h.respondWithNewSession(w, r, "Check your new email address to confirm the change")
+}
+
+// DeleteAccount permanently removes the account and all of its notes after confirming it is really the user
+func (h *AccountHandler) DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserIDFromContext(r.Context())
+
+	var input struct {
+		Password string `json:"password"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	user, err := h.userModel.GetByID(userID)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Password is incorrect",
+		})
+		return
+	}
+	if ok, message := confirmIdentity(r, h.userModel, h.sessionModel, user, input.Password); !ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": message,
+		})
+		return
+	}
+
+	if err := h.accountModel.Delete(userID); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Failed to delete account: " + err.Error(),
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  true,
+		"message": "Account deleted successfully",
+	})
+}
+
+// respondWithNewSession ends every other session of the user and hands the caller
+// a new token pair, so only the session that made the change stays signed in.
+// The token generation must already have been bumped.
+func (h *AccountHandler) respondWithNewSession(w http.ResponseWriter, r *http.Request, message string) {
+	identity, _ := middleware.IdentityFromContext(r.Context())
+	userID := identity.UserID
+
+	if err := h.sessionModel.DeleteOthers(userID, identity.SessionID); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	// This also revokes the current session's refresh token, a new one is issued below
+	if err := h.refreshTokenModel.RevokeAllForUser(userID); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	user, err := h.userModel.GetByID(userID)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "User not found",
+		})
+		return
+	}
+
+	tokenString, refreshToken, err := h.tokenIssuer.Issue(user, identity.SessionID)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Failed to generate token",
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":        true,
+		"message":       message,
+		"token":         tokenString,
+		"refresh_token": refreshToken,
+		"expires_in":    h.tokenIssuer.ExpiresIn(),
+	})
+}
+
+func (h *AccountHandler) sendEmailChangeEmail(user *models.User, newEmail string) error {
+	token, err := h.userTokenModel.CreateForEmail(user.ID, models.TokenPurposeEmailChange, newEmail, h.emailTokenTTL)
+	if err != nil {
+		return err
+	}
+
+	link := h.appBaseURL + "/verify-email?token=" + url.QueryEscape(token)
+	body := fmt.Sprintf("Please confirm that you want to use this address for your GoGoNotes account.\n\n"+
+		"Open the link below within %s to finish the change:\n\n%s\n\n"+
+		"If you didn't ask for this, you can ignore this email.", h.emailTokenTTL, link)
+
+	if err := h.mailer.Send(newEmail, "Confirm your new GoGoNotes email address", body); err != nil {
+		return err
+	}
+
+	// Let the current address know, in case the change wasn't made by its owner
+	notice := fmt.Sprintf("A request was made to change the email address of your GoGoNotes account to %s.\n\n"+
+		"If this wasn't you, reset your password right away.", newEmail)
+	if err := h.mailer.Send(user.Email, "Your GoGoNotes email address is changing", notice); err != nil {
+		log.Printf("Failed to send email change notice: %v", err)
+	}
+
+	return nil
+}
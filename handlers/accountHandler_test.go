@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/suraj/GoGoNotes/internal/testutil"
+	"github.com/suraj/GoGoNotes/middleware"
+	"github.com/suraj/GoGoNotes/models"
+	"github.com/suraj/GoGoNotes/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func accountTestHandler(mt *mtest.T) *AccountHandler {
+	userModel := models.NewUserModel(mt.Coll)
+	sessionModel := models.NewSessionModel(mt.Coll, time.Hour)
+	refreshTokenModel := models.NewRefreshTokenModel(mt.Coll, time.Hour)
+	return NewAccountHandler(models.NewAccountModel(mt.Client, mt.Coll), userModel, models.NewUserTokenModel(mt.Coll),
+		sessionModel, refreshTokenModel, models.NewPersonalAccessTokenModel(mt.Coll),
+		NewTokenIssuer(userModel, sessionModel, refreshTokenModel, testKeyring(mt), time.Minute),
+		&sentMail{}, &utils.PasswordPolicy{MinLength: 12}, time.Hour, "https://api.example.com")
+}
+
+// passwordUser is a user whose password is "correct horse"
+func passwordUser(mt *mtest.T) models.User {
+	hash, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		mt.Fatal(err)
+	}
+	return models.User{ID: primitive.NewObjectID(), Email: "bob@example.com", Password: string(hash), EmailVerified: true}
+}
+
+// sessionRequest is a request made from the given session of the user
+func sessionRequest(method, body string, userID, sessionID primitive.ObjectID) *http.Request {
+	r := httptest.NewRequest(method, "/account", strings.NewReader(body))
+	return r.WithContext(middleware.WithIdentity(r.Context(), &middleware.Identity{UserID: userID, SessionID: sessionID}))
+}
+
+func TestDeleteAccountWithoutPassword(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("asks to sign in again", func(mt *mtest.T) {
+		h := accountTestHandler(mt)
+		user := models.User{ID: primitive.NewObjectID(), Email: "bob@example.com", EmailVerified: true}
+		sessionID := primitive.NewObjectID()
+		stale := models.Session{ID: sessionID, UserID: user.ID, CreatedAt: time.Now().Add(-time.Hour), ExpiresAt: time.Now().Add(time.Hour)}
+		mt.AddMockResponses(testutil.FindResponse(user), testutil.FindResponse(stale))
+
+		w := httptest.NewRecorder()
+		h.DeleteAccount(w, sessionRequest(http.MethodDelete, `{}`, user.ID, sessionID))
+
+		var body map[string]interface{}
+		json.NewDecoder(w.Body).Decode(&body)
+		if w.Code != http.StatusUnauthorized || body["message"] != reauthMessage {
+			mt.Errorf("status %d: %v", w.Code, body)
+		}
+	})
+}
+
+func TestChangePassword(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	change := func(h *AccountHandler, user models.User, sessionID primitive.ObjectID, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		h.ChangePassword(w, sessionRequest(http.MethodPut, body, user.ID, sessionID))
+
+		var response map[string]interface{}
+		json.NewDecoder(w.Body).Decode(&response)
+		return w, response
+	}
+
+	mt.Run("wrong current password", func(mt *mtest.T) {
+		user := passwordUser(mt)
+		mt.AddMockResponses(testutil.FindResponse(user))
+
+		w, body := change(accountTestHandler(mt), user, primitive.NewObjectID(), `{"current_password":"wrong","new_password":"a much longer password"}`)
+		if w.Code != http.StatusUnauthorized {
+			mt.Fatalf("status %d: %v", w.Code, body)
+		}
+		mt.GetStartedEvent()
+		if event := mt.GetStartedEvent(); event != nil {
+			mt.Errorf("nothing may change, got %s", event.CommandName)
+		}
+	})
+
+	mt.Run("weak new password", func(mt *mtest.T) {
+		user := passwordUser(mt)
+		mt.AddMockResponses(testutil.FindResponse(user))
+
+		w, body := change(accountTestHandler(mt), user, primitive.NewObjectID(), `{"current_password":"correct horse","new_password":"short"}`)
+		if errors, _ := body["errors"].(map[string]interface{}); w.Code != http.StatusBadRequest || errors["new_password"] == nil {
+			mt.Fatalf("status %d: %v", w.Code, body)
+		}
+	})
+
+	mt.Run("keeps only the current session", func(mt *mtest.T) {
+		user := passwordUser(mt)
+		sessionID := primitive.NewObjectID()
+		mt.AddMockResponses(
+			testutil.FindResponse(user),
+			testutil.UpdateResponse(1), testutil.UpdateResponse(2), testutil.DeleteResponse(3), testutil.UpdateResponse(4),
+			testutil.FindResponse(user), mtest.CreateSuccessResponse(),
+		)
+
+		w, body := change(accountTestHandler(mt), user, sessionID, `{"current_password":"correct horse","new_password":"a much longer password"}`)
+		if w.Code != http.StatusOK || body["token"] == "" || body["refresh_token"] == "" {
+			mt.Fatalf("status %d: %v", w.Code, body)
+		}
+
+		mt.GetStartedEvent()
+		if password := mt.GetStartedEvent().Command; password.Lookup("updates", "0", "u", "$inc", "token_generation").IsZero() {
+			mt.Errorf("the password update must retire access tokens, got %s", password)
+		}
+		if pats := mt.GetStartedEvent().Command; pats.Lookup("updates", "0", "u", "$set", "revoked_at").IsZero() {
+			mt.Errorf("expected the personal access tokens to be revoked, got %s", pats)
+		}
+		if sessions := mt.GetStartedEvent().Command; sessions.Lookup("deletes", "0", "q", "_id", "$ne").ObjectID() != sessionID {
+			mt.Errorf("expected every other session to be deleted, got %s", sessions)
+		}
+	})
+}
+
+func TestChangeEmail(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	changeEmail := func(h *AccountHandler, user models.User, newEmail string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		h.ChangeEmail(w, sessionRequest(http.MethodPut, `{"password":"correct horse","new_email":"`+newEmail+`"}`, user.ID, primitive.NewObjectID()))
+
+		var response map[string]interface{}
+		json.NewDecoder(w.Body).Decode(&response)
+		return w, response
+	}
+
+	mt.Run("same address", func(mt *mtest.T) {
+		user := passwordUser(mt)
+		mt.AddMockResponses(testutil.FindResponse(user))
+
+		if w, body := changeEmail(accountTestHandler(mt), user, "Bob@Example.com"); w.Code != http.StatusBadRequest {
+			mt.Errorf("status %d: %v", w.Code, body)
+		}
+	})
+
+	mt.Run("address in use", func(mt *mtest.T) {
+		user := passwordUser(mt)
+		other := models.User{ID: primitive.NewObjectID(), Email: "alice@example.com"}
+		mt.AddMockResponses(testutil.FindResponse(user), testutil.FindResponse(other))
+
+		if w, body := changeEmail(accountTestHandler(mt), user, "alice@example.com"); w.Code != http.StatusConflict || body["message"] != models.ErrEmailInUse.Error() {
+			mt.Errorf("status %d: %v", w.Code, body)
+		}
+	})
+
+	mt.Run("link goes to the new address, a notice to the old one", func(mt *mtest.T) {
+		h := accountTestHandler(mt)
+		mail := h.mailer.(*sentMail)
+		user := passwordUser(mt)
+		mt.AddMockResponses(
+			testutil.FindResponse(user), testutil.FindResponse(),
+			testutil.DeleteResponse(0), mtest.CreateSuccessResponse(),
+			testutil.UpdateResponse(1), testutil.DeleteResponse(1), testutil.UpdateResponse(1),
+			testutil.FindResponse(user), mtest.CreateSuccessResponse(),
+		)
+
+		w, body := changeEmail(h, user, "New@Example.com")
+		if w.Code != http.StatusOK {
+			mt.Fatalf("status %d: %v", w.Code, body)
+		}
+		if len(mail.to) != 2 || mail.to[0] != "new@example.com" || !strings.Contains(mail.body[0], "/verify-email?token=") {
+			mt.Fatalf("sent to %v", mail.to)
+		}
+		if mail.to[1] != "bob@example.com" || strings.Contains(mail.body[1], "token=") {
+			mt.Errorf("the current address must only get a notice, got %q", mail.body[1])
+		}
+
+		mt.GetStartedEvent()
+		mt.GetStartedEvent()
+		mt.GetStartedEvent()
+		if token := mt.GetStartedEvent().Command; token.Lookup("documents", "0", "email").StringValue() != "new@example.com" {
+			mt.Errorf("the token must carry the new address, got %s", token)
+		}
+	})
+}
+
+func TestDeleteAccountWithPassword(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	deleteAccount := func(h *AccountHandler, user models.User, password string) int {
+		w := httptest.NewRecorder()
+		h.DeleteAccount(w, sessionRequest(http.MethodDelete, `{"password":"`+password+`"}`, user.ID, primitive.NewObjectID()))
+		return w.Code
+	}
+
+	mt.Run("wrong password", func(mt *mtest.T) {
+		user := passwordUser(mt)
+		mt.AddMockResponses(testutil.FindResponse(user))
+
+		if code := deleteAccount(accountTestHandler(mt), user, "wrong"); code != http.StatusUnauthorized {
+			mt.Fatalf("status %d", code)
+		}
+		mt.GetStartedEvent()
+		if event := mt.GetStartedEvent(); event != nil {
+			mt.Errorf("nothing may be deleted, got %s", event.CommandName)
+		}
+	})
+
+	mt.Run("deleted", func(mt *mtest.T) {
+		user := passwordUser(mt)
+		mt.AddMockResponses(testutil.FindResponse(user), testutil.DeleteResponse(1), mtest.CreateSuccessResponse())
+
+		if code := deleteAccount(accountTestHandler(mt), user, "correct horse"); code != http.StatusOK {
+			mt.Fatalf("status %d", code)
+		}
+		mt.GetStartedEvent()
+		if event := mt.GetStartedEvent(); event.CommandName != "delete" || event.Command.Lookup("deletes", "0", "q", "_id").ObjectID() != user.ID {
+			mt.Errorf("expected the user to be deleted, got %s", event.Command)
+		}
+	})
+}
@@ -0,0 +1,426 @@
+package handlers
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/suraj/GoGoNotes/middleware"
+	"github.com/suraj/GoGoNotes/models"
+	"github.com/suraj/GoGoNotes/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// adminPageSize and adminMaxPageSize control the pagination of the user listing
+const (
+	adminPageSize    = 20
+	adminMaxPageSize = 100
+)
+
+// AdminHandler serves the operator endpoints under /admin
+type AdminHandler struct {
+	userModel         *models.UserModel
+	noteModel         *models.NoteModel
+	sessionModel      *models.SessionModel
+	refreshTokenModel *models.RefreshTokenModel
+	patModel          *models.PersonalAccessTokenModel
+	accountModel      *models.AccountModel
+	passwordHandler   *PasswordHandler
+}
+
+func NewAdminHandler(userModel *models.UserModel, noteModel *models.NoteModel, sessionModel *models.SessionModel, refreshTokenModel *models.RefreshTokenModel, patModel *models.PersonalAccessTokenModel, accountModel *models.AccountModel, passwordHandler *PasswordHandler) *AdminHandler {
+	return &AdminHandler{
+		userModel:         userModel,
+		noteModel:         noteModel,
+		sessionModel:      sessionModel,
+		refreshTokenModel: refreshTokenModel,
+		patModel:          patModel,
+		accountModel:      accountModel,
+		passwordHandler:   passwordHandler,
+	}
+}
+
+// ListUsers returns a page of users. ?q= filters by part of the email address.
+func (h *AdminHandler) ListUsers(w http.ResponseWriter, r *http.Request) {
+	page, err := utils.QueryInt(r, "page", 1, math.MaxInt32)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": err.Error(),
+			"users":   []interface{}{},
+		})
+		return
+	}
+	limit, err := utils.QueryInt(r, "limit", adminPageSize, adminMaxPageSize)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": err.Error(),
+			"users":   []interface{}{},
+		})
+		return
+	}
+
+	users, total, err := h.userModel.List(strings.TrimSpace(r.URL.Query().Get("q")), page, limit)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Failed to fetch users: " + err.Error(),
+			"users":   []interface{}{},
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  true,
+		"message": "Users fetched successfully",
+		"users":   users,
+		"page":    page,
+		"limit":   limit,
+		"total":   total,
+	})
+}
+
+// GetUser returns a user together with how many notes they have
+func (h *AdminHandler) GetUser(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	userID, err := primitive.ObjectIDFromHex(params["id"])
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Invalid user ID",
+		})
+		return
+	}
+
+	user, err := h.userModel.GetByID(userID)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "User not found",
+		})
+		return
+	}
+
+	noteCount, err := h.noteModel.CountForUser(userID)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":     true,
+		"message":    "User fetched successfully",
+		"user":       user,
+		"note_count": noteCount,
+	})
+}
+
+// DisableUser blocks an account from signing in and ends all of its sessions.
+// Its personal access tokens stop working too, but come back if the account is enabled again.
+func (h *AdminHandler) DisableUser(w http.ResponseWriter, r *http.Request) {
+	h.setDisabled(w, r, true)
+}
+
+func (h *AdminHandler) EnableUser(w http.ResponseWriter, r *http.Request) {
+	h.setDisabled(w, r, false)
+}
+
+func (h *AdminHandler) setDisabled(w http.ResponseWriter, r *http.Request, disabled bool) {
+	adminID := middleware.UserIDFromContext(r.Context())
+
+	params := mux.Vars(r)
+	userID, err := primitive.ObjectIDFromHex(params["id"])
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Invalid user ID",
+		})
+		return
+	}
+
+	if disabled && userID == adminID {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "You can't disable your own account",
+		})
+		return
+	}
+
+	if err := h.userModel.SetDisabled(userID, disabled); err != nil {
+		status := http.StatusInternalServerError
+		if err == models.ErrUserNotFound {
+			status = http.StatusNotFound
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	message := "User enabled successfully"
+	if disabled {
+		if err := h.endAllSessions(userID); err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"status":  false,
+				"message": err.Error(),
+			})
+			return
+		}
+		message = "User disabled successfully"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  true,
+		"message": message,
+	})
+}
+
+// ForcePasswordReset removes the user's password, signs them out everywhere, revokes
+// their personal access tokens and emails them a reset link. They can't sign in with a password again until they use it.
+func (h *AdminHandler) ForcePasswordReset(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	userID, err := primitive.ObjectIDFromHex(params["id"])
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Invalid user ID",
+		})
+		return
+	}
+
+	user, err := h.userModel.GetByID(userID)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "User not found",
+		})
+		return
+	}
+
+	if err := h.userModel.ClearPassword(userID); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	if err := h.endAllSessions(userID); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	if err := h.patModel.RevokeAllForUser(userID); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	if err := h.passwordHandler.SendResetEmail(user); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Password was cleared but the reset email could not be sent: " + err.Error(),
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  true,
+		"message": "Password reset email sent",
+	})
+}
+
+// DeleteUser removes a user and everything they own. Admins delete their own account through /account.
+func (h *AdminHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	adminID := middleware.UserIDFromContext(r.Context())
+
+	params := mux.Vars(r)
+	userID, err := primitive.ObjectIDFromHex(params["id"])
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Invalid user ID",
+		})
+		return
+	}
+
+	if userID == adminID {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Use DELETE /account to delete your own account",
+		})
+		return
+	}
+
+	if err := h.accountModel.Delete(userID); err != nil {
+		status := http.StatusInternalServerError
+		message := "Failed to delete user: " + err.Error()
+		if err == models.ErrUserNotFound {
+			status = http.StatusNotFound
+			message = err.Error()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": message,
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  true,
+		"message": "User deleted successfully",
+	})
+}
+
+// endAllSessions signs the user out on every device
+func (h *AdminHandler) endAllSessions(userID primitive.ObjectID) error {
+	if err := h.sessionModel.DeleteAllForUser(userID); err != nil {
+		return err
+	}
+	return h.refreshTokenModel.RevokeAllForUser(userID)
+}
+
+// SetUserRoles replaces a user's roles. Every user keeps the user role, and admins
+// can't take the admin role away from themselves so there is always one left.
+func (h *AdminHandler) SetUserRoles(w http.ResponseWriter, r *http.Request) {
+	adminID := middleware.UserIDFromContext(r.Context())
+
+	params := mux.Vars(r)
+	userID, err := primitive.ObjectIDFromHex(params["id"])
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Invalid user ID",
+		})
+		return
+	}
+
+	var input struct {
+		Roles []string `json:"roles"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	roles := []string{models.RoleUser}
+	isAdmin := false
+	for _, role := range input.Roles {
+		if !isValidRole(role) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"status":  false,
+				"message": "Unknown role: " + role,
+			})
+			return
+		}
+		if role == models.RoleAdmin && !isAdmin {
+			isAdmin = true
+			roles = append(roles, role)
+		}
+	}
+
+	if userID == adminID && !isAdmin {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "You can't remove your own admin role",
+		})
+		return
+	}
+
+	if err := h.userModel.SetRoles(userID, roles); err != nil {
+		status := http.StatusInternalServerError
+		if err == models.ErrUserNotFound {
+			status = http.StatusNotFound
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  true,
+		"message": "Roles updated successfully",
+		"roles":   roles,
+	})
+}
+
+func isValidRole(role string) bool {
+	for _, r := range models.AllRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/suraj/GoGoNotes/internal/testutil"
+	"github.com/suraj/GoGoNotes/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestAdminUserNotFound(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	dbError := mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 91, Message: "shutting down"})
+	actions := []struct {
+		name   string
+		method string
+		body   string
+		call   func(h *AdminHandler, w http.ResponseWriter, r *http.Request)
+	}{
+		{"disable", http.MethodPost, "", (*AdminHandler).DisableUser},
+		{"enable", http.MethodPost, "", (*AdminHandler).EnableUser},
+		{"set roles", http.MethodPut, `{"roles":["admin"]}`, (*AdminHandler).SetUserRoles},
+		{"delete", http.MethodDelete, "", (*AdminHandler).DeleteUser},
+	}
+	tests := []struct {
+		name     string
+		response bson.D
+		want     int
+	}{
+		{"missing user", testutil.UpdateResponse(0), http.StatusNotFound},
+		{"database error", dbError, http.StatusInternalServerError},
+	}
+
+	for _, action := range actions {
+		for _, tt := range tests {
+			mt.Run(action.name+" "+tt.name, func(mt *mtest.T) {
+				h := NewAdminHandler(models.NewUserModel(mt.Coll), nil, models.NewSessionModel(mt.Coll, time.Hour), nil, nil,
+					models.NewAccountModel(mt.Client, mt.Coll), nil)
+				mt.AddMockResponses(tt.response)
+
+				r := sessionRequest(action.method, action.body, primitive.NewObjectID(), primitive.NewObjectID())
+				w := httptest.NewRecorder()
+				action.call(h, w, mux.SetURLVars(r, map[string]string{"id": primitive.NewObjectID().Hex()}))
+
+				if w.Code != tt.want {
+					mt.Errorf("status %d, want %d: %s", w.Code, tt.want, w.Body)
+				}
+			})
+		}
+	}
+}
+
+func TestSetUserRoles(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	adminID := primitive.NewObjectID()
+	setRoles := func(h *AdminHandler, userID primitive.ObjectID, body string) int {
+		r := sessionRequest(http.MethodPut, body, adminID, primitive.NewObjectID())
+		w := httptest.NewRecorder()
+		h.SetUserRoles(w, mux.SetURLVars(r, map[string]string{"id": userID.Hex()}))
+		return w.Code
+	}
+	handler := func(mt *mtest.T) *AdminHandler {
+		return NewAdminHandler(models.NewUserModel(mt.Coll), nil, nil, nil, nil, nil, nil)
+	}
+
+	tests := []struct {
+		name string
+		user primitive.ObjectID
+		body string
+		want int
+	}{
+		{"unknown role", primitive.NewObjectID(), `{"roles":["owner"]}`, http.StatusBadRequest},
+		{"admin demoting themselves", adminID, `{"roles":["user"]}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			if code := setRoles(handler(mt), tt.user, tt.body); code != tt.want {
+				mt.Errorf("status %d, want %d", code, tt.want)
+			}
+			if event := mt.GetStartedEvent(); event != nil {
+				mt.Errorf("roles must not change, got %s", event.CommandName)
+			}
+		})
+	}
+
+	mt.Run("user role is always kept", func(mt *mtest.T) {
+		mt.AddMockResponses(testutil.UpdateResponse(1))
+
+		if code := setRoles(handler(mt), primitive.NewObjectID(), `{"roles":["admin","admin"]}`); code != http.StatusOK {
+			mt.Fatalf("status %d", code)
+		}
+		update := mt.GetStartedEvent().Command.Lookup("updates", "0", "u")
+		roles, _ := update.Document().Lookup("$set", "roles").Array().Values()
+		if len(roles) != 2 || roles[0].StringValue() != models.RoleUser || roles[1].StringValue() != models.RoleAdmin {
+			mt.Errorf("roles = %v", roles)
+		}
+		if update.Document().Lookup("$inc", "token_generation").IsZero() {
+			mt.Error("tokens carrying the old roles must be retired")
+		}
+	})
+}
+
+func TestListUsers(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	list := func(h *AdminHandler, query string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		h.ListUsers(w, httptest.NewRequest(http.MethodGet, "/admin/users"+query, nil))
+
+		var body map[string]interface{}
+		json.NewDecoder(w.Body).Decode(&body)
+		return w, body
+	}
+	count := func(n int) bson.D {
+		return mtest.CreateCursorResponse(0, "test.users", mtest.FirstBatch, bson.D{{Key: "n", Value: n}})
+	}
+
+	mt.Run("search is taken literally", func(mt *mtest.T) {
+		h := NewAdminHandler(models.NewUserModel(mt.Coll), nil, nil, nil, nil, nil, nil)
+		mt.AddMockResponses(count(41), testutil.FindResponse(models.User{ID: primitive.NewObjectID(), Email: "a.b+c@example.com"}))
+
+		w, body := list(h, "?q=a.b%2Bc&page=3&limit=20")
+		if w.Code != http.StatusOK || body["total"] != float64(41) || body["page"] != float64(3) {
+			mt.Fatalf("status %d: %v", w.Code, body)
+		}
+
+		pattern, _ := mt.GetStartedEvent().Command.Lookup("pipeline", "0", "$match", "email").Regex()
+		if pattern != `a\.b\+c` {
+			mt.Errorf("search must be escaped, got %q", pattern)
+		}
+		find := mt.GetStartedEvent().Command
+		if find.Lookup("skip").AsInt64() != 40 || find.Lookup("limit").AsInt64() != 20 {
+			mt.Errorf("page 3 of 20 must skip 40, got %s", find)
+		}
+	})
+
+	mt.Run("limit is capped", func(mt *mtest.T) {
+		h := NewAdminHandler(models.NewUserModel(mt.Coll), nil, nil, nil, nil, nil, nil)
+		mt.AddMockResponses(count(0), testutil.FindResponse())
+
+		if w, body := list(h, "?limit=100000"); w.Code != http.StatusOK || body["limit"] != float64(adminMaxPageSize) {
+			mt.Fatalf("status %d: %v", w.Code, body)
+		}
+	})
+
+	mt.Run("bad page", func(mt *mtest.T) {
+		h := NewAdminHandler(models.NewUserModel(mt.Coll), nil, nil, nil, nil, nil, nil)
+		if w, body := list(h, "?page=0"); w.Code != http.StatusBadRequest {
+			mt.Fatalf("status %d: %v", w.Code, body)
+		}
+	})
+}
+
+func TestAdminCannotLockThemselvesOut(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	adminID := primitive.NewObjectID()
+	actions := []struct {
+		name string
+		call func(h *AdminHandler, w http.ResponseWriter, r *http.Request)
+	}{
+		{"disable", (*AdminHandler).DisableUser},
+		{"delete", (*AdminHandler).DeleteUser},
+	}
+	for _, action := range actions {
+		mt.Run(action.name, func(mt *mtest.T) {
+			h := NewAdminHandler(models.NewUserModel(mt.Coll), nil, nil, nil, nil, models.NewAccountModel(mt.Client, mt.Coll), nil)
+			r := sessionRequest(http.MethodPost, "", adminID, primitive.NewObjectID())
+			w := httptest.NewRecorder()
+			action.call(h, w, mux.SetURLVars(r, map[string]string{"id": adminID.Hex()}))
+
+			if w.Code != http.StatusBadRequest {
+				mt.Errorf("status %d", w.Code)
+			}
+			if event := mt.GetStartedEvent(); event != nil {
+				mt.Errorf("nothing may change, got %s", event.CommandName)
+			}
+		})
+	}
+}
+
+func TestDisableUserEndsSessions(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("disable", func(mt *mtest.T) {
+		h := NewAdminHandler(models.NewUserModel(mt.Coll), nil, models.NewSessionModel(mt.Coll, time.Hour), models.NewRefreshTokenModel(mt.Coll, time.Hour), nil, nil, nil)
+		userID := primitive.NewObjectID()
+		mt.AddMockResponses(testutil.UpdateResponse(1), testutil.DeleteResponse(2), testutil.UpdateResponse(2))
+
+		r := sessionRequest(http.MethodPost, "", primitive.NewObjectID(), primitive.NewObjectID())
+		w := httptest.NewRecorder()
+		h.DisableUser(w, mux.SetURLVars(r, map[string]string{"id": userID.Hex()}))
+		if w.Code != http.StatusOK {
+			mt.Fatalf("status %d: %s", w.Code, w.Body)
+		}
+
+		mt.GetStartedEvent()
+		if sessions := mt.GetStartedEvent(); sessions.CommandName != "delete" || sessions.Command.Lookup("deletes", "0", "q", "user_id").ObjectID() != userID {
+			mt.Errorf("expected the user's sessions to be deleted, got %s", sessions.Command)
+		}
+		if refresh := mt.GetStartedEvent(); refresh.CommandName != "update" || refresh.Command.Lookup("updates", "0", "q", "user_id").ObjectID() != userID {
+			mt.Errorf("expected the user's refresh tokens to be revoked, got %s", refresh.Command)
+		}
+	})
+}
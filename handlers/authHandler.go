@@ -2,23 +2,35 @@ package handlers
 
 import (
 	"encoding/json"
+	"log"
 	"net/http"
-	"time"
 
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/suraj/GoGoNotes/middleware"
 	"github.com/suraj/GoGoNotes/models"
-	"go.mongodb.org/mongo-driver/bson/primitive"
+	"github.com/suraj/GoGoNotes/utils"
 )
 
 type AuthHandler struct {
-	userModel *models.UserModel
-	jwtSecret []byte
+	userModel         *models.UserModel
+	sessionModel      *models.SessionModel
+	refreshTokenModel *models.RefreshTokenModel
+	revokedTokenModel *models.RevokedTokenModel
+	loginAttemptModel *models.LoginAttemptModel
+	emailVerification *EmailVerificationHandler
+	tokenIssuer       *TokenIssuer
+	passwordPolicy    *utils.PasswordPolicy
 }
 
-func NewAuthHandler(userModel *models.UserModel, jwtSecret []byte) *AuthHandler {
+func NewAuthHandler(userModel *models.UserModel, sessionModel *models.SessionModel, refreshTokenModel *models.RefreshTokenModel, revokedTokenModel *models.RevokedTokenModel, loginAttemptModel *models.LoginAttemptModel, emailVerification *EmailVerificationHandler, tokenIssuer *TokenIssuer, passwordPolicy *utils.PasswordPolicy) *AuthHandler {
 	return &AuthHandler{
-		userModel: userModel,
-		jwtSecret: jwtSecret,
+		userModel:         userModel,
+		sessionModel:      sessionModel,
+		refreshTokenModel: refreshTokenModel,
+		revokedTokenModel: revokedTokenModel,
+		loginAttemptModel: loginAttemptModel,
+		emailVerification: emailVerification,
+		tokenIssuer:       tokenIssuer,
+		passwordPolicy:    passwordPolicy,
 	}
 }
 
@@ -39,10 +51,53 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := h.userModel.Create(input.Email, input.Password)
+	validationErrors := utils.ValidationErrors{}
+	email, err := utils.NormalizeEmail(input.Email)
+	if err != nil {
+		validationErrors["email"] = err.Error()
+	}
+	problem, err := h.passwordPolicy.Validate(input.Password)
 	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Failed to check password: " + err.Error(),
+			"token":   "",
+		})
+		return
+	}
+	if problem != "" {
+		validationErrors["password"] = problem
+	}
+
+	if len(validationErrors) > 0 {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Validation failed",
+			"errors":  validationErrors,
+			"token":   "",
+		})
+		return
+	}
+
+	user, err := h.userModel.Create(email, input.Password)
+	if err == models.ErrUserExists {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": err.Error(),
+			"errors":  utils.ValidationErrors{"email": "an account with this email already exists"},
+			"token":   "",
+		})
+		return
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"status":  false,
 			"message": err.Error(),
@@ -51,7 +106,12 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	tokenString, err := h.generateJWT(user.ID)
+	// The account works right away, verification only gates features that require it
+	if err := h.emailVerification.SendVerificationEmail(user); err != nil {
+		log.Printf("Failed to send verification email: %v", err)
+	}
+
+	tokenString, refreshToken, err := h.tokenIssuer.StartSession(user, r)
 	if err != nil {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusInternalServerError)
@@ -65,9 +125,11 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"status":  true,
-		"message": "User registered successfully",
-		"token":   tokenString,
+		"status":        true,
+		"message":       "User registered successfully",
+		"token":         tokenString,
+		"refresh_token": refreshToken,
+		"expires_in":    h.tokenIssuer.ExpiresIn(),
 	})
 }
 
@@ -88,8 +150,36 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Refuse locked out accounts and IPs before spending any time on bcrypt
+	ip := utils.ClientIP(r)
+	lockedFor, err := h.loginAttemptModel.LockedFor(input.Email, ip)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": err.Error(),
+			"token":   "",
+		})
+		return
+	}
+	if lockedFor > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Retry-After", utils.RetryAfter(lockedFor))
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Too many failed login attempts, try again later or reset your password",
+			"token":   "",
+		})
+		return
+	}
+
 	user, err := h.userModel.GetByEmail(input.Email)
 	if err != nil || !h.userModel.VerifyPassword(user, input.Password) {
+		if _, err := h.loginAttemptModel.RecordFailure(input.Email, ip); err != nil {
+			log.Printf("Failed to record login attempt: %v", err)
+		}
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusUnauthorized)
 		json.NewEncoder(w).Encode(map[string]interface{}{
@@ -100,7 +190,49 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	tokenString, err := h.generateJWT(user.ID)
+	if user.Disabled {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "This account has been disabled",
+			"token":   "",
+		})
+		return
+	}
+
+	// With TOTP enabled the password alone only earns a short-lived challenge token
+	if user.TOTPEnabled {
+		mfaToken, err := h.tokenIssuer.MFAToken(user)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"status":  false,
+				"message": "Failed to generate token",
+				"token":   "",
+			})
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":       true,
+			"message":      "Two-factor authentication required",
+			"token":        "",
+			"mfa_required": true,
+			"mfa_token":    mfaToken,
+		})
+		return
+	}
+
+	// Failures are only forgiven after a complete login, so the password step
+	// can't be repeated to reset the counter while guessing TOTP codes
+	if err := h.loginAttemptModel.Reset(user.Email); err != nil {
+		log.Printf("Failed to reset login attempts: %v", err)
+	}
+
+	tokenString, refreshToken, err := h.tokenIssuer.StartSession(user, r)
 	if err != nil {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusInternalServerError)
@@ -114,14 +246,51 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"status":  true,
-		"message": "Login Successful",
-		"token":   tokenString,
+		"status":        true,
+		"message":       "Login Successful",
+		"token":         tokenString,
+		"refresh_token": refreshToken,
+		"expires_in":    h.tokenIssuer.ExpiresIn(),
 	})
 }
 
+// Logout ends the session the request was made with, including its access and refresh tokens
 func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
-	// JWT is stateless: Just ask client to discard the token
+	identity, _ := middleware.IdentityFromContext(r.Context())
+
+	if err := h.revokedTokenModel.Revoke(identity.TokenID, identity.UserID, identity.ExpiresAt); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": err.Error(),
+			"token":   "",
+		})
+		return
+	}
+
+	if err := h.sessionModel.Delete(identity.SessionID, identity.UserID); err != nil && err != models.ErrSessionNotFound {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": err.Error(),
+			"token":   "",
+		})
+		return
+	}
+
+	if err := h.refreshTokenModel.RevokeFamily(identity.SessionID); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": err.Error(),
+			"token":   "",
+		})
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"status":  true,
@@ -130,13 +299,146 @@ func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func (h *AuthHandler) generateJWT(userID primitive.ObjectID) (string, error) {
-	claims := jwt.MapClaims{
-		"user_id": userID.Hex(),
-		"iat":     time.Now().Unix(), // gives new token at every login
-		// No expiration - Token Never Expires
+// LogoutAll invalidates every access and refresh token the user holds, on every device.
+// Personal access tokens are left alone: they belong to integrations rather than
+// devices, and are revoked one by one under /tokens or all at once when the password
+// is changed or reset.
+func (h *AuthHandler) LogoutAll(w http.ResponseWriter, r *http.Request) {
+	identity, _ := middleware.IdentityFromContext(r.Context())
+
+	if err := h.userModel.IncrementTokenGeneration(identity.UserID); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": err.Error(),
+			"token":   "",
+		})
+		return
+	}
+
+	if err := h.sessionModel.DeleteAllForUser(identity.UserID); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": err.Error(),
+			"token":   "",
+		})
+		return
+	}
+
+	if err := h.refreshTokenModel.RevokeAllForUser(identity.UserID); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": err.Error(),
+			"token":   "",
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  true,
+		"message": "Logged out of all devices",
+		"token":   "",
+	})
+}
+
+// Refresh exchanges a refresh token for a new access token and a rotated refresh token
+func (h *AuthHandler) Refresh(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil || input.RefreshToken == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "refresh_token is required",
+			"token":   "",
+		})
+		return
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(h.jwtSecret)
+	refreshToken, stored, err := h.refreshTokenModel.Rotate(input.RefreshToken)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err == models.ErrRefreshTokenInvalid || err == models.ErrRefreshTokenReused {
+			status = http.StatusUnauthorized
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": err.Error(),
+			"token":   "",
+		})
+		return
+	}
+
+	// The refresh token family is the session, so a signed out device can't refresh either
+	if _, err := h.sessionModel.Touch(stored.FamilyID, stored.UserID, utils.ClientIP(r)); err != nil {
+		status := http.StatusInternalServerError
+		if err == models.ErrSessionNotFound {
+			status = http.StatusUnauthorized
+			if err := h.refreshTokenModel.RevokeFamily(stored.FamilyID); err != nil {
+				log.Printf("Failed to revoke refresh tokens: %v", err)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": err.Error(),
+			"token":   "",
+		})
+		return
+	}
+
+	user, err := h.userModel.GetByID(stored.UserID)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "User not found",
+			"token":   "",
+		})
+		return
+	}
+	if user.Disabled {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "This account has been disabled",
+			"token":   "",
+		})
+		return
+	}
+
+	tokenString, err := h.tokenIssuer.AccessToken(user, stored.FamilyID)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Failed to generate token",
+			"token":   "",
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":        true,
+		"message":       "Token refreshed successfully",
+		"token":         tokenString,
+		"refresh_token": refreshToken,
+		"expires_in":    h.tokenIssuer.ExpiresIn(),
+	})
 }
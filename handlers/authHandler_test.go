@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/suraj/GoGoNotes/internal/testutil"
+	"github.com/suraj/GoGoNotes/middleware"
+	"github.com/suraj/GoGoNotes/models"
+	"github.com/suraj/GoGoNotes/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func testKeyring(t testing.TB) *utils.Keyring {
+	t.Helper()
+	key, err := utils.NewHMACKey("test", []byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring, err := utils.NewKeyring([]*utils.Key{key}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyring
+}
+
+// refreshAuthHandler wires an AuthHandler whose models all talk to the mock collection
+func refreshAuthHandler(mt *mtest.T, keyring *utils.Keyring) *AuthHandler {
+	userModel := models.NewUserModel(mt.Coll)
+	sessionModel := models.NewSessionModel(mt.Coll, time.Hour)
+	refreshTokenModel := models.NewRefreshTokenModel(mt.Coll, time.Hour)
+	tokenIssuer := NewTokenIssuer(userModel, sessionModel, refreshTokenModel, keyring, 15*time.Minute)
+	return &AuthHandler{
+		userModel:         userModel,
+		sessionModel:      sessionModel,
+		refreshTokenModel: refreshTokenModel,
+		tokenIssuer:       tokenIssuer,
+	}
+}
+
+func postRefresh(h *AuthHandler, refreshToken string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	r := httptest.NewRequest(http.MethodPost, "/refresh", strings.NewReader(`{"refresh_token":"`+refreshToken+`"}`))
+	w := httptest.NewRecorder()
+	h.Refresh(w, r)
+
+	var body map[string]interface{}
+	json.NewDecoder(w.Body).Decode(&body)
+	return w, body
+}
+
+func TestRefreshRotatesTokens(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("rotation", func(mt *mtest.T) {
+		keyring := testKeyring(mt)
+		h := refreshAuthHandler(mt, keyring)
+
+		user := models.User{ID: primitive.NewObjectID(), Email: "bob@example.com"}
+		familyID := primitive.NewObjectID()
+		session := models.Session{ID: familyID, UserID: user.ID, IP: "192.0.2.1", LastSeenAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)}
+		stored := models.RefreshToken{ID: primitive.NewObjectID(), UserID: user.ID, FamilyID: familyID, ExpiresAt: time.Now().Add(time.Hour)}
+
+		presented := "first"
+		seen := map[string]bool{}
+		for i := 0; i < 2; i++ {
+			// Look up and claim the refresh token, store its successor, check the session, load the user
+			mt.AddMockResponses(testutil.FindResponse(stored), testutil.UpdateResponse(1), mtest.CreateSuccessResponse(), testutil.FindResponse(session), testutil.FindResponse(user))
+
+			w, body := postRefresh(h, presented)
+			if w.Code != http.StatusOK {
+				mt.Fatalf("refresh %d: status %d: %v", i, w.Code, body)
+			}
+
+			next, _ := body["refresh_token"].(string)
+			if next == "" || next == presented {
+				mt.Errorf("refresh %d: refresh token was not rotated: %q", i, next)
+			}
+			presented = next
+
+			claims, err := utils.ParseToken(body["token"].(string), utils.TokenTypeAccess, keyring)
+			if err != nil {
+				mt.Fatalf("refresh %d: %v", i, err)
+			}
+			if seen[claims.TokenID] {
+				mt.Errorf("refresh %d: jti %s was issued before", i, claims.TokenID)
+			}
+			seen[claims.TokenID] = true
+			if claims.SessionID != familyID || claims.UserID != user.ID {
+				mt.Errorf("refresh %d: token is for session %s and user %s", i, claims.SessionID.Hex(), claims.UserID.Hex())
+			}
+		}
+	})
+
+	mt.Run("reuse", func(mt *mtest.T) {
+		h := refreshAuthHandler(mt, testKeyring(mt))
+
+		familyID := primitive.NewObjectID()
+		usedAt := time.Now().Add(-time.Minute)
+		stale := models.RefreshToken{ID: primitive.NewObjectID(), UserID: primitive.NewObjectID(), FamilyID: familyID, ExpiresAt: time.Now().Add(time.Hour), UsedAt: &usedAt}
+		mt.AddMockResponses(testutil.FindResponse(stale), testutil.UpdateResponse(2))
+
+		w, body := postRefresh(h, "stale")
+		if w.Code != http.StatusUnauthorized || body["message"] != models.ErrRefreshTokenReused.Error() {
+			mt.Fatalf("status %d: %v", w.Code, body)
+		}
+		if token, _ := body["token"].(string); token != "" {
+			mt.Error("no access token may be issued for a reused refresh token")
+		}
+
+		mt.GetStartedEvent()
+		revoke := mt.GetStartedEvent()
+		if revoke.CommandName != "update" || revoke.Command.Lookup("updates", "0", "q", "family_id").ObjectID() != familyID {
+			mt.Errorf("expected the family to be revoked, got %s", revoke.Command)
+		}
+	})
+}
+
+func TestLogoutEndsTheSession(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("logout", func(mt *mtest.T) {
+		h := refreshAuthHandler(mt, testKeyring(mt))
+		h.revokedTokenModel = models.NewRevokedTokenModel(mt.Coll)
+		mt.AddMockResponses(testutil.UpdateResponse(1), testutil.DeleteResponse(1), testutil.UpdateResponse(1))
+
+		userID := primitive.NewObjectID()
+		sessionID := primitive.NewObjectID()
+		expiresAt := time.Now().Add(10 * time.Minute).Truncate(time.Millisecond)
+		r := httptest.NewRequest(http.MethodPost, "/logout", nil)
+		r = r.WithContext(middleware.WithIdentity(r.Context(), &middleware.Identity{UserID: userID, TokenID: "jti", SessionID: sessionID, ExpiresAt: expiresAt}))
+		w := httptest.NewRecorder()
+		h.Logout(w, r)
+		if w.Code != http.StatusOK {
+			mt.Fatalf("status %d: %s", w.Code, w.Body)
+		}
+
+		revoke := mt.GetStartedEvent().Command
+		if revoke.Lookup("updates", "0", "q", "_id").StringValue() != "jti" || !revoke.Lookup("updates", "0", "u", "$setOnInsert", "expires_at").Time().Equal(expiresAt) {
+			mt.Errorf("the access token must stay revoked until it expires, got %s", revoke)
+		}
+		session := mt.GetStartedEvent()
+		if session.CommandName != "delete" || session.Command.Lookup("deletes", "0", "q", "_id").ObjectID() != sessionID {
+			mt.Errorf("expected the session to be deleted, got %s", session.Command)
+		}
+		family := mt.GetStartedEvent()
+		if family.CommandName != "update" || family.Command.Lookup("updates", "0", "q", "family_id").ObjectID() != sessionID {
+			mt.Errorf("expected the refresh tokens to be revoked, got %s", family.Command)
+		}
+	})
+}
@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/suraj/GoGoNotes/mailer"
+	"github.com/suraj/GoGoNotes/middleware"
+	"github.com/suraj/GoGoNotes/models"
+)
+
+type EmailVerificationHandler struct {
+	userModel      *models.UserModel
+	userTokenModel *models.UserTokenModel
+	mailer         mailer.Mailer
+	tokenTTL       time.Duration
+	appBaseURL     string
+}
+
+func NewEmailVerificationHandler(userModel *models.UserModel, userTokenModel *models.UserTokenModel, mailer mailer.Mailer, tokenTTL time.Duration, appBaseURL string) *EmailVerificationHandler {
+	return &EmailVerificationHandler{
+		userModel:      userModel,
+		userTokenModel: userTokenModel,
+		mailer:         mailer,
+		tokenTTL:       tokenTTL,
+		appBaseURL:     appBaseURL,
+	}
+}
+
+// VerifyEmail confirms an email address. The token comes from the ?token= query
+// parameter when the emailed link is opened, or from the JSON body for apps.
+func (h *EmailVerificationHandler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if r.Method == http.MethodPost {
+		var input struct {
+			Token string `json:"token"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&input); err == nil && input.Token != "" {
+			token = input.Token
+		}
+	}
+
+	if token == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "token is required",
+		})
+		return
+	}
+
+	userToken, err := h.userTokenModel.Consume(token, models.TokenPurposeEmailVerification, models.TokenPurposeEmailChange)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err == models.ErrUserTokenInvalid {
+			status = http.StatusBadRequest
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	// Email change links confirm the new address and switch the account over to it
+	if userToken.Purpose == models.TokenPurposeEmailChange {
+		err = h.userModel.UpdateEmail(userToken.UserID, userToken.Email)
+	} else {
+		err = h.userModel.MarkEmailVerified(userToken.UserID)
+	}
+	if err == models.ErrEmailInUse {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Failed to verify email: " + err.Error(),
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  true,
+		"message": "Email verified successfully",
+	})
+}
+
+// ResendVerification sends a fresh verification link to the logged in user
+func (h *EmailVerificationHandler) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserIDFromContext(r.Context())
+
+	user, err := h.userModel.GetByID(userID)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "User not found",
+		})
+		return
+	}
+
+	if user.EmailVerified {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Email is already verified",
+		})
+		return
+	}
+
+	if err := h.SendVerificationEmail(user); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Failed to send verification email: " + err.Error(),
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  true,
+		"message": "Verification email sent",
+	})
+}
+
+// SendVerificationEmail emails the user a link that confirms their address
+func (h *EmailVerificationHandler) SendVerificationEmail(user *models.User) error {
+	token, err := h.userTokenModel.Create(user.ID, models.TokenPurposeEmailVerification, h.tokenTTL)
+	if err != nil {
+		return err
+	}
+
+	link := h.appBaseURL + "/verify-email?token=" + url.QueryEscape(token)
+	body := fmt.Sprintf("Welcome to GoGoNotes!\n\n"+
+		"Please confirm your email address within %s by opening the link below:\n\n%s\n\n"+
+		"If you didn't create an account, you can ignore this email.", h.tokenTTL, link)
+
+	return h.mailer.Send(user.Email, "Confirm your GoGoNotes email address", body)
+}
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/suraj/GoGoNotes/internal/testutil"
+	"github.com/suraj/GoGoNotes/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestVerifyEmail(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	userID := primitive.NewObjectID()
+	consumed := func(purpose, email string) bson.D {
+		token := models.UserToken{ID: primitive.NewObjectID(), UserID: userID, Purpose: purpose, Email: email}
+		return mtest.CreateSuccessResponse(bson.E{Key: "value", Value: testutil.ToDoc(token)})
+	}
+	emailTaken := mtest.CreateWriteErrorsResponse(mtest.WriteError{Index: 0, Code: 11000, Message: "E11000 duplicate key error"})
+
+	tests := []struct {
+		name        string
+		request     func() *http.Request
+		responses   []bson.D
+		wantStatus  int
+		wantMessage string
+		wantSet     bson.M
+	}{
+		{
+			"link opened in a browser",
+			func() *http.Request { return httptest.NewRequest(http.MethodGet, "/verify-email?token=abc", nil) },
+			[]bson.D{consumed(models.TokenPurposeEmailVerification, ""), testutil.UpdateResponse(1)},
+			http.StatusOK, "Email verified successfully",
+			bson.M{"email_verified": true},
+		},
+		{
+			"token posted by an app",
+			func() *http.Request {
+				return httptest.NewRequest(http.MethodPost, "/verify-email", strings.NewReader(`{"token":"abc"}`))
+			},
+			[]bson.D{consumed(models.TokenPurposeEmailVerification, ""), testutil.UpdateResponse(1)},
+			http.StatusOK, "Email verified successfully",
+			bson.M{"email_verified": true},
+		},
+		{
+			"email change switches the address",
+			func() *http.Request { return httptest.NewRequest(http.MethodGet, "/verify-email?token=abc", nil) },
+			[]bson.D{consumed(models.TokenPurposeEmailChange, "new@example.com"), testutil.UpdateResponse(1)},
+			http.StatusOK, "Email verified successfully",
+			bson.M{"email": "new@example.com", "email_verified": true},
+		},
+		{
+			"new address taken in the meantime",
+			func() *http.Request { return httptest.NewRequest(http.MethodGet, "/verify-email?token=abc", nil) },
+			[]bson.D{consumed(models.TokenPurposeEmailChange, "new@example.com"), emailTaken},
+			http.StatusConflict, models.ErrEmailInUse.Error(),
+			nil,
+		},
+		{
+			"used or expired token",
+			func() *http.Request { return httptest.NewRequest(http.MethodGet, "/verify-email?token=abc", nil) },
+			[]bson.D{mtest.CreateSuccessResponse(bson.E{Key: "value", Value: nil})},
+			http.StatusBadRequest, models.ErrUserTokenInvalid.Error(),
+			nil,
+		},
+		{
+			"no token",
+			func() *http.Request { return httptest.NewRequest(http.MethodGet, "/verify-email", nil) },
+			nil,
+			http.StatusBadRequest, "token is required",
+			nil,
+		},
+	}
+
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			h := NewEmailVerificationHandler(models.NewUserModel(mt.Coll), models.NewUserTokenModel(mt.Coll), &sentMail{}, time.Hour, "https://api.example.com")
+			mt.AddMockResponses(tt.responses...)
+
+			w := httptest.NewRecorder()
+			h.VerifyEmail(w, tt.request())
+
+			var body map[string]interface{}
+			json.NewDecoder(w.Body).Decode(&body)
+			if w.Code != tt.wantStatus || body["message"] != tt.wantMessage {
+				mt.Fatalf("status %d: %v", w.Code, body)
+			}
+			if tt.wantSet == nil {
+				return
+			}
+
+			consume := mt.GetStartedEvent().Command
+			if purposes, _ := consume.Lookup("query", "purpose", "$in").Array().Values(); len(purposes) != 2 {
+				mt.Errorf("only verification and email change tokens may be used, got %v", purposes)
+			}
+			set := mt.GetStartedEvent().Command.Lookup("updates", "0", "u", "$set").Document()
+			for key, want := range tt.wantSet {
+				var got interface{}
+				switch value := set.Lookup(key); value.Type {
+				case bson.TypeBoolean:
+					got = value.Boolean()
+				case bson.TypeString:
+					got = value.StringValue()
+				}
+				if got != want {
+					mt.Errorf("%s = %v, want %v", key, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestResendVerificationToVerifiedUser(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("already verified", func(mt *mtest.T) {
+		mail := &sentMail{}
+		h := NewEmailVerificationHandler(models.NewUserModel(mt.Coll), models.NewUserTokenModel(mt.Coll), mail, time.Hour, "https://api.example.com")
+		user := models.User{ID: primitive.NewObjectID(), Email: "bob@example.com", EmailVerified: true}
+		mt.AddMockResponses(testutil.FindResponse(user))
+
+		w := httptest.NewRecorder()
+		h.ResendVerification(w, sessionRequest(http.MethodPost, "", user.ID, primitive.NewObjectID()))
+		if w.Code != http.StatusBadRequest || len(mail.to) != 0 {
+			mt.Errorf("status %d, sent %v", w.Code, mail.to)
+		}
+	})
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/suraj/GoGoNotes/models"
+)
+
+// noteETag is the strong entity tag of a note, its quoted version
+func noteETag(note *models.Note) string {
+	return fmt.Sprintf(`"%d"`, note.Version)
+}
+
+// ifMatchVersions parses the If-Match header into the note versions it allows.
+// It returns nil when there is no precondition (no header or "*"), and an empty
+// list when no listed tag can ever match, such as weak tags which If-Match never
+// accepts.
+func ifMatchVersions(r *http.Request) []int64 {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" || header == "*" {
+		return nil
+	}
+
+	versions := []int64{}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if !strings.HasPrefix(tag, `"`) || !strings.HasSuffix(tag, `"`) || len(tag) < 2 {
+			continue
+		}
+		if v, err := strconv.ParseInt(tag[1:len(tag)-1], 10, 64); err == nil {
+			versions = append(versions, v)
+		}
+	}
+	return versions
+}
+
+// ifNoneMatch reports whether the If-None-Match header lists etag, using the weak
+// comparison reads call for
+func ifNoneMatch(r *http.Request, etag string) bool {
+	header := strings.TrimSpace(r.Header.Get("If-None-Match"))
+	if header == "*" {
+		return true
+	}
+	for _, tag := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(tag), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
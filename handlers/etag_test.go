@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestIfMatchVersions(t *testing.T) {
+	tests := []struct {
+		header string
+		want   []int64
+	}{
+		{"", nil},
+		{"*", nil},
+		{` * `, nil},
+		{`"3"`, []int64{3}},
+		{`"1", "2"`, []int64{1, 2}},
+		{`"1","2" , "7"`, []int64{1, 2, 7}},
+		{`W/"3"`, []int64{}},
+		{`W/"3", "4"`, []int64{4}},
+		{`3`, []int64{}},
+		{`"`, []int64{}},
+		{`""`, []int64{}},
+		{`"abc"`, []int64{}},
+		{`"3`, []int64{}},
+		{`,`, []int64{}},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPut, "/notes/1", nil)
+		if tt.header != "" {
+			r.Header.Set("If-Match", tt.header)
+		}
+		if got := ifMatchVersions(r); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("If-Match %q: got %#v, want %#v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestIfNoneMatch(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{"*", true},
+		{`"3"`, true},
+		{`W/"3"`, true},
+		{`"1", "2"`, false},
+		{`"1", W/"3"`, true},
+		{`"4"`, false},
+		{`3`, false},
+		{`"3`, false},
+		{`"abc"`, false},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/notes/1", nil)
+		if tt.header != "" {
+			r.Header.Set("If-None-Match", tt.header)
+		}
+		if got := ifNoneMatch(r, `"3"`); got != tt.want {
+			t.Errorf("If-None-Match %q: got %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/suraj/GoGoNotes/utils"
+)
+
+type JWKSHandler struct {
+	keyring *utils.Keyring
+}
+
+func NewJWKSHandler(keyring *utils.Keyring) *JWKSHandler {
+	return &JWKSHandler{keyring: keyring}
+}
+
+// GetJWKS publishes the public signing keys so other services can verify our tokens.
+// The response is a plain RFC 7517 key set rather than our usual envelope, since
+// it is consumed by JWT libraries.
+func (h *JWKSHandler) GetJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"keys": h.keyring.PublicJWKs(),
+	})
+}
@@ -0,0 +1,366 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/suraj/GoGoNotes/middleware"
+	"github.com/suraj/GoGoNotes/models"
+	"github.com/suraj/GoGoNotes/utils"
+)
+
+type MFAHandler struct {
+	userModel         *models.UserModel
+	sessionModel      *models.SessionModel
+	revokedTokenModel *models.RevokedTokenModel
+	loginAttemptModel *models.LoginAttemptModel
+	tokenIssuer       *TokenIssuer
+	keyring           *utils.Keyring
+	issuer            string
+}
+
+func NewMFAHandler(userModel *models.UserModel, sessionModel *models.SessionModel, revokedTokenModel *models.RevokedTokenModel, loginAttemptModel *models.LoginAttemptModel, tokenIssuer *TokenIssuer, keyring *utils.Keyring, issuer string) *MFAHandler {
+	return &MFAHandler{
+		userModel:         userModel,
+		sessionModel:      sessionModel,
+		revokedTokenModel: revokedTokenModel,
+		loginAttemptModel: loginAttemptModel,
+		tokenIssuer:       tokenIssuer,
+		keyring:           keyring,
+		issuer:            issuer,
+	}
+}
+
+// EnrollTOTP generates a new TOTP secret. It isn't active until confirmed with a valid code.
+func (h *MFAHandler) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserIDFromContext(r.Context())
+
+	user, err := h.userModel.GetByID(userID)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "User not found",
+		})
+		return
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Failed to generate secret",
+		})
+		return
+	}
+
+	if err := h.userModel.SetPendingTOTPSecret(userID, secret); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":      true,
+		"message":     "Scan the QR code with your authenticator app, then confirm with a code",
+		"secret":      secret,
+		"otpauth_uri": utils.TOTPURI(h.issuer, user.Email, secret),
+	})
+}
+
+// ConfirmTOTP enables TOTP once the user proves their app produces valid codes, and returns the recovery codes
+func (h *MFAHandler) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserIDFromContext(r.Context())
+
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil || input.Code == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "code is required",
+		})
+		return
+	}
+
+	user, err := h.userModel.GetByID(userID)
+	if err != nil || user.TOTPSecret == "" || user.TOTPEnabled {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "No pending two-factor enrollment",
+		})
+		return
+	}
+
+	step, ok := utils.ValidateTOTP(user.TOTPSecret, input.Code, time.Now())
+	if !ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Invalid code",
+		})
+		return
+	}
+
+	recoveryCodes, err := h.userModel.EnableTOTP(userID, step)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Failed to enable two-factor authentication: " + err.Error(),
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":         true,
+		"message":        "Two-factor authentication enabled. Store these recovery codes somewhere safe, they won't be shown again",
+		"recovery_codes": recoveryCodes,
+	})
+}
+
+// DisableTOTP turns two-factor authentication off. It needs the password, or a recent sign-in
+// for accounts without one, and a current code or a recovery code.
+func (h *MFAHandler) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserIDFromContext(r.Context())
+
+	var input struct {
+		Password     string `json:"password"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	user, err := h.userModel.GetByID(userID)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Password is incorrect",
+		})
+		return
+	}
+	if ok, message := confirmIdentity(r, h.userModel, h.sessionModel, user, input.Password); !ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": message,
+		})
+		return
+	}
+
+	if !user.TOTPEnabled {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Two-factor authentication is not enabled",
+		})
+		return
+	}
+
+	if ok, err := h.verifySecondFactor(user, input.Code, input.RecoveryCode); err != nil || !ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Invalid code",
+		})
+		return
+	}
+
+	if err := h.userModel.DisableTOTP(userID); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Failed to disable two-factor authentication: " + err.Error(),
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  true,
+		"message": "Two-factor authentication disabled",
+	})
+}
+
+// VerifyLogin is the second login step: it exchanges the MFA challenge token from
+// /login plus a TOTP or recovery code for the real session tokens
+func (h *MFAHandler) VerifyLogin(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil || input.MFAToken == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "mfa_token is required",
+			"token":   "",
+		})
+		return
+	}
+
+	claims, err := utils.ParseToken(input.MFAToken, utils.TokenTypeMFA, h.keyring)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Invalid or expired MFA token, please log in again",
+			"token":   "",
+		})
+		return
+	}
+
+	revoked, err := h.revokedTokenModel.IsRevoked(claims.TokenID)
+	if err != nil || revoked {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Invalid or expired MFA token, please log in again",
+			"token":   "",
+		})
+		return
+	}
+
+	user, err := h.userModel.GetByID(claims.UserID)
+	if err != nil || !user.TOTPEnabled || user.Disabled || user.TokenGeneration != claims.Generation {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Invalid or expired MFA token, please log in again",
+			"token":   "",
+		})
+		return
+	}
+
+	// Wrong codes count towards the same lockout as wrong passwords
+	ip := utils.ClientIP(r)
+	lockedFor, err := h.loginAttemptModel.LockedFor(user.Email, ip)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": err.Error(),
+			"token":   "",
+		})
+		return
+	}
+	if lockedFor > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Retry-After", utils.RetryAfter(lockedFor))
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Too many failed login attempts, try again later or reset your password",
+			"token":   "",
+		})
+		return
+	}
+
+	if ok, err := h.verifySecondFactor(user, input.Code, input.RecoveryCode); err != nil || !ok {
+		if _, err := h.loginAttemptModel.RecordFailure(user.Email, ip); err != nil {
+			log.Printf("Failed to record login attempt: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Invalid code",
+			"token":   "",
+		})
+		return
+	}
+
+	if err := h.loginAttemptModel.Reset(user.Email); err != nil {
+		log.Printf("Failed to reset login attempts: %v", err)
+	}
+
+	// The challenge token is single use
+	if err := h.revokedTokenModel.Revoke(claims.TokenID, claims.UserID, claims.ExpiresAt); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": err.Error(),
+			"token":   "",
+		})
+		return
+	}
+
+	tokenString, refreshToken, err := h.tokenIssuer.StartSession(user, r)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Failed to generate token",
+			"token":   "",
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":        true,
+		"message":       "Login Successful",
+		"token":         tokenString,
+		"refresh_token": refreshToken,
+		"expires_in":    h.tokenIssuer.ExpiresIn(),
+	})
+}
+
+// verifySecondFactor accepts either a TOTP code (that hasn't been used before) or an unused recovery code
+func (h *MFAHandler) verifySecondFactor(user *models.User, code, recoveryCode string) (bool, error) {
+	if code != "" {
+		step, ok := utils.ValidateTOTP(user.TOTPSecret, code, time.Now())
+		if !ok {
+			return false, nil
+		}
+		return h.userModel.UseTOTPStep(user.ID, step)
+	}
+
+	if recoveryCode != "" {
+		return h.userModel.UseRecoveryCode(user.ID, recoveryCode)
+	}
+
+	return false, nil
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/suraj/GoGoNotes/internal/testutil"
+	"github.com/suraj/GoGoNotes/models"
+	"github.com/suraj/GoGoNotes/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestVerifySecondFactorRejectsReplayedCodes(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("replay", func(mt *mtest.T) {
+		secret, err := utils.GenerateTOTPSecret()
+		if err != nil {
+			mt.Fatal(err)
+		}
+		user := &models.User{ID: primitive.NewObjectID(), TOTPSecret: secret}
+		h := &MFAHandler{userModel: models.NewUserModel(mt.Coll)}
+		code := currentTOTPCode(mt, secret)
+
+		// The first use records the step; a second use of the same code finds the
+		// step already taken, so the conditional update matches nothing
+		mt.AddMockResponses(testutil.UpdateResponse(1), testutil.UpdateResponse(0))
+
+		if ok, err := h.verifySecondFactor(user, code, ""); err != nil || !ok {
+			mt.Fatalf("first use = %t, %v, want accepted", ok, err)
+		}
+		step := mt.GetStartedEvent().Command.Lookup("updates", "0", "q", "totp_last_step", "$lt").Int64()
+		if want := time.Now().Unix() / 30; step < want-1 || step > want {
+			mt.Errorf("recorded step %d, want about %d", step, want)
+		}
+
+		if ok, err := h.verifySecondFactor(user, code, ""); err != nil || ok {
+			mt.Errorf("replay = %t, %v, want rejected", ok, err)
+		}
+	})
+
+	mt.Run("wrong code", func(mt *mtest.T) {
+		secret, err := utils.GenerateTOTPSecret()
+		if err != nil {
+			mt.Fatal(err)
+		}
+		user := &models.User{ID: primitive.NewObjectID(), TOTPSecret: secret}
+		h := &MFAHandler{userModel: models.NewUserModel(mt.Coll)}
+
+		if ok, err := h.verifySecondFactor(user, "000000x", ""); err != nil || ok {
+			mt.Errorf("malformed code = %t, %v, want rejected", ok, err)
+		}
+		if mt.GetStartedEvent() != nil {
+			mt.Error("an invalid code should not touch the database")
+		}
+	})
+}
+
+func TestDisableTOTPWithoutPassword(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := models.User{ID: primitive.NewObjectID(), Email: "bob@example.com", EmailVerified: true, TOTPEnabled: true, TOTPSecret: secret}
+	sessionID := primitive.NewObjectID()
+	session := func(createdAt time.Time) models.Session {
+		return models.Session{ID: sessionID, UserID: user.ID, CreatedAt: createdAt, LastSeenAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)}
+	}
+	disable := func(h *MFAHandler) (*httptest.ResponseRecorder, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		h.DisableTOTP(w, sessionRequest(http.MethodDelete, `{"code":"`+currentTOTPCode(mt, secret)+`"}`, user.ID, sessionID))
+		var body map[string]interface{}
+		json.NewDecoder(w.Body).Decode(&body)
+		return w, body
+	}
+
+	mt.Run("after a recent sign-in", func(mt *mtest.T) {
+		h := &MFAHandler{userModel: models.NewUserModel(mt.Coll), sessionModel: models.NewSessionModel(mt.Coll, time.Hour)}
+		mt.AddMockResponses(testutil.FindResponse(user), testutil.FindResponse(session(time.Now().Add(-time.Minute))), testutil.UpdateResponse(1), testutil.UpdateResponse(1))
+
+		if w, body := disable(h); w.Code != http.StatusOK {
+			mt.Fatalf("status %d: %v", w.Code, body)
+		}
+		for range 3 {
+			mt.GetStartedEvent()
+		}
+		if unset := mt.GetStartedEvent().Command.Lookup("updates", "0", "u", "$unset", "totp_secret"); unset.IsZero() {
+			mt.Error("the TOTP secret was not removed")
+		}
+	})
+
+	mt.Run("with an old session", func(mt *mtest.T) {
+		h := &MFAHandler{userModel: models.NewUserModel(mt.Coll), sessionModel: models.NewSessionModel(mt.Coll, time.Hour)}
+		mt.AddMockResponses(testutil.FindResponse(user), testutil.FindResponse(session(time.Now().Add(-time.Hour))))
+
+		if w, body := disable(h); w.Code != http.StatusUnauthorized || body["message"] != reauthMessage {
+			mt.Errorf("status %d: %v", w.Code, body)
+		}
+	})
+}
+
+// currentTOTPCode computes the code an authenticator app would show for secret right now (RFC 6238)
+func currentTOTPCode(mt *mtest.T, secret string) string {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		mt.Fatal(err)
+	}
+
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(time.Now().Unix()/30))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", value%1000000)
+}
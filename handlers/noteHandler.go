@@ -1,45 +1,75 @@
 package handlers
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
 	"net/http"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/suraj/GoGoNotes/middleware"
 	"github.com/suraj/GoGoNotes/models"
 	"github.com/suraj/GoGoNotes/utils"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type NoteHandler struct {
-	model     *models.NoteModel
-	SecretKey []byte
+	model         *models.NoteModel
+	notebookModel *models.NotebookModel
 }
 
-func NewNoteHandler(noteModel *models.NoteModel, secretKey []byte) *NoteHandler {
+func NewNoteHandler(noteModel *models.NoteModel, notebookModel *models.NotebookModel) *NoteHandler {
 	return &NoteHandler{
-		model:     noteModel,
-		SecretKey: secretKey,
+		model:         noteModel,
+		notebookModel: notebookModel,
 	}
 }
 
 func (h *NoteHandler) CreateNote(w http.ResponseWriter, r *http.Request) {
-	userID, err := utils.ExtractUserIDFromToken(r)
-	if err != nil {
+	userID := middleware.UserIDFromContext(r.Context())
+
+	var input struct {
+		Title      string              `json:"title"`
+		Body       string              `json:"body"`
+		Tags       []string            `json:"tags"`
+		NotebookID *primitive.ObjectID `json:"notebook_id"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
 		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusUnauthorized)
+		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"status":  false,
-			"message": "Unauthorized: " + err.Error(),
+			"message": err.Error(),
 		})
 		return
 	}
 
-	var input struct {
-		Title string `json:"title"`
-		Body  string `json:"body"`
+	if input.NotebookID != nil {
+		if _, err := h.notebookModel.GetByID(*input.NotebookID, userID); err != nil {
+			status := http.StatusInternalServerError
+			if err == models.ErrNotebookNotFound {
+				status = http.StatusNotFound
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"status":  false,
+				"message": err.Error(),
+			})
+			return
+		}
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+	tags, err := models.NormalizeTags(input.Tags)
+	if err != nil {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(map[string]interface{}{
@@ -49,7 +79,7 @@ func (h *NoteHandler) CreateNote(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	note, err := h.model.Create(userID, input.Title, input.Body)
+	note, err := h.model.Create(userID, input.Title, input.Body, tags, input.NotebookID)
 	if err != nil {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusInternalServerError)
@@ -61,6 +91,7 @@ func (h *NoteHandler) CreateNote(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", noteETag(note))
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"status":  true,
 		"message": "Note created successfully",
@@ -68,20 +99,65 @@ func (h *NoteHandler) CreateNote(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// notePageSize and noteMaxPageSize control the pagination of GET /notes
+const (
+	notePageSize    = 20
+	noteMaxPageSize = 100
+)
+
+// GetAllNotes returns a page of notes. Supported query parameters:
+//
+//	limit                          page size, 20 by default and at most 100
+//	cursor                         next_cursor from the previous page
+//	sort                           created_at, updated_at (default) or title
+//	order                          asc or desc (default)
+//	created_after, created_before  RFC 3339 timestamps, after is inclusive and before exclusive
+//	updated_after, updated_before
+//	tags                           comma-separated tags
+//	tag_mode                       any (default) or all of the tags
+//	notebook_id                    a notebook's ID, or "none" for notes outside any notebook
+//	include_subnotebooks           true to also list the notes of the notebook's sub-notebooks
 func (h *NoteHandler) GetAllNotes(w http.ResponseWriter, r *http.Request) {
-	userID, err := utils.ExtractUserIDFromToken(r)
+	userID := middleware.UserIDFromContext(r.Context())
+
+	opts, err := parseNoteListOptions(r)
 	if err != nil {
 		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusUnauthorized)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": err.Error(),
+			"notes":   []interface{}{},
+		})
+		return
+	}
+
+	if err := h.applyNotebookFilter(r, userID, &opts); err != nil {
+		status := http.StatusBadRequest
+		if err == models.ErrNotebookNotFound {
+			status = http.StatusNotFound
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"status":  false,
-			"message": "Unauthorized: " + err.Error(),
+			"message": err.Error(),
 			"notes":   []interface{}{},
 		})
 		return
 	}
 
-	notes, err := h.model.GetAll(userID)
+	notes, nextCursor, err := h.model.List(userID, opts)
+	if err == models.ErrInvalidCursor {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": err.Error(),
+			"notes":   []interface{}{},
+		})
+		return
+	}
 	if err != nil {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusInternalServerError)
@@ -93,30 +169,197 @@ func (h *NoteHandler) GetAllNotes(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if notes == nil {
-		notes = []models.Note{}
-	}
-
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"status":  true,
-		"message": "Notes fetched successfully",
-		"notes":   notes,
+		"status":      true,
+		"message":     "Notes fetched successfully",
+		"notes":       notes,
+		"next_cursor": nextCursor,
 	})
 }
 
-func (h *NoteHandler) GetNote(w http.ResponseWriter, r *http.Request) {
-	userID, err := utils.ExtractUserIDFromToken(r)
+func parseNoteListOptions(r *http.Request) (models.NoteListOptions, error) {
+	query := r.URL.Query()
+	opts := models.NoteListOptions{
+		SortField: query.Get("sort"),
+		Cursor:    query.Get("cursor"),
+	}
+
+	limit, err := utils.QueryInt(r, "limit", notePageSize, noteMaxPageSize)
+	if err != nil {
+		return opts, err
+	}
+	opts.Limit = limit
+
+	if opts.SortField != "" && !models.IsValidNoteSortField(opts.SortField) {
+		return opts, errors.New("sort must be created_at, updated_at or title")
+	}
+
+	switch query.Get("order") {
+	case "", "desc":
+	case "asc":
+		opts.Ascending = true
+	default:
+		return opts, errors.New("order must be asc or desc")
+	}
+
+	dates := map[string]**time.Time{
+		"created_after":  &opts.CreatedAfter,
+		"created_before": &opts.CreatedBefore,
+		"updated_after":  &opts.UpdatedAfter,
+		"updated_before": &opts.UpdatedBefore,
+	}
+	for key, target := range dates {
+		value := query.Get(key)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return opts, fmt.Errorf("%s must be an RFC 3339 timestamp", key)
+		}
+		*target = &t
+	}
+
+	if value := query.Get("tags"); value != "" {
+		tags, err := models.NormalizeTags(strings.Split(value, ","))
+		if err != nil {
+			return opts, err
+		}
+		opts.Tags = tags
+	}
+
+	switch query.Get("tag_mode") {
+	case "", "any":
+	case "all":
+		opts.MatchAllTags = true
+	default:
+		return opts, errors.New("tag_mode must be any or all")
+	}
+
+	return opts, nil
+}
+
+// applyNotebookFilter restricts opts to the notebook in ?notebook_id=, and its
+// sub-notebooks with ?include_subnotebooks=true
+func (h *NoteHandler) applyNotebookFilter(r *http.Request, userID primitive.ObjectID, opts *models.NoteListOptions) error {
+	query := r.URL.Query()
+	value := query.Get("notebook_id")
+	if value == "" {
+		return nil
+	}
+	if value == "none" {
+		opts.Unfiled = true
+		return nil
+	}
+
+	notebookID, err := primitive.ObjectIDFromHex(value)
 	if err != nil {
+		return errors.New("Invalid notebook ID")
+	}
+	if _, err := h.notebookModel.GetByID(notebookID, userID); err != nil {
+		return err
+	}
+
+	if query.Get("include_subnotebooks") != "true" {
+		opts.NotebookIDs = []primitive.ObjectID{notebookID}
+		return nil
+	}
+
+	ids, err := h.notebookModel.SubtreeIDs(notebookID, userID)
+	if err != nil {
+		return err
+	}
+	opts.NotebookIDs = ids
+	return nil
+}
+
+// searchPageSize, searchMaxPageSize and snippetLength shape the results of GET /notes/search
+const (
+	searchPageSize    = 20
+	searchMaxPageSize = 50
+	snippetLength     = 160
+)
+
+// SearchNotes finds notes by text. ?q= takes words, "exact phrases" and -excluded
+// words; ?page= and ?limit= page through the results, which come best match first.
+// Each result carries HTML-escaped title and body snippets with the matches in <mark>.
+func (h *NoteHandler) SearchNotes(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserIDFromContext(r.Context())
+
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	terms := utils.SearchTerms(query)
+	if len(terms) == 0 {
 		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusUnauthorized)
+		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"status":  false,
-			"message": "Unauthorized: " + err.Error(),
+			"message": "q must contain at least one word or phrase to search for",
+			"results": []interface{}{},
 		})
 		return
 	}
 
+	page, err := utils.QueryInt(r, "page", 1, math.MaxInt32)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": err.Error(),
+			"results": []interface{}{},
+		})
+		return
+	}
+	limit, err := utils.QueryInt(r, "limit", searchPageSize, searchMaxPageSize)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": err.Error(),
+			"results": []interface{}{},
+		})
+		return
+	}
+
+	notes, err := h.model.Search(userID, query, page, limit)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Failed to search notes: " + err.Error(),
+			"results": []interface{}{},
+		})
+		return
+	}
+
+	results := make([]map[string]interface{}, len(notes))
+	for i, note := range notes {
+		results[i] = map[string]interface{}{
+			"note":  note.Note,
+			"score": note.Score,
+			"highlights": map[string]string{
+				"title": utils.Highlight(note.Title, terms, 0),
+				"body":  utils.Highlight(note.Body, terms, snippetLength),
+			},
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  true,
+		"message": "Search completed successfully",
+		"results": results,
+		"page":    page,
+		"limit":   limit,
+	})
+}
+
+func (h *NoteHandler) GetNote(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserIDFromContext(r.Context())
+
 	params := mux.Vars(r)
 	noteID, err := primitive.ObjectIDFromHex(params["id"])
 	if err != nil {
@@ -140,6 +383,14 @@ func (h *NoteHandler) GetNote(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Clients that already have this version get an empty 304
+	etag := noteETag(note)
+	w.Header().Set("ETag", etag)
+	if ifNoneMatch(r, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"status":  true,
@@ -148,17 +399,10 @@ func (h *NoteHandler) GetNote(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// UpdateNote replaces a note. Sending the note's ETag in If-Match makes the update
+// fail with 412 when someone else changed the note in the meantime.
 func (h *NoteHandler) UpdateNote(w http.ResponseWriter, r *http.Request) {
-	userID, err := utils.ExtractUserIDFromToken(r)
-	if err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"status":  false,
-			"message": "Unauthorized: " + err.Error(),
-		})
-		return
-	}
+	userID := middleware.UserIDFromContext(r.Context())
 
 	params := mux.Vars(r)
 	noteID, err := primitive.ObjectIDFromHex(params["id"])
@@ -173,8 +417,9 @@ func (h *NoteHandler) UpdateNote(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var input struct {
-		Title string `json:"title"`
-		Body  string `json:"body"`
+		Title string   `json:"title"`
+		Body  string   `json:"body"`
+		Tags  []string `json:"tags"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
@@ -187,10 +432,25 @@ func (h *NoteHandler) UpdateNote(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	note, err := h.model.Update(noteID, userID, input.Title, input.Body)
+	// Leaving tags out keeps the note's tags, an empty list clears them
+	var tags []string
+	if input.Tags != nil {
+		tags, err = models.NormalizeTags(input.Tags)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"status":  false,
+				"message": err.Error(),
+			})
+			return
+		}
+	}
+
+	note, err := h.model.Update(noteID, userID, input.Title, input.Body, tags, ifMatchVersions(r))
 	if err != nil {
 		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(noteErrorStatus(err))
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"status":  false,
 			"message": "Failed to update note: " + err.Error(),
@@ -199,6 +459,7 @@ func (h *NoteHandler) UpdateNote(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", noteETag(note))
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"status":  true,
 		"message": "Note updated successfully",
@@ -206,18 +467,206 @@ func (h *NoteHandler) UpdateNote(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func (h *NoteHandler) DeleteNote(w http.ResponseWriter, r *http.Request) {
-	userID, err := utils.ExtractUserIDFromToken(r)
+// Content types PatchNote accepts, also advertised in Accept-Patch
+const (
+	mergePatchContentType = "application/merge-patch+json"
+	jsonPatchContentType  = "application/json-patch+json"
+)
+
+// maxPatchBytes bounds the size of a PatchNote body
+const maxPatchBytes = 1 << 20
+
+// patchAttempts is how often PatchNote reapplies a patch when the note changes
+// between reading and writing it
+const patchAttempts = 3
+
+// PatchNote partially updates a note's title, body and tags. The body is a JSON
+// Merge Patch (RFC 7396) or a JSON Patch (RFC 6902) depending on Content-Type,
+// applied to {"title": ..., "body": ..., "tags": [...]}; only the fields it changes
+// are written. If-Match works like in UpdateNote.
+func (h *NoteHandler) PatchNote(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserIDFromContext(r.Context())
+
+	params := mux.Vars(r)
+	noteID, err := primitive.ObjectIDFromHex(params["id"])
 	if err != nil {
 		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusUnauthorized)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Invalid note ID",
+		})
+		return
+	}
+
+	var apply func(doc, patch []byte) ([]byte, error)
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case mergePatchContentType:
+		apply = utils.ApplyMergePatch
+	case jsonPatchContentType:
+		apply = utils.ApplyJSONPatch
+	default:
+		w.Header().Set("Accept-Patch", mergePatchContentType+", "+jsonPatchContentType)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnsupportedMediaType)
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"status":  false,
-			"message": "Unauthorized: " + err.Error(),
+			"message": "Content-Type must be " + mergePatchContentType + " or " + jsonPatchContentType,
 		})
 		return
 	}
 
+	patch, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPatchBytes))
+	if err != nil {
+		status := http.StatusBadRequest
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	versions := ifMatchVersions(r)
+	for attempt := 1; ; attempt++ {
+		note, err := h.model.GetByID(noteID, userID)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(noteErrorStatus(err))
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"status":  false,
+				"message": err.Error(),
+			})
+			return
+		}
+
+		if versions != nil && !slices.Contains(versions, note.Version) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusPreconditionFailed)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"status":  false,
+				"message": models.ErrVersionMismatch.Error(),
+			})
+			return
+		}
+
+		changes, changed, err := patchNote(note, patch, apply)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"status":  false,
+				"message": err.Error(),
+			})
+			return
+		}
+
+		// The patch was applied to this version, so it may only be written over this version
+		if changed {
+			note, err = h.model.Patch(noteID, userID, changes, []int64{note.Version})
+			if err == models.ErrVersionMismatch && versions == nil && attempt < patchAttempts {
+				continue
+			}
+			if err != nil {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(noteErrorStatus(err))
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"status":  false,
+					"message": "Failed to update note: " + err.Error(),
+				})
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("ETag", noteETag(note))
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  true,
+			"message": "Note updated successfully",
+			"note":    note,
+		})
+		return
+	}
+}
+
+// patchNote applies patch to the note's editable fields, validates the result and
+// returns the fields that changed
+func patchNote(note *models.Note, patch []byte, apply func(doc, patch []byte) ([]byte, error)) (models.NotePatch, bool, error) {
+	var changes models.NotePatch
+
+	current := note.Tags
+	if current == nil {
+		current = []string{}
+	}
+	doc, err := json.Marshal(map[string]interface{}{
+		"title": note.Title,
+		"body":  note.Body,
+		"tags":  current,
+	})
+	if err != nil {
+		return changes, false, err
+	}
+
+	patched, err := apply(doc, patch)
+	if err != nil {
+		return changes, false, err
+	}
+
+	if !bytes.HasPrefix(patched, []byte("{")) {
+		return changes, false, errors.New("the patched note must be a JSON object")
+	}
+
+	// Fields removed by the patch are cleared; anything else is rejected
+	var result struct {
+		Title *string   `json:"title"`
+		Body  *string   `json:"body"`
+		Tags  *[]string `json:"tags"`
+	}
+	decoder := json.NewDecoder(bytes.NewReader(patched))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&result); err != nil {
+		return changes, false, fmt.Errorf("invalid patched note: %v", err)
+	}
+
+	var title, body string
+	var tags []string
+	if result.Title != nil {
+		title = *result.Title
+	}
+	if result.Body != nil {
+		body = *result.Body
+	}
+	if result.Tags != nil {
+		tags = *result.Tags
+	}
+	if tags, err = models.NormalizeTags(tags); err != nil {
+		return changes, false, err
+	}
+
+	changed := false
+	if title != note.Title {
+		changes.Title, changed = &title, true
+	}
+	if body != note.Body {
+		changes.Body, changed = &body, true
+	}
+	if !slices.Equal(tags, current) {
+		changes.Tags, changed = tags, true
+	}
+	return changes, changed, nil
+}
+
+// MoveNote puts the note into {"notebook_id": "..."}, or takes it out of its
+// notebook when notebook_id is null
+func (h *NoteHandler) MoveNote(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserIDFromContext(r.Context())
+
 	params := mux.Vars(r)
 	noteID, err := primitive.ObjectIDFromHex(params["id"])
 	if err != nil {
@@ -230,10 +679,76 @@ func (h *NoteHandler) DeleteNote(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = h.model.Delete(noteID, userID)
+	var input struct {
+		NotebookID *primitive.ObjectID `json:"notebook_id"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	if input.NotebookID != nil {
+		if _, err := h.notebookModel.GetByID(*input.NotebookID, userID); err != nil {
+			status := http.StatusInternalServerError
+			if err == models.ErrNotebookNotFound {
+				status = http.StatusNotFound
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"status":  false,
+				"message": err.Error(),
+			})
+			return
+		}
+	}
+
+	note, err := h.model.Move(noteID, userID, input.NotebookID)
 	if err != nil {
 		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Note not found",
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", noteETag(note))
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  true,
+		"message": "Note moved successfully",
+		"note":    note,
+	})
+}
+
+// DeleteNote moves the note to the trash, see TrashHandler. If-Match works like in UpdateNote.
+func (h *NoteHandler) DeleteNote(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserIDFromContext(r.Context())
+
+	params := mux.Vars(r)
+	noteID, err := primitive.ObjectIDFromHex(params["id"])
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Invalid note ID",
+		})
+		return
+	}
+
+	err = h.model.Delete(noteID, userID, ifMatchVersions(r))
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(noteErrorStatus(err))
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"status":  false,
 			"message": "Failed to delete note: " + err.Error(),
@@ -244,6 +759,17 @@ func (h *NoteHandler) DeleteNote(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"status":  true,
-		"message": "Note deleted successfully",
+		"message": "Note moved to trash",
 	})
 }
+
+func noteErrorStatus(err error) int {
+	switch err {
+	case models.ErrNoteNotFound, models.ErrNoteNotInTrash:
+		return http.StatusNotFound
+	case models.ErrVersionMismatch:
+		return http.StatusPreconditionFailed
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/suraj/GoGoNotes/internal/testutil"
+	"github.com/suraj/GoGoNotes/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func patchRequest(noteID primitive.ObjectID, contentType, body, ifMatch string) *http.Request {
+	r := httptest.NewRequest(http.MethodPatch, "/notes/"+noteID.Hex(), strings.NewReader(body))
+	r.Header.Set("Content-Type", contentType)
+	if ifMatch != "" {
+		r.Header.Set("If-Match", ifMatch)
+	}
+	return mux.SetURLVars(r, map[string]string{"id": noteID.Hex()})
+}
+
+// failedSaveResponses are the replies to a NoteModel.Patch that loses the race
+// against a concurrent write: the note is read at the expected version, the
+// conditional update matches nothing and the note turns out to be at a newer one
+func failedSaveResponses(expected, current models.Note) []bson.D {
+	return []bson.D{
+		testutil.FindResponse(expected),
+		mtest.CreateCursorResponse(0, "test.note_revisions", mtest.FirstBatch, bson.D{{Key: "n", Value: 1}}),
+		mtest.CreateSuccessResponse(bson.E{Key: "value", Value: nil}),
+		testutil.FindResponse(current),
+	}
+}
+
+// savedResponses are the replies to a NoteModel.Patch that writes saved
+func savedResponses(before, saved models.Note) []bson.D {
+	return []bson.D{
+		testutil.FindResponse(before),
+		mtest.CreateCursorResponse(0, "test.note_revisions", mtest.FirstBatch, bson.D{{Key: "n", Value: 1}}),
+		mtest.CreateSuccessResponse(bson.E{Key: "value", Value: testutil.ToDoc(saved)}),
+		mtest.CreateSuccessResponse(),
+	}
+}
+
+func TestPatchNoteConcurrency(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	noteID := primitive.NewObjectID()
+	note := func(version int64, title string) models.Note {
+		return models.Note{ID: noteID, Title: title, Body: "body", Tags: []string{}, Version: version, UpdatedAt: time.Now()}
+	}
+	const mergePatch = `{"title":"patched"}`
+
+	mt.Run("reapplies the patch when the note changed", func(mt *mtest.T) {
+		h := NewNoteHandler(models.NewNoteModel(mt.Coll, mt.Coll, mt.Coll, 0), nil)
+
+		mt.AddMockResponses(testutil.FindResponse(note(3, "old")))
+		mt.AddMockResponses(failedSaveResponses(note(3, "old"), note(4, "renamed elsewhere"))...)
+		mt.AddMockResponses(testutil.FindResponse(note(4, "renamed elsewhere")))
+		mt.AddMockResponses(savedResponses(note(4, "renamed elsewhere"), note(5, "patched"))...)
+
+		w := httptest.NewRecorder()
+		h.PatchNote(w, patchRequest(noteID, mergePatchContentType, mergePatch, ""))
+
+		if w.Code != http.StatusOK {
+			mt.Fatalf("status %d: %s", w.Code, w.Body)
+		}
+		if etag := w.Header().Get("ETag"); etag != `"5"` {
+			mt.Errorf("ETag = %s, want \"5\"", etag)
+		}
+
+		// The second write must be conditional on the version the patch was reapplied to
+		var writes []int64
+		for event := mt.GetStartedEvent(); event != nil; event = mt.GetStartedEvent() {
+			if event.CommandName == "findAndModify" {
+				writes = append(writes, event.Command.Lookup("query", "version", "$in", "0").Int64())
+			}
+		}
+		if len(writes) != 2 || writes[0] != 3 || writes[1] != 4 {
+			mt.Errorf("conditional writes on versions %v, want [3 4]", writes)
+		}
+	})
+
+	mt.Run("gives up after a few attempts", func(mt *mtest.T) {
+		h := NewNoteHandler(models.NewNoteModel(mt.Coll, mt.Coll, mt.Coll, 0), nil)
+
+		for v := int64(1); v <= patchAttempts; v++ {
+			mt.AddMockResponses(testutil.FindResponse(note(v, "old")))
+			mt.AddMockResponses(failedSaveResponses(note(v, "old"), note(v+1, "old"))...)
+		}
+
+		w := httptest.NewRecorder()
+		h.PatchNote(w, patchRequest(noteID, mergePatchContentType, mergePatch, ""))
+
+		if w.Code != http.StatusPreconditionFailed {
+			mt.Errorf("status %d: %s", w.Code, w.Body)
+		}
+	})
+
+	mt.Run("If-Match on an older version", func(mt *mtest.T) {
+		h := NewNoteHandler(models.NewNoteModel(mt.Coll, mt.Coll, mt.Coll, 0), nil)
+		mt.AddMockResponses(testutil.FindResponse(note(4, "old")))
+
+		w := httptest.NewRecorder()
+		h.PatchNote(w, patchRequest(noteID, jsonPatchContentType, `[{"op":"replace","path":"/title","value":"patched"}]`, `"3"`))
+
+		if w.Code != http.StatusPreconditionFailed {
+			mt.Fatalf("status %d: %s", w.Code, w.Body)
+		}
+		mt.GetStartedEvent()
+		if event := mt.GetStartedEvent(); event != nil {
+			mt.Errorf("nothing should be written, got %s", event.CommandName)
+		}
+	})
+
+	mt.Run("If-Match does not retry", func(mt *mtest.T) {
+		h := NewNoteHandler(models.NewNoteModel(mt.Coll, mt.Coll, mt.Coll, 0), nil)
+		mt.AddMockResponses(testutil.FindResponse(note(3, "old")))
+		mt.AddMockResponses(failedSaveResponses(note(3, "old"), note(4, "renamed elsewhere"))...)
+
+		w := httptest.NewRecorder()
+		h.PatchNote(w, patchRequest(noteID, mergePatchContentType, mergePatch, `"3"`))
+
+		if w.Code != http.StatusPreconditionFailed {
+			mt.Fatalf("status %d: %s", w.Code, w.Body)
+		}
+		var body map[string]interface{}
+		json.NewDecoder(w.Body).Decode(&body)
+		if !strings.Contains(body["message"].(string), models.ErrVersionMismatch.Error()) {
+			mt.Errorf("message = %v", body["message"])
+		}
+	})
+
+	mt.Run("body too large", func(mt *mtest.T) {
+		h := NewNoteHandler(models.NewNoteModel(mt.Coll, mt.Coll, mt.Coll, 0), nil)
+		huge := `{"body":"` + strings.Repeat("a", maxPatchBytes) + `"}`
+
+		w := httptest.NewRecorder()
+		h.PatchNote(w, patchRequest(noteID, mergePatchContentType, huge, ""))
+
+		if w.Code != http.StatusRequestEntityTooLarge {
+			mt.Errorf("status %d, want 413", w.Code)
+		}
+		if event := mt.GetStartedEvent(); event != nil {
+			mt.Errorf("an oversized patch must not be applied, got %s", event.CommandName)
+		}
+	})
+
+	mt.Run("unsupported media type", func(mt *mtest.T) {
+		h := NewNoteHandler(models.NewNoteModel(mt.Coll, mt.Coll, mt.Coll, 0), nil)
+
+		w := httptest.NewRecorder()
+		h.PatchNote(w, patchRequest(noteID, "application/json", mergePatch, ""))
+
+		if w.Code != http.StatusUnsupportedMediaType || w.Header().Get("Accept-Patch") == "" {
+			mt.Errorf("status %d, Accept-Patch %q", w.Code, w.Header().Get("Accept-Patch"))
+		}
+	})
+}
@@ -0,0 +1,262 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/suraj/GoGoNotes/middleware"
+	"github.com/suraj/GoGoNotes/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// NotebookHandler manages the folders notes are organized in
+type NotebookHandler struct {
+	model *models.NotebookModel
+}
+
+func NewNotebookHandler(notebookModel *models.NotebookModel) *NotebookHandler {
+	return &NotebookHandler{
+		model: notebookModel,
+	}
+}
+
+// notebookInput is the body of creating and updating a notebook. A null or missing
+// parent_id puts the notebook at the top level.
+type notebookInput struct {
+	Name     string              `json:"name"`
+	ParentID *primitive.ObjectID `json:"parent_id"`
+}
+
+func (h *NotebookHandler) GetAllNotebooks(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserIDFromContext(r.Context())
+
+	notebooks, err := h.model.GetAll(userID)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":    false,
+			"message":   "Failed to fetch notebooks: " + err.Error(),
+			"notebooks": []interface{}{},
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":    true,
+		"message":   "Notebooks fetched successfully",
+		"notebooks": notebooks,
+	})
+}
+
+func (h *NotebookHandler) CreateNotebook(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserIDFromContext(r.Context())
+
+	var input notebookInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	name, err := models.NormalizeNotebookName(input.Name)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	notebook, err := h.model.Create(userID, name, input.ParentID)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(notebookErrorStatus(err))
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":   true,
+		"message":  "Notebook created successfully",
+		"notebook": notebook,
+	})
+}
+
+func (h *NotebookHandler) GetNotebook(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserIDFromContext(r.Context())
+
+	params := mux.Vars(r)
+	notebookID, err := primitive.ObjectIDFromHex(params["id"])
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Invalid notebook ID",
+		})
+		return
+	}
+
+	notebook, err := h.model.GetByID(notebookID, userID)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(notebookErrorStatus(err))
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	noteCount, err := h.model.CountNotes(notebookID, userID)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":     true,
+		"message":    "Notebook fetched successfully",
+		"notebook":   notebook,
+		"note_count": noteCount,
+	})
+}
+
+// UpdateNotebook renames a notebook and moves it, with everything in it, under parent_id
+func (h *NotebookHandler) UpdateNotebook(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserIDFromContext(r.Context())
+
+	params := mux.Vars(r)
+	notebookID, err := primitive.ObjectIDFromHex(params["id"])
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Invalid notebook ID",
+		})
+		return
+	}
+
+	var input notebookInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	name, err := models.NormalizeNotebookName(input.Name)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	notebook, err := h.model.Update(notebookID, userID, name, input.ParentID)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(notebookErrorStatus(err))
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":   true,
+		"message":  "Notebook updated successfully",
+		"notebook": notebook,
+	})
+}
+
+// DeleteNotebook deletes a notebook. ?mode=move (the default) moves its notes and
+// sub-notebooks up to its parent; ?mode=cascade deletes them along with it.
+func (h *NotebookHandler) DeleteNotebook(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserIDFromContext(r.Context())
+
+	params := mux.Vars(r)
+	notebookID, err := primitive.ObjectIDFromHex(params["id"])
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Invalid notebook ID",
+		})
+		return
+	}
+
+	var cascade bool
+	switch r.URL.Query().Get("mode") {
+	case "", "move":
+	case "cascade":
+		cascade = true
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "mode must be move or cascade",
+		})
+		return
+	}
+
+	if err := h.model.Delete(notebookID, userID, cascade); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(notebookErrorStatus(err))
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  true,
+		"message": "Notebook deleted successfully",
+	})
+}
+
+func notebookErrorStatus(err error) int {
+	switch err {
+	case models.ErrNotebookNotFound:
+		return http.StatusNotFound
+	case models.ErrNotebookNameTaken:
+		return http.StatusConflict
+	case models.ErrNotebookCycle, models.ErrNotebookTooDeep:
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,274 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+
+	"github.com/gorilla/mux"
+	"github.com/suraj/GoGoNotes/models"
+	"github.com/suraj/GoGoNotes/oidc"
+	"github.com/suraj/GoGoNotes/utils"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// OIDCHandler signs users in through external OpenID Connect providers
+type OIDCHandler struct {
+	providers             map[string]*oidc.Provider
+	oidcLoginModel        *models.OIDCLoginModel
+	externalIdentityModel *models.ExternalIdentityModel
+	userModel             *models.UserModel
+	tokenIssuer           *TokenIssuer
+}
+
+func NewOIDCHandler(providers map[string]*oidc.Provider, oidcLoginModel *models.OIDCLoginModel, externalIdentityModel *models.ExternalIdentityModel, userModel *models.UserModel, tokenIssuer *TokenIssuer) *OIDCHandler {
+	return &OIDCHandler{
+		providers:             providers,
+		oidcLoginModel:        oidcLoginModel,
+		externalIdentityModel: externalIdentityModel,
+		userModel:             userModel,
+		tokenIssuer:           tokenIssuer,
+	}
+}
+
+// GetProviders lists the providers users can sign in with
+func (h *OIDCHandler) GetProviders(w http.ResponseWriter, r *http.Request) {
+	names := []string{}
+	for name := range h.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":    true,
+		"message":   "Providers fetched successfully",
+		"providers": names,
+	})
+}
+
+// Login redirects the user to the provider's sign-in page
+func (h *OIDCHandler) Login(w http.ResponseWriter, r *http.Request) {
+	provider, ok := h.providers[mux.Vars(r)["provider"]]
+	if !ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Unknown identity provider",
+		})
+		return
+	}
+
+	state, login, err := h.oidcLoginModel.Create(provider.Name)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	authURL, err := provider.AuthCodeURL(state, login.Nonce, login.CodeVerifier)
+	if err != nil {
+		log.Printf("Failed to start sign-in with %s: %v", provider.Name, err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadGateway)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Identity provider is unavailable",
+		})
+		return
+	}
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// Callback finishes the sign-in when the provider redirects back. The external identity
+// is matched by subject first; the first time around it is linked to the account with the
+// same verified email address, or a new account is created for it.
+func (h *OIDCHandler) Callback(w http.ResponseWriter, r *http.Request) {
+	provider, ok := h.providers[mux.Vars(r)["provider"]]
+	if !ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Unknown identity provider",
+			"token":   "",
+		})
+		return
+	}
+
+	query := r.URL.Query()
+	if query.Get("error") != "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Sign-in was not completed: " + query.Get("error"),
+			"token":   "",
+		})
+		return
+	}
+
+	login, err := h.oidcLoginModel.Consume(query.Get("state"), provider.Name)
+	if err == models.ErrOIDCLoginInvalid || query.Get("code") == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": models.ErrOIDCLoginInvalid.Error(),
+			"token":   "",
+		})
+		return
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": err.Error(),
+			"token":   "",
+		})
+		return
+	}
+
+	claims, err := provider.Exchange(query.Get("code"), login.CodeVerifier, login.Nonce)
+	if err != nil {
+		log.Printf("Failed to complete sign-in with %s: %v", provider.Name, err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Sign-in with the identity provider failed",
+			"token":   "",
+		})
+		return
+	}
+
+	user, status, err := h.resolveUser(provider.Name, claims)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": err.Error(),
+			"token":   "",
+		})
+		return
+	}
+
+	if user.Disabled {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "This account has been disabled",
+			"token":   "",
+		})
+		return
+	}
+
+	// The provider stands in for the password, TOTP is still required on top of it
+	if user.TOTPEnabled {
+		mfaToken, err := h.tokenIssuer.MFAToken(user)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"status":  false,
+				"message": "Failed to generate token",
+				"token":   "",
+			})
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":       true,
+			"message":      "Two-factor authentication required",
+			"token":        "",
+			"mfa_required": true,
+			"mfa_token":    mfaToken,
+		})
+		return
+	}
+
+	tokenString, refreshToken, err := h.tokenIssuer.StartSession(user, r)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Failed to generate token",
+			"token":   "",
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":        true,
+		"message":       "Login Successful",
+		"token":         tokenString,
+		"refresh_token": refreshToken,
+		"expires_in":    h.tokenIssuer.ExpiresIn(),
+	})
+}
+
+// resolveUser finds the local user for a provider identity, linking or creating one on first sign-in.
+// The returned status is the HTTP status to respond with when err is not nil.
+func (h *OIDCHandler) resolveUser(providerName string, claims *oidc.Claims) (*models.User, int, error) {
+	identity, err := h.externalIdentityModel.Get(providerName, claims.Subject)
+	if err == nil {
+		user, err := h.userModel.GetByID(identity.UserID)
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		return user, 0, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	// Linking by email is only safe when the provider vouches for the address
+	if !claims.EmailVerified {
+		return nil, http.StatusForbidden, errors.New("The identity provider did not confirm your email address")
+	}
+	email, err := utils.NormalizeEmail(claims.Email)
+	if err != nil {
+		return nil, http.StatusForbidden, errors.New("The identity provider did not supply a usable email address")
+	}
+
+	user, err := h.userModel.GetByEmail(email)
+	switch {
+	case err == mongo.ErrNoDocuments:
+		user, err = h.userModel.CreateWithoutPassword(email)
+		if err == models.ErrUserExists {
+			return nil, http.StatusConflict, errors.New("An account with this email was just created, please try again")
+		}
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+	case err != nil:
+		return nil, http.StatusInternalServerError, err
+	case !user.EmailVerified:
+		// Whoever registered this account never proved they own the address, so
+		// linking it would hand their password access to the provider's user
+		return nil, http.StatusConflict, errors.New("An account with this email exists but its address is not verified. Sign in with your password and verify it first")
+	}
+
+	if _, err := h.externalIdentityModel.Link(user.ID, providerName, claims.Subject, email); err != nil {
+		if err == models.ErrIdentityLinked {
+			return nil, http.StatusConflict, errors.New("This identity was just linked, please try again")
+		}
+		return nil, http.StatusInternalServerError, err
+	}
+
+	return user, 0, nil
+}
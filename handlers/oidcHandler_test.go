@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/suraj/GoGoNotes/internal/testutil"
+	"github.com/suraj/GoGoNotes/models"
+	"github.com/suraj/GoGoNotes/oidc"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func oidcTestHandler(mt *mtest.T) *OIDCHandler {
+	return NewOIDCHandler(nil, nil, models.NewExternalIdentityModel(mt.Coll), models.NewUserModel(mt.Coll), nil)
+}
+
+func providerClaims(email string, verified bool) *oidc.Claims {
+	return &oidc.Claims{
+		Email:            email,
+		EmailVerified:    verified,
+		RegisteredClaims: jwt.RegisteredClaims{Subject: "subject-1"},
+	}
+}
+
+func TestResolveUser(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("linked identity wins over the email", func(mt *mtest.T) {
+		h := oidcTestHandler(mt)
+		user := models.User{ID: primitive.NewObjectID(), Email: "old@example.com"}
+		mt.AddMockResponses(
+			testutil.FindResponse(models.ExternalIdentity{ID: primitive.NewObjectID(), UserID: user.ID, Provider: "mock", Subject: "subject-1"}),
+			testutil.FindResponse(user),
+		)
+
+		// The address at the provider changed and isn't verified, the subject is what counts
+		got, status, err := h.resolveUser("mock", providerClaims("new@example.com", false))
+		if err != nil || got.ID != user.ID {
+			mt.Fatalf("resolveUser = %v, %d, %v", got, status, err)
+		}
+		if q := mt.GetStartedEvent().Command.Lookup("filter"); q.Document().Lookup("subject").StringValue() != "subject-1" || q.Document().Lookup("provider").StringValue() != "mock" {
+			mt.Errorf("identity looked up by %s", q)
+		}
+	})
+
+	mt.Run("unverified email at the provider", func(mt *mtest.T) {
+		h := oidcTestHandler(mt)
+		mt.AddMockResponses(testutil.FindResponse())
+
+		_, status, err := h.resolveUser("mock", providerClaims("bob@example.com", false))
+		if err == nil || status != http.StatusForbidden {
+			mt.Fatalf("resolveUser = %d, %v, want 403", status, err)
+		}
+		mt.GetStartedEvent()
+		if event := mt.GetStartedEvent(); event != nil {
+			mt.Errorf("nothing should be looked up by email, got %s", event.CommandName)
+		}
+	})
+
+	mt.Run("links the account with the same verified email", func(mt *mtest.T) {
+		h := oidcTestHandler(mt)
+		user := models.User{ID: primitive.NewObjectID(), Email: "bob@example.com", EmailVerified: true}
+		mt.AddMockResponses(testutil.FindResponse(), testutil.FindResponse(user), mtest.CreateSuccessResponse())
+
+		got, status, err := h.resolveUser("mock", providerClaims(" Bob@Example.com", true))
+		if err != nil || got.ID != user.ID {
+			mt.Fatalf("resolveUser = %v, %d, %v", got, status, err)
+		}
+
+		mt.GetStartedEvent()
+		if email := mt.GetStartedEvent().Command.Lookup("filter", "email").StringValue(); email != "bob@example.com" {
+			mt.Errorf("looked up %q, want the normalized email", email)
+		}
+		link := mt.GetStartedEvent().Command.Lookup("documents", "0")
+		if link.Document().Lookup("user_id").ObjectID() != user.ID || link.Document().Lookup("subject").StringValue() != "subject-1" {
+			mt.Errorf("linked %s", link)
+		}
+	})
+
+	mt.Run("refuses to link an account whose email is not verified", func(mt *mtest.T) {
+		h := oidcTestHandler(mt)
+		user := models.User{ID: primitive.NewObjectID(), Email: "bob@example.com", Password: "hash"}
+		mt.AddMockResponses(testutil.FindResponse(), testutil.FindResponse(user))
+
+		_, status, err := h.resolveUser("mock", providerClaims("bob@example.com", true))
+		if err == nil || status != http.StatusConflict {
+			mt.Fatalf("resolveUser = %d, %v, want 409", status, err)
+		}
+		mt.GetStartedEvent()
+		mt.GetStartedEvent()
+		if event := mt.GetStartedEvent(); event != nil {
+			mt.Errorf("nothing should be written, got %s", event.CommandName)
+		}
+	})
+
+	mt.Run("creates a passwordless account for a new email", func(mt *mtest.T) {
+		h := oidcTestHandler(mt)
+		mt.AddMockResponses(testutil.FindResponse(), testutil.FindResponse(), mtest.CreateSuccessResponse(), mtest.CreateSuccessResponse())
+
+		got, status, err := h.resolveUser("mock", providerClaims("new@example.com", true))
+		if err != nil {
+			mt.Fatalf("resolveUser = %d, %v", status, err)
+		}
+		if got.Email != "new@example.com" || !got.EmailVerified || got.Password != "" {
+			mt.Errorf("created %+v", got)
+		}
+
+		mt.GetStartedEvent()
+		mt.GetStartedEvent()
+		mt.GetStartedEvent()
+		if userID := mt.GetStartedEvent().Command.Lookup("documents", "0", "user_id").ObjectID(); userID != got.ID {
+			mt.Errorf("identity linked to %s, want the new user %s", userID.Hex(), got.ID.Hex())
+		}
+	})
+
+	mt.Run("identity linked concurrently", func(mt *mtest.T) {
+		h := oidcTestHandler(mt)
+		user := models.User{ID: primitive.NewObjectID(), Email: "bob@example.com", EmailVerified: true}
+		mt.AddMockResponses(
+			testutil.FindResponse(),
+			testutil.FindResponse(user),
+			mtest.CreateWriteErrorsResponse(mtest.WriteError{Index: 0, Code: 11000, Message: "duplicate key"}),
+		)
+
+		_, status, err := h.resolveUser("mock", providerClaims("bob@example.com", true))
+		if err == nil || status != http.StatusConflict {
+			mt.Errorf("resolveUser = %d, %v, want 409", status, err)
+		}
+	})
+}
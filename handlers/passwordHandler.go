@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/suraj/GoGoNotes/mailer"
+	"github.com/suraj/GoGoNotes/models"
+	"github.com/suraj/GoGoNotes/utils"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type PasswordHandler struct {
+	userModel         *models.UserModel
+	userTokenModel    *models.UserTokenModel
+	sessionModel      *models.SessionModel
+	refreshTokenModel *models.RefreshTokenModel
+	patModel          *models.PersonalAccessTokenModel
+	loginAttemptModel *models.LoginAttemptModel
+	rateLimitModel    *models.RateLimitModel
+	forgotEmailLimit  models.RateLimit
+	forgotIPLimit     models.RateLimit
+	mailer            mailer.Mailer
+	passwordPolicy    *utils.PasswordPolicy
+	resetTokenTTL     time.Duration
+	resetURL          string
+}
+
+func NewPasswordHandler(userModel *models.UserModel, userTokenModel *models.UserTokenModel, sessionModel *models.SessionModel, refreshTokenModel *models.RefreshTokenModel, patModel *models.PersonalAccessTokenModel, loginAttemptModel *models.LoginAttemptModel, rateLimitModel *models.RateLimitModel, forgotEmailLimit, forgotIPLimit models.RateLimit, mailer mailer.Mailer, passwordPolicy *utils.PasswordPolicy, resetTokenTTL time.Duration, resetURL string) *PasswordHandler {
+	return &PasswordHandler{
+		userModel:         userModel,
+		userTokenModel:    userTokenModel,
+		sessionModel:      sessionModel,
+		refreshTokenModel: refreshTokenModel,
+		patModel:          patModel,
+		loginAttemptModel: loginAttemptModel,
+		rateLimitModel:    rateLimitModel,
+		forgotEmailLimit:  forgotEmailLimit,
+		forgotIPLimit:     forgotIPLimit,
+		mailer:            mailer,
+		passwordPolicy:    passwordPolicy,
+		resetTokenTTL:     resetTokenTTL,
+		resetURL:          resetURL,
+	}
+}
+
+// ForgotPassword emails a reset link. The response is the same whether or not the
+// account exists, and the email is sent in the background so the response time doesn't
+// give it away either. Requests are throttled per email and per client IP.
+func (h *PasswordHandler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil || input.Email == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "email is required",
+		})
+		return
+	}
+
+	wait, err := h.throttleForgotPassword(input.Email, utils.ClientIP(r))
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if wait > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Retry-After", utils.RetryAfter(wait))
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Too many password reset requests, try again later",
+		})
+		return
+	}
+
+	go func() {
+		user, err := h.userModel.GetByEmail(input.Email)
+		if err != nil {
+			if err != mongo.ErrNoDocuments {
+				log.Printf("Failed to look up user for password reset: %v", err)
+			}
+			return
+		}
+		if err := h.SendResetEmail(user); err != nil {
+			log.Printf("Failed to send password reset email: %v", err)
+		}
+	}()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  true,
+		"message": "If an account exists for that email, a password reset link has been sent",
+	})
+}
+
+// ResetPassword sets a new password using a reset token and signs the user out everywhere
+func (h *PasswordHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil || input.Token == "" || input.Password == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "token and password are required",
+		})
+		return
+	}
+
+	// Check the password before consuming the token so a rejected password doesn't burn the link
+	problem, err := h.passwordPolicy.Validate(input.Password)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Failed to check password: " + err.Error(),
+		})
+		return
+	}
+	if problem != "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Validation failed",
+			"errors":  utils.ValidationErrors{"password": problem},
+		})
+		return
+	}
+
+	userToken, err := h.userTokenModel.Consume(input.Token, models.TokenPurposePasswordReset)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err == models.ErrUserTokenInvalid {
+			status = http.StatusBadRequest
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	// Updating the password bumps the token generation, which retires every access token
+	if err := h.userModel.UpdatePassword(userToken.UserID, input.Password); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Failed to reset password: " + err.Error(),
+		})
+		return
+	}
+
+	if err := h.sessionModel.DeleteAllForUser(userToken.UserID); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	if err := h.refreshTokenModel.RevokeAllForUser(userToken.UserID); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	// Whoever knew the old password could have minted tokens with it
+	if err := h.patModel.RevokeAllForUser(userToken.UserID); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	// Proving ownership of the mailbox also lifts a brute-force lockout
+	if user, err := h.userModel.GetByID(userToken.UserID); err == nil {
+		if err := h.loginAttemptModel.Reset(user.Email); err != nil {
+			log.Printf("Failed to reset login attempts: %v", err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  true,
+		"message": "Password reset successfully",
+	})
+}
+
+// throttleForgotPassword counts a reset request against the email and the client IP and
+// returns how long the caller has to wait when either limit is used up
+func (h *PasswordHandler) throttleForgotPassword(email, ip string) (time.Duration, error) {
+	emailWait, err := h.rateLimitModel.Allow("forgot-password:email:"+strings.ToLower(strings.TrimSpace(email)), h.forgotEmailLimit)
+	if err != nil {
+		return 0, err
+	}
+	ipWait, err := h.rateLimitModel.Allow("forgot-password:ip:"+ip, h.forgotIPLimit)
+	if err != nil {
+		return 0, err
+	}
+	return max(emailWait, ipWait), nil
+}
+
+// SendResetEmail emails the user a single-use link to choose a new password
+func (h *PasswordHandler) SendResetEmail(user *models.User) error {
+	token, err := h.userTokenModel.Create(user.ID, models.TokenPurposePasswordReset, h.resetTokenTTL)
+	if err != nil {
+		return err
+	}
+
+	link, err := url.Parse(h.resetURL)
+	if err != nil {
+		return fmt.Errorf("invalid password reset URL: %v", err)
+	}
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+	body := fmt.Sprintf("We received a request to reset your GoGoNotes password.\n\n"+
+		"Use the link below within %s to choose a new password:\n\n%s\n\n"+
+		"If you didn't ask for this, you can ignore this email.", h.resetTokenTTL, link.String())
+
+	return h.mailer.Send(user.Email, "Reset your GoGoNotes password", body)
+}
@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/suraj/GoGoNotes/internal/testutil"
+	"github.com/suraj/GoGoNotes/models"
+	"github.com/suraj/GoGoNotes/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// sentMail records the emails a handler sends
+type sentMail struct {
+	to, subject, body []string
+}
+
+func (m *sentMail) Send(to, subject, body string) error {
+	m.to = append(m.to, to)
+	m.subject = append(m.subject, subject)
+	m.body = append(m.body, body)
+	return nil
+}
+
+func passwordTestHandler(mt *mtest.T, mail *sentMail, resetURL string) *PasswordHandler {
+	limit := models.RateLimit{Limit: 3, Window: time.Hour}
+	return NewPasswordHandler(models.NewUserModel(mt.Coll), models.NewUserTokenModel(mt.Coll), nil, nil, nil, nil,
+		models.NewRateLimitModel(mt.Coll), limit, limit, mail, nil, time.Hour, resetURL)
+}
+
+// counterResponse is the reply to a rate limit counter reaching count
+func counterResponse(count int) bson.D {
+	return mtest.CreateSuccessResponse(bson.E{Key: "value", Value: bson.D{{Key: "_id", Value: "key"}, {Key: "count", Value: count}}})
+}
+
+func TestSendResetEmailLinksToTheFrontend(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("link", func(mt *mtest.T) {
+		mail := &sentMail{}
+		h := passwordTestHandler(mt, mail, "https://app.example.com/reset-password?lang=en")
+		mt.AddMockResponses(testutil.UpdateResponse(0), mtest.CreateSuccessResponse())
+
+		if err := h.SendResetEmail(&models.User{ID: primitive.NewObjectID(), Email: "bob@example.com"}); err != nil {
+			mt.Fatal(err)
+		}
+		if len(mail.to) != 1 || mail.to[0] != "bob@example.com" {
+			mt.Fatalf("sent to %v", mail.to)
+		}
+
+		raw := regexp.MustCompile(`https://\S+`).FindString(mail.body[0])
+		link, err := url.Parse(raw)
+		if err != nil {
+			mt.Fatalf("no link in %q", mail.body[0])
+		}
+		if link.Host != "app.example.com" || link.Path != "/reset-password" || link.Query().Get("lang") != "en" || link.Query().Get("token") == "" {
+			mt.Errorf("link = %s", raw)
+		}
+	})
+}
+
+func TestForgotPasswordThrottling(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	forgot := func(h *PasswordHandler, email string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/password/forgot", strings.NewReader(`{"email":"`+email+`"}`))
+		r.RemoteAddr = "198.51.100.7:4000"
+		w := httptest.NewRecorder()
+		h.ForgotPassword(w, r)
+		return w
+	}
+
+	mt.Run("too many requests for an email", func(mt *mtest.T) {
+		mail := &sentMail{}
+		h := passwordTestHandler(mt, mail, "https://app.example.com/reset-password")
+		mt.AddMockResponses(counterResponse(4), counterResponse(1))
+
+		w := forgot(h, " Bob@Example.com")
+		if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+			mt.Fatalf("status %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+		}
+
+		emailKey := mt.GetStartedEvent().Command.Lookup("query", "_id").StringValue()
+		ipKey := mt.GetStartedEvent().Command.Lookup("query", "_id").StringValue()
+		if !strings.HasPrefix(emailKey, "forgot-password:email:bob@example.com:") || !strings.HasPrefix(ipKey, "forgot-password:ip:198.51.100.7:") {
+			mt.Errorf("counted %q and %q", emailKey, ipKey)
+		}
+		if event := mt.GetStartedEvent(); event != nil {
+			mt.Errorf("a throttled request must not look the user up, got %s", event.CommandName)
+		}
+	})
+
+	mt.Run("too many requests from an IP", func(mt *mtest.T) {
+		h := passwordTestHandler(mt, &sentMail{}, "https://app.example.com/reset-password")
+		mt.AddMockResponses(counterResponse(1), counterResponse(4))
+
+		if w := forgot(h, "someone@example.com"); w.Code != http.StatusTooManyRequests {
+			var body map[string]interface{}
+			json.NewDecoder(w.Body).Decode(&body)
+			mt.Errorf("status %d: %v", w.Code, body)
+		}
+	})
+}
+
+func TestResetPassword(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	userID := primitive.NewObjectID()
+	reset := func(h *PasswordHandler, password string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		r := httptest.NewRequest(http.MethodPost, "/password/reset", strings.NewReader(`{"token":"link-token","password":"`+password+`"}`))
+		w := httptest.NewRecorder()
+		h.ResetPassword(w, r)
+
+		var body map[string]interface{}
+		json.NewDecoder(w.Body).Decode(&body)
+		return w, body
+	}
+	handler := func(mt *mtest.T) *PasswordHandler {
+		h := passwordTestHandler(mt, &sentMail{}, "https://app.example.com/reset-password")
+		h.sessionModel = models.NewSessionModel(mt.Coll, time.Hour)
+		h.refreshTokenModel = models.NewRefreshTokenModel(mt.Coll, time.Hour)
+		h.patModel = models.NewPersonalAccessTokenModel(mt.Coll)
+		h.loginAttemptModel = models.NewLoginAttemptModel(mt.Coll, models.LockoutPolicy{}, models.LockoutPolicy{})
+		h.passwordPolicy = &utils.PasswordPolicy{MinLength: 12}
+		return h
+	}
+
+	mt.Run("a rejected password keeps the link", func(mt *mtest.T) {
+		w, body := reset(handler(mt), "short")
+		if w.Code != http.StatusBadRequest || body["message"] != "Validation failed" {
+			mt.Fatalf("status %d: %v", w.Code, body)
+		}
+		if event := mt.GetStartedEvent(); event != nil {
+			mt.Errorf("the token must not be consumed, got %s", event.CommandName)
+		}
+	})
+
+	mt.Run("used or expired link", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "value", Value: nil}))
+
+		w, body := reset(handler(mt), "correct horse battery")
+		if w.Code != http.StatusBadRequest || body["message"] != models.ErrUserTokenInvalid.Error() {
+			mt.Fatalf("status %d: %v", w.Code, body)
+		}
+		consume := mt.GetStartedEvent().Command
+		if consume.Lookup("query", "purpose", "$in", "0").StringValue() != models.TokenPurposePasswordReset || consume.Lookup("query", "used_at").Type != bson.TypeNull {
+			mt.Errorf("only unused reset tokens may be consumed, got %s", consume)
+		}
+	})
+
+	mt.Run("signs out everywhere", func(mt *mtest.T) {
+		token := models.UserToken{ID: primitive.NewObjectID(), UserID: userID, Purpose: models.TokenPurposePasswordReset}
+		user := models.User{ID: userID, Email: "bob@example.com"}
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: testutil.ToDoc(token)}),
+			testutil.UpdateResponse(1), testutil.DeleteResponse(2), testutil.UpdateResponse(2), testutil.UpdateResponse(1),
+			testutil.FindResponse(user), testutil.DeleteResponse(1),
+		)
+
+		w, body := reset(handler(mt), "correct horse battery")
+		if w.Code != http.StatusOK {
+			mt.Fatalf("status %d: %v", w.Code, body)
+		}
+
+		mt.GetStartedEvent()
+		password := mt.GetStartedEvent().Command
+		if password.Lookup("updates", "0", "q", "_id").ObjectID() != userID || password.Lookup("updates", "0", "u", "$inc", "token_generation").IsZero() {
+			mt.Errorf("the password update must retire access tokens, got %s", password)
+		}
+		for _, want := range []string{"delete", "update", "update"} {
+			event := mt.GetStartedEvent()
+			if event.CommandName != want {
+				mt.Fatalf("expected %s of the user's sessions and tokens, got %s", want, event.Command)
+			}
+		}
+		mt.GetStartedEvent()
+		if attempts := mt.GetStartedEvent(); attempts == nil || attempts.CommandName != "delete" {
+			mt.Error("a reset must lift the login lockout")
+		}
+	})
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/suraj/GoGoNotes/internal/testutil"
+	"github.com/suraj/GoGoNotes/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestRevokeToken(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	revoke := func(h *PersonalAccessTokenHandler) int {
+		id := primitive.NewObjectID().Hex()
+		r := sessionRequest(http.MethodDelete, "", primitive.NewObjectID(), primitive.NewObjectID())
+		w := httptest.NewRecorder()
+		h.RevokeToken(w, mux.SetURLVars(r, map[string]string{"id": id}))
+		return w.Code
+	}
+
+	tests := []struct {
+		name     string
+		response bson.D
+		want     int
+	}{
+		{"revoked", testutil.UpdateResponse(1), http.StatusOK},
+		{"missing or already revoked", testutil.UpdateResponse(0), http.StatusNotFound},
+		{"database error", mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 91, Message: "shutting down"}), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			h := NewPersonalAccessTokenHandler(models.NewPersonalAccessTokenModel(mt.Coll))
+			mt.AddMockResponses(tt.response)
+
+			if code := revoke(h); code != tt.want {
+				mt.Errorf("status %d, want %d", code, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateToken(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	create := func(h *PersonalAccessTokenHandler, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		h.CreateToken(w, sessionRequest(http.MethodPost, body, primitive.NewObjectID(), primitive.NewObjectID()))
+
+		var response map[string]interface{}
+		json.NewDecoder(w.Body).Decode(&response)
+		return w, response
+	}
+
+	invalid := []struct {
+		name string
+		body string
+	}{
+		{"no name", `{"name":"  ","scopes":["notes:read"]}`},
+		{"no scopes", `{"name":"backup"}`},
+		{"unknown scope", `{"name":"backup","scopes":["notes:read","admin"]}`},
+		{"already expired", `{"name":"backup","scopes":["notes:read"],"expires_at":"2000-01-01T00:00:00Z"}`},
+	}
+	for _, tt := range invalid {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			if w, body := create(NewPersonalAccessTokenHandler(models.NewPersonalAccessTokenModel(mt.Coll)), tt.body); w.Code != http.StatusBadRequest {
+				mt.Errorf("status %d: %v", w.Code, body)
+			}
+		})
+	}
+
+	mt.Run("only the hash is stored", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		w, body := create(NewPersonalAccessTokenHandler(models.NewPersonalAccessTokenModel(mt.Coll)), `{"name":"backup","scopes":["notes:read"]}`)
+		token, _ := body["token"].(string)
+		if w.Code != http.StatusCreated || !strings.HasPrefix(token, models.PersonalAccessTokenPrefix) {
+			mt.Fatalf("status %d: %v", w.Code, body)
+		}
+
+		stored := mt.GetStartedEvent().Command.Lookup("documents", "0").Document()
+		if hash := stored.Lookup("token_hash").StringValue(); hash == "" || strings.Contains(stored.String(), token) {
+			mt.Errorf("the plain token must not be stored, got %s", stored)
+		}
+		if prefix := stored.Lookup("prefix").StringValue(); !strings.HasPrefix(token, prefix) || len(prefix) >= len(token) {
+			mt.Errorf("prefix %q does not identify %q", prefix, token)
+		}
+	})
+}
@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/suraj/GoGoNotes/middleware"
+	"github.com/suraj/GoGoNotes/models"
+)
+
+// reauthWindow is how recently a user without a password must have signed in to
+// change their email, delete their account or turn off two-factor authentication
+const reauthWindow = 10 * time.Minute
+
+const reauthMessage = "Please sign in again to confirm this change"
+
+// confirmIdentity re-checks who is behind a sensitive change. Users with a password have to
+// enter it. Users who only sign in through an identity provider have none, so their
+// current session must have been started within reauthWindow instead. It returns the
+// message to respond with when the check fails.
+func confirmIdentity(r *http.Request, userModel *models.UserModel, sessionModel *models.SessionModel, user *models.User, password string) (bool, string) {
+	if user.Password != "" {
+		return userModel.VerifyPassword(user, password), "Password is incorrect"
+	}
+
+	identity, ok := middleware.IdentityFromContext(r.Context())
+	if !ok {
+		return false, reauthMessage
+	}
+	session, err := sessionModel.Get(identity.SessionID, user.ID)
+	if err != nil || time.Since(session.CreatedAt) > reauthWindow {
+		return false, reauthMessage
+	}
+	return true, ""
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/suraj/GoGoNotes/internal/testutil"
+	"github.com/suraj/GoGoNotes/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestConfirmIdentity(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	withPassword := &models.User{ID: primitive.NewObjectID(), Password: string(hash)}
+	passwordless := &models.User{ID: primitive.NewObjectID(), EmailVerified: true}
+	sessionID := primitive.NewObjectID()
+	session := func(createdAt time.Time) models.Session {
+		return models.Session{ID: sessionID, UserID: passwordless.ID, CreatedAt: createdAt, LastSeenAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)}
+	}
+
+	mt.Run("password users must enter their password", func(mt *mtest.T) {
+		h := accountTestHandler(mt)
+		r := sessionRequest(http.MethodDelete, "", withPassword.ID, sessionID)
+
+		if ok, _ := confirmIdentity(r, h.userModel, h.sessionModel, withPassword, "correct horse"); !ok {
+			mt.Error("correct password was rejected")
+		}
+		if ok, message := confirmIdentity(r, h.userModel, h.sessionModel, withPassword, "wrong"); ok || message != "Password is incorrect" {
+			mt.Errorf("wrong password: %v, %q", ok, message)
+		}
+		if ok, _ := confirmIdentity(r, h.userModel, h.sessionModel, withPassword, ""); ok {
+			mt.Error("a missing password was accepted")
+		}
+		if event := mt.GetStartedEvent(); event != nil {
+			mt.Errorf("a recent sign-in must not stand in for the password, got %s", event.CommandName)
+		}
+	})
+
+	mt.Run("passwordless users who just signed in", func(mt *mtest.T) {
+		h := accountTestHandler(mt)
+		mt.AddMockResponses(testutil.FindResponse(session(time.Now().Add(-time.Minute))))
+
+		if ok, message := confirmIdentity(sessionRequest(http.MethodDelete, "", passwordless.ID, sessionID), h.userModel, h.sessionModel, passwordless, ""); !ok {
+			mt.Errorf("recent sign-in was rejected: %q", message)
+		}
+		filter := mt.GetStartedEvent().Command.Lookup("filter")
+		if filter.Document().Lookup("_id").ObjectID() != sessionID || filter.Document().Lookup("user_id").ObjectID() != passwordless.ID {
+			mt.Errorf("looked up session %s", filter)
+		}
+	})
+
+	mt.Run("passwordless users with an old session", func(mt *mtest.T) {
+		h := accountTestHandler(mt)
+		mt.AddMockResponses(testutil.FindResponse(session(time.Now().Add(-reauthWindow - time.Minute))))
+
+		if ok, message := confirmIdentity(sessionRequest(http.MethodDelete, "", passwordless.ID, sessionID), h.userModel, h.sessionModel, passwordless, ""); ok || message != reauthMessage {
+			mt.Errorf("old session: %v, %q", ok, message)
+		}
+	})
+
+	mt.Run("passwordless users whose session is gone", func(mt *mtest.T) {
+		h := accountTestHandler(mt)
+		mt.AddMockResponses(testutil.FindResponse())
+
+		if ok, _ := confirmIdentity(sessionRequest(http.MethodDelete, "", passwordless.ID, sessionID), h.userModel, h.sessionModel, passwordless, ""); ok {
+			mt.Error("unknown session was accepted")
+		}
+	})
+
+	mt.Run("without an identity", func(mt *mtest.T) {
+		h := accountTestHandler(mt)
+
+		if ok, _ := confirmIdentity(httptest.NewRequest(http.MethodDelete, "/account", nil), h.userModel, h.sessionModel, passwordless, ""); ok {
+			mt.Error("request without an identity was accepted")
+		}
+	})
+}
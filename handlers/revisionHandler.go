@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/suraj/GoGoNotes/middleware"
+	"github.com/suraj/GoGoNotes/models"
+	"github.com/suraj/GoGoNotes/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RevisionHandler exposes the revision history of notes
+type RevisionHandler struct {
+	noteModel *models.NoteModel
+}
+
+func NewRevisionHandler(noteModel *models.NoteModel) *RevisionHandler {
+	return &RevisionHandler{
+		noteModel: noteModel,
+	}
+}
+
+// GetAllRevisions lists the revisions of a note, newest first
+func (h *RevisionHandler) GetAllRevisions(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserIDFromContext(r.Context())
+
+	noteID, ok := h.noteFromPath(w, r, userID)
+	if !ok {
+		return
+	}
+
+	revisions, err := h.noteModel.GetRevisions(noteID, userID)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":    false,
+			"message":   "Failed to fetch revisions: " + err.Error(),
+			"revisions": []interface{}{},
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":    true,
+		"message":   "Revisions fetched successfully",
+		"revisions": revisions,
+	})
+}
+
+func (h *RevisionHandler) GetRevision(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserIDFromContext(r.Context())
+
+	noteID, ok := h.noteFromPath(w, r, userID)
+	if !ok {
+		return
+	}
+
+	revision, ok := h.revision(w, noteID, userID, mux.Vars(r)["number"])
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":   true,
+		"message":  "Revision fetched successfully",
+		"revision": revision,
+	})
+}
+
+// DiffRevisions compares revision ?from= with revision ?to= line by line. Title and
+// body come as lists of equal, delete and insert lines; tags as added and removed.
+func (h *RevisionHandler) DiffRevisions(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserIDFromContext(r.Context())
+
+	noteID, ok := h.noteFromPath(w, r, userID)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	from, ok := h.revision(w, noteID, userID, query.Get("from"))
+	if !ok {
+		return
+	}
+	to, ok := h.revision(w, noteID, userID, query.Get("to"))
+	if !ok {
+		return
+	}
+
+	added, removed := tagChanges(from.Tags, to.Tags)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  true,
+		"message": "Diff computed successfully",
+		"from":    from.Number,
+		"to":      to.Number,
+		"title":   utils.DiffLines(from.Title, to.Title),
+		"body":    utils.DiffLines(from.Body, to.Body),
+		"tags": map[string]interface{}{
+			"added":   added,
+			"removed": removed,
+		},
+	})
+}
+
+// RestoreRevision makes an old revision the note's current content, which is
+// recorded as a new revision so the restore itself can be undone. If-Match works
+// like in UpdateNote.
+func (h *RevisionHandler) RestoreRevision(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserIDFromContext(r.Context())
+
+	noteID, ok := h.noteFromPath(w, r, userID)
+	if !ok {
+		return
+	}
+
+	revision, ok := h.revision(w, noteID, userID, mux.Vars(r)["number"])
+	if !ok {
+		return
+	}
+
+	tags := append([]string{}, revision.Tags...)
+	note, err := h.noteModel.Update(noteID, userID, revision.Title, revision.Body, tags, ifMatchVersions(r))
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(noteErrorStatus(err))
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Failed to restore revision: " + err.Error(),
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", noteETag(note))
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  true,
+		"message": "Revision restored successfully",
+		"note":    note,
+	})
+}
+
+// noteFromPath parses the note ID in the path and checks that the note exists
+// outside the trash, writing the error response if not
+func (h *RevisionHandler) noteFromPath(w http.ResponseWriter, r *http.Request, userID primitive.ObjectID) (primitive.ObjectID, bool) {
+	noteID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Invalid note ID",
+		})
+		return noteID, false
+	}
+
+	if _, err := h.noteModel.GetByID(noteID, userID); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Note not found",
+		})
+		return noteID, false
+	}
+
+	return noteID, true
+}
+
+// revision fetches the revision with the given number, writing the error response if there is none
+func (h *RevisionHandler) revision(w http.ResponseWriter, noteID, userID primitive.ObjectID, number string) (*models.NoteRevision, bool) {
+	n, err := strconv.Atoi(number)
+	if err != nil || n < 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Invalid revision number",
+		})
+		return nil, false
+	}
+
+	revision, err := h.noteModel.GetRevision(noteID, userID, n)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err == models.ErrRevisionNotFound {
+			status = http.StatusNotFound
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": err.Error(),
+		})
+		return nil, false
+	}
+
+	return revision, true
+}
+
+// tagChanges returns the tags in to but not in from, and the other way around
+func tagChanges(from, to []string) (added, removed []string) {
+	added, removed = []string{}, []string{}
+	had := make(map[string]bool, len(from))
+	for _, tag := range from {
+		had[tag] = true
+	}
+	has := make(map[string]bool, len(to))
+	for _, tag := range to {
+		has[tag] = true
+		if !had[tag] {
+			added = append(added, tag)
+		}
+	}
+	for _, tag := range from {
+		if !has[tag] {
+			removed = append(removed, tag)
+		}
+	}
+	return added, removed
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/suraj/GoGoNotes/middleware"
+	"github.com/suraj/GoGoNotes/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SessionHandler lets users see where they are signed in and sign out individual devices
+type SessionHandler struct {
+	sessionModel      *models.SessionModel
+	refreshTokenModel *models.RefreshTokenModel
+}
+
+func NewSessionHandler(sessionModel *models.SessionModel, refreshTokenModel *models.RefreshTokenModel) *SessionHandler {
+	return &SessionHandler{
+		sessionModel:      sessionModel,
+		refreshTokenModel: refreshTokenModel,
+	}
+}
+
+func (h *SessionHandler) GetAllSessions(w http.ResponseWriter, r *http.Request) {
+	identity, _ := middleware.IdentityFromContext(r.Context())
+
+	sessions, err := h.sessionModel.GetAll(identity.UserID)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":   false,
+			"message":  "Failed to fetch sessions: " + err.Error(),
+			"sessions": []interface{}{},
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":          true,
+		"message":         "Sessions fetched successfully",
+		"sessions":        sessions,
+		"current_session": identity.SessionID,
+	})
+}
+
+// RevokeSession signs out a single device. Its access tokens stop working right
+// away and its refresh token can no longer be used.
+func (h *SessionHandler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserIDFromContext(r.Context())
+
+	params := mux.Vars(r)
+	sessionID, err := primitive.ObjectIDFromHex(params["id"])
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Invalid session ID",
+		})
+		return
+	}
+
+	if err := h.sessionModel.Delete(sessionID, userID); err != nil {
+		status := http.StatusInternalServerError
+		if err == models.ErrSessionNotFound {
+			status = http.StatusNotFound
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	if err := h.refreshTokenModel.RevokeFamily(sessionID); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  true,
+		"message": "Session revoked successfully",
+	})
+}
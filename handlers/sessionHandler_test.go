@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/suraj/GoGoNotes/internal/testutil"
+	"github.com/suraj/GoGoNotes/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func sessionTestHandler(mt *mtest.T) *SessionHandler {
+	return NewSessionHandler(models.NewSessionModel(mt.Coll, time.Hour), models.NewRefreshTokenModel(mt.Coll, time.Hour))
+}
+
+func TestGetAllSessions(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	userID := primitive.NewObjectID()
+	currentID := primitive.NewObjectID()
+
+	mt.Run("marks the current session", func(mt *mtest.T) {
+		sessions := []interface{}{
+			models.Session{ID: currentID, UserID: userID, UserAgent: "Firefox"},
+			models.Session{ID: primitive.NewObjectID(), UserID: userID, UserAgent: "curl"},
+		}
+		mt.AddMockResponses(testutil.FindResponse(sessions...))
+
+		w := httptest.NewRecorder()
+		sessionTestHandler(mt).GetAllSessions(w, sessionRequest(http.MethodGet, "", userID, currentID))
+
+		var body struct {
+			Sessions       []models.Session `json:"sessions"`
+			CurrentSession string           `json:"current_session"`
+		}
+		json.NewDecoder(w.Body).Decode(&body)
+		if w.Code != http.StatusOK || len(body.Sessions) != 2 || body.CurrentSession != currentID.Hex() {
+			mt.Fatalf("status %d: %+v", w.Code, body)
+		}
+		if filter := mt.GetStartedEvent().Command.Lookup("filter"); filter.Document().Lookup("user_id").ObjectID() != userID {
+			mt.Errorf("only the user's sessions may be listed, got %s", filter)
+		}
+	})
+
+	mt.Run("no sessions", func(mt *mtest.T) {
+		mt.AddMockResponses(testutil.FindResponse())
+
+		w := httptest.NewRecorder()
+		sessionTestHandler(mt).GetAllSessions(w, sessionRequest(http.MethodGet, "", userID, currentID))
+
+		var body map[string]interface{}
+		json.NewDecoder(w.Body).Decode(&body)
+		if sessions, ok := body["sessions"].([]interface{}); !ok || len(sessions) != 0 {
+			mt.Errorf("sessions = %v, want an empty list", body["sessions"])
+		}
+	})
+}
+
+func TestRevokeSession(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	userID := primitive.NewObjectID()
+	revoke := func(h *SessionHandler, sessionID primitive.ObjectID) int {
+		r := sessionRequest(http.MethodDelete, "", userID, primitive.NewObjectID())
+		w := httptest.NewRecorder()
+		h.RevokeSession(w, mux.SetURLVars(r, map[string]string{"id": sessionID.Hex()}))
+		return w.Code
+	}
+
+	mt.Run("signs out the device", func(mt *mtest.T) {
+		sessionID := primitive.NewObjectID()
+		mt.AddMockResponses(testutil.DeleteResponse(1), testutil.UpdateResponse(1))
+
+		if code := revoke(sessionTestHandler(mt), sessionID); code != http.StatusOK {
+			mt.Fatalf("status %d", code)
+		}
+		deleted := mt.GetStartedEvent().Command
+		if deleted.Lookup("deletes", "0", "q", "_id").ObjectID() != sessionID || deleted.Lookup("deletes", "0", "q", "user_id").ObjectID() != userID {
+			mt.Errorf("only the user's own session may be deleted, got %s", deleted)
+		}
+		if family := mt.GetStartedEvent().Command; family.Lookup("updates", "0", "q", "family_id").ObjectID() != sessionID {
+			mt.Errorf("expected the session's refresh tokens to be revoked, got %s", family)
+		}
+	})
+
+	mt.Run("someone else's or unknown session", func(mt *mtest.T) {
+		mt.AddMockResponses(testutil.DeleteResponse(0))
+
+		if code := revoke(sessionTestHandler(mt), primitive.NewObjectID()); code != http.StatusNotFound {
+			mt.Fatalf("status %d", code)
+		}
+		mt.GetStartedEvent()
+		if event := mt.GetStartedEvent(); event != nil {
+			mt.Errorf("no refresh tokens may be revoked, got %s", event.CommandName)
+		}
+	})
+}
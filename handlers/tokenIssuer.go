@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/suraj/GoGoNotes/models"
+	"github.com/suraj/GoGoNotes/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// mfaTokenTTL is how long a user has to enter their second factor after the password step
+const mfaTokenTTL = 5 * time.Minute
+
+// TokenIssuer creates the sessions and the access and refresh tokens handed out to clients
+type TokenIssuer struct {
+	userModel         *models.UserModel
+	sessionModel      *models.SessionModel
+	refreshTokenModel *models.RefreshTokenModel
+	keyring           *utils.Keyring
+	accessTokenTTL    time.Duration
+}
+
+func NewTokenIssuer(userModel *models.UserModel, sessionModel *models.SessionModel, refreshTokenModel *models.RefreshTokenModel, keyring *utils.Keyring, accessTokenTTL time.Duration) *TokenIssuer {
+	return &TokenIssuer{
+		userModel:         userModel,
+		sessionModel:      sessionModel,
+		refreshTokenModel: refreshTokenModel,
+		keyring:           keyring,
+		accessTokenTTL:    accessTokenTTL,
+	}
+}
+
+// StartSession records a new login from the device making the request and issues its first tokens
+func (t *TokenIssuer) StartSession(user *models.User, r *http.Request) (string, string, error) {
+	session, err := t.sessionModel.Create(user.ID, r.UserAgent(), utils.ClientIP(r))
+	if err != nil {
+		return "", "", err
+	}
+	if err := t.userModel.RecordLogin(user.ID); err != nil {
+		log.Printf("Failed to record login: %v", err)
+	}
+	return t.Issue(user, session.ID)
+}
+
+// Issue creates an access token and a refresh token for an existing session.
+// The session ID is also the refresh token family ID.
+func (t *TokenIssuer) Issue(user *models.User, sessionID primitive.ObjectID) (string, string, error) {
+	tokenString, err := t.AccessToken(user, sessionID)
+	if err != nil {
+		return "", "", err
+	}
+
+	refreshToken, _, err := t.refreshTokenModel.Create(user.ID, sessionID)
+	if err != nil {
+		return "", "", err
+	}
+
+	return tokenString, refreshToken, nil
+}
+
+func (t *TokenIssuer) AccessToken(user *models.User, sessionID primitive.ObjectID) (string, error) {
+	return t.sign(user, utils.TokenTypeAccess, sessionID, t.accessTokenTTL)
+}
+
+// MFAToken is a short-lived challenge token that proves the password step of a
+// login succeeded. It can only be exchanged at /login/mfa, never used as an access token.
+func (t *TokenIssuer) MFAToken(user *models.User) (string, error) {
+	return t.sign(user, utils.TokenTypeMFA, primitive.NilObjectID, mfaTokenTTL)
+}
+
+func (t *TokenIssuer) sign(user *models.User, tokenType string, sessionID primitive.ObjectID, ttl time.Duration) (string, error) {
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims := utils.AccessClaims{
+		UserID:     user.ID.Hex(),
+		Type:       tokenType,
+		Generation: user.TokenGeneration,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        hex.EncodeToString(jti),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+	}
+
+	if !sessionID.IsZero() {
+		claims.SessionID = sessionID.Hex()
+		claims.Roles = user.EffectiveRoles()
+	}
+
+	return t.keyring.Sign(claims)
+}
+
+// ExpiresIn is the lifetime of an access token in seconds, as reported to clients
+func (t *TokenIssuer) ExpiresIn() int {
+	return int(t.accessTokenTTL.Seconds())
+}
@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/suraj/GoGoNotes/middleware"
+	"github.com/suraj/GoGoNotes/models"
+	"github.com/suraj/GoGoNotes/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// trashPageSize and trashMaxPageSize control the pagination of GET /trash
+const (
+	trashPageSize    = 20
+	trashMaxPageSize = 100
+)
+
+// TrashHandler lets users look through deleted notes, restore them or delete them for good.
+// Notes left in the trash are purged automatically once retention has passed.
+type TrashHandler struct {
+	noteModel *models.NoteModel
+	retention time.Duration
+}
+
+func NewTrashHandler(noteModel *models.NoteModel, retention time.Duration) *TrashHandler {
+	return &TrashHandler{
+		noteModel: noteModel,
+		retention: retention,
+	}
+}
+
+// GetTrash returns a page (?page=, ?limit=) of deleted notes, each with the time it will be purged
+func (h *TrashHandler) GetTrash(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserIDFromContext(r.Context())
+
+	page, err := utils.QueryInt(r, "page", 1, math.MaxInt32)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": err.Error(),
+			"notes":   []interface{}{},
+		})
+		return
+	}
+	limit, err := utils.QueryInt(r, "limit", trashPageSize, trashMaxPageSize)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": err.Error(),
+			"notes":   []interface{}{},
+		})
+		return
+	}
+
+	notes, err := h.noteModel.GetTrash(userID, page, limit)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Failed to fetch trash: " + err.Error(),
+			"notes":   []interface{}{},
+		})
+		return
+	}
+
+	items := make([]map[string]interface{}, len(notes))
+	for i, note := range notes {
+		items[i] = map[string]interface{}{
+			"note":     note,
+			"purge_at": note.DeletedAt.Add(h.retention),
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  true,
+		"message": "Trash fetched successfully",
+		"notes":   items,
+		"page":    page,
+		"limit":   limit,
+	})
+}
+
+func (h *TrashHandler) RestoreNote(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserIDFromContext(r.Context())
+
+	params := mux.Vars(r)
+	noteID, err := primitive.ObjectIDFromHex(params["id"])
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Invalid note ID",
+		})
+		return
+	}
+
+	note, err := h.noteModel.Restore(noteID, userID)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(noteErrorStatus(err))
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  true,
+		"message": "Note restored successfully",
+		"note":    note,
+	})
+}
+
+// DeleteNote permanently deletes a single note from the trash
+func (h *TrashHandler) DeleteNote(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserIDFromContext(r.Context())
+
+	params := mux.Vars(r)
+	noteID, err := primitive.ObjectIDFromHex(params["id"])
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": "Invalid note ID",
+		})
+		return
+	}
+
+	if err := h.noteModel.DeleteFromTrash(noteID, userID); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(noteErrorStatus(err))
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  true,
+		"message": "Note permanently deleted",
+	})
+}
+
+func (h *TrashHandler) EmptyTrash(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserIDFromContext(r.Context())
+
+	deleted, err := h.noteModel.EmptyTrash(userID)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  true,
+		"message": "Trash emptied successfully",
+		"deleted": deleted,
+	})
+}
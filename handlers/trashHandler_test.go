@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/suraj/GoGoNotes/internal/testutil"
+	"github.com/suraj/GoGoNotes/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestGetTrashShowsWhenNotesArePurged(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("purge_at", func(mt *mtest.T) {
+		retention := 30 * 24 * time.Hour
+		h := NewTrashHandler(models.NewNoteModel(mt.Coll, mt.Coll, mt.Coll, 0), retention)
+		userID := primitive.NewObjectID()
+		deletedAt := time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+		mt.AddMockResponses(testutil.FindResponse(models.Note{ID: primitive.NewObjectID(), UserID: userID, Title: "Groceries", DeletedAt: &deletedAt}))
+
+		w := httptest.NewRecorder()
+		h.GetTrash(w, sessionRequest(http.MethodGet, "", userID, primitive.NewObjectID()))
+
+		var body struct {
+			Notes []struct {
+				PurgeAt time.Time `json:"purge_at"`
+			} `json:"notes"`
+		}
+		json.NewDecoder(w.Body).Decode(&body)
+		if w.Code != http.StatusOK || len(body.Notes) != 1 || !body.Notes[0].PurgeAt.Equal(deletedAt.Add(retention)) {
+			mt.Fatalf("status %d: %+v", w.Code, body)
+		}
+
+		find := mt.GetStartedEvent().Command
+		if find.Lookup("filter", "user_id").ObjectID() != userID || find.Lookup("filter", "deleted_at", "$ne").Type != bson.TypeNull {
+			mt.Errorf("only the user's deleted notes belong in the trash, got %s", find)
+		}
+	})
+}
+
+func TestTrashErrorStatus(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	request := func(method string) *http.Request {
+		id := primitive.NewObjectID().Hex()
+		return mux.SetURLVars(httptest.NewRequest(method, "/trash/"+id, nil), map[string]string{"id": id})
+	}
+	dbError := mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 91, Message: "shutting down"})
+
+	mt.Run("delete a note that isn't in the trash", func(mt *mtest.T) {
+		h := NewTrashHandler(models.NewNoteModel(mt.Coll, mt.Coll, mt.Coll, 0), 30*24*time.Hour)
+		mt.AddMockResponses(testutil.FindResponse())
+
+		w := httptest.NewRecorder()
+		h.DeleteNote(w, request(http.MethodDelete))
+		if w.Code != http.StatusNotFound {
+			mt.Errorf("status %d, want 404", w.Code)
+		}
+	})
+
+	mt.Run("delete fails", func(mt *mtest.T) {
+		h := NewTrashHandler(models.NewNoteModel(mt.Coll, mt.Coll, mt.Coll, 0), 30*24*time.Hour)
+		mt.AddMockResponses(dbError)
+
+		w := httptest.NewRecorder()
+		h.DeleteNote(w, request(http.MethodDelete))
+		if w.Code != http.StatusInternalServerError {
+			mt.Errorf("status %d, want 500", w.Code)
+		}
+	})
+
+	mt.Run("restore a note that isn't in the trash", func(mt *mtest.T) {
+		h := NewTrashHandler(models.NewNoteModel(mt.Coll, mt.Coll, mt.Coll, 0), 30*24*time.Hour)
+		mt.AddMockResponses(testutil.UpdateResponse(0))
+
+		w := httptest.NewRecorder()
+		h.RestoreNote(w, request(http.MethodPost))
+		if w.Code != http.StatusNotFound {
+			mt.Errorf("status %d, want 404", w.Code)
+		}
+	})
+
+	mt.Run("restore fails", func(mt *mtest.T) {
+		h := NewTrashHandler(models.NewNoteModel(mt.Coll, mt.Coll, mt.Coll, 0), 30*24*time.Hour)
+		mt.AddMockResponses(dbError)
+
+		w := httptest.NewRecorder()
+		h.RestoreNote(w, request(http.MethodPost))
+		if w.Code != http.StatusInternalServerError {
+			mt.Errorf("status %d, want 500", w.Code)
+		}
+	})
+}
@@ -0,0 +1,39 @@
+// Package testutil builds the server replies that tests script on mtest mock clients.
+package testutil
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// FindResponse is the server's reply to a find returning the given models
+func FindResponse(models ...interface{}) bson.D {
+	docs := make([]bson.D, len(models))
+	for i, model := range models {
+		docs[i] = ToDoc(model)
+	}
+	return mtest.CreateCursorResponse(0, "test.coll", mtest.FirstBatch, docs...)
+}
+
+// UpdateResponse is the server's reply to an update that matched and modified n documents
+func UpdateResponse(n int) bson.D {
+	return bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: n}, {Key: "nModified", Value: n}}
+}
+
+// DeleteResponse is the server's reply to a delete that removed n documents
+func DeleteResponse(n int) bson.D {
+	return bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: n}}
+}
+
+// ToDoc converts a model to the document the server would return for it
+func ToDoc(v interface{}) bson.D {
+	data, err := bson.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	var doc bson.D
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		panic(err)
+	}
+	return doc
+}
@@ -0,0 +1,49 @@
+package mailer
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// Mailer delivers plain text emails
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// NewFromEnv picks the mailer implementation from the MAILER environment variable:
+//
+//	smtp   deliver through SMTP_HOST / SMTP_PORT / SMTP_USERNAME / SMTP_PASSWORD / SMTP_FROM
+//	file   append every message to MAILER_FILE, handy for local development and tests
+//	log    (default) write every message to the server log
+func NewFromEnv() (Mailer, error) {
+	switch os.Getenv("MAILER") {
+	case "smtp":
+		port, err := strconv.Atoi(os.Getenv("SMTP_PORT"))
+		if err != nil {
+			port = 587
+		}
+		if os.Getenv("SMTP_HOST") == "" || os.Getenv("SMTP_FROM") == "" {
+			return nil, fmt.Errorf("SMTP_HOST and SMTP_FROM are required for the smtp mailer")
+		}
+		return NewSMTPMailer(
+			os.Getenv("SMTP_HOST"),
+			port,
+			os.Getenv("SMTP_USERNAME"),
+			os.Getenv("SMTP_PASSWORD"),
+			os.Getenv("SMTP_FROM"),
+		), nil
+
+	case "file":
+		path := os.Getenv("MAILER_FILE")
+		if path == "" {
+			return nil, fmt.Errorf("MAILER_FILE is required for the file mailer")
+		}
+		return NewFileMailer(path), nil
+
+	case "", "log":
+		return NewLogMailer(), nil
+	}
+
+	return nil, fmt.Errorf("unknown mailer %q", os.Getenv("MAILER"))
+}
@@ -4,13 +4,20 @@ import (
 	"context"
 	"log"
 	"net/http"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
 	"github.com/suraj/GoGoNotes/database"
 	"github.com/suraj/GoGoNotes/handlers"
+	"github.com/suraj/GoGoNotes/mailer"
+	"github.com/suraj/GoGoNotes/middleware"
 	"github.com/suraj/GoGoNotes/models"
+	"github.com/suraj/GoGoNotes/oidc"
 	"github.com/suraj/GoGoNotes/routes"
+	"github.com/suraj/GoGoNotes/utils"
 )
 
 func main() {
@@ -20,25 +27,226 @@ func main() {
 	}
 
 	// Connect to MongoDB
-	client, userCollection, noteCollection := database.Connect()
+	client, collections := database.Connect()
 	defer client.Disconnect(context.Background())
 
+	// Token lifetimes
+	accessTokenTTL := utils.GetEnvDuration("ACCESS_TOKEN_TTL", 15*time.Minute)
+	refreshTokenTTL := utils.GetEnvDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour)
+	passwordResetTTL := utils.GetEnvDuration("PASSWORD_RESET_TTL", time.Hour)
+	emailVerificationTTL := utils.GetEnvDuration("EMAIL_VERIFICATION_TTL", 48*time.Hour)
+	oidcLoginTTL := utils.GetEnvDuration("OIDC_LOGIN_TTL", 10*time.Minute)
+
+	// Deleted notes stay in the trash this long before they are purged for good
+	trashRetention := utils.GetEnvDuration("TRASH_RETENTION", 30*24*time.Hour)
+	trashPurgeInterval := utils.GetEnvDuration("TRASH_PURGE_INTERVAL", time.Hour)
+
+	// How many revisions are kept per note, 0 keeps every revision
+	noteRevisionLimit := utils.GetEnvNonNegativeInt("NOTE_REVISION_LIMIT", 50)
+
+	// Block note creation until the user has confirmed their email address
+	requireVerifiedEmail := utils.GetEnvBool("REQUIRE_EMAIL_VERIFICATION", false)
+
+	// Public URL of the app, used to build links in emails
+	appBaseURL := utils.GetEnv("APP_BASE_URL", "http://localhost:8080")
+	// Password reset links open the frontend, which posts the new password to /password/reset
+	frontendURL := strings.TrimSuffix(utils.GetEnv("FRONTEND_URL", "http://localhost:3000"), "/")
+
+	// Brute-force protection for logins. IPs get a higher threshold since many users can share one.
+	lockoutBase := utils.GetEnvDuration("LOGIN_LOCKOUT_BASE", time.Minute)
+	lockoutMax := utils.GetEnvDuration("LOGIN_LOCKOUT_MAX", time.Hour)
+	accountLockout := models.LockoutPolicy{
+		Threshold: utils.GetEnvInt("LOGIN_LOCKOUT_THRESHOLD", 5),
+		BaseDelay: lockoutBase,
+		MaxDelay:  lockoutMax,
+	}
+	ipLockout := models.LockoutPolicy{
+		Threshold: utils.GetEnvInt("LOGIN_IP_LOCKOUT_THRESHOLD", 20),
+		BaseDelay: lockoutBase,
+		MaxDelay:  lockoutMax,
+	}
+
+	// Throttling of password reset emails
+	forgotPasswordWindow := utils.GetEnvDuration("FORGOT_PASSWORD_WINDOW", time.Hour)
+	forgotPasswordEmailLimit := models.RateLimit{Limit: utils.GetEnvInt("FORGOT_PASSWORD_EMAIL_LIMIT", 3), Window: forgotPasswordWindow}
+	forgotPasswordIPLimit := models.RateLimit{Limit: utils.GetEnvInt("FORGOT_PASSWORD_IP_LIMIT", 20), Window: forgotPasswordWindow}
+
+	// Password policy, optionally backed by a local Pwned Passwords style range directory
+	passwordPolicy := &utils.PasswordPolicy{MinLength: utils.GetEnvInt("PASSWORD_MIN_LENGTH", 8)}
+	if dir := os.Getenv("BREACHED_PASSWORDS_DIR"); dir != "" {
+		breached, err := utils.NewBreachedPasswords(dir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		passwordPolicy.Breached = breached
+	}
+
+	// X-Forwarded-For is only trusted from our own reverse proxies
+	if os.Getenv("TRUST_PROXY_HEADERS") != "" {
+		log.Fatal("TRUST_PROXY_HEADERS was replaced by TRUSTED_PROXIES, set it to the IPs or CIDR ranges of your reverse proxies")
+	}
+	trustedProxies, err := middleware.ParseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	// Create Models
-	userModel := models.NewUserModel(userCollection)
-	noteModel := models.NewNoteModel(noteCollection, userCollection)
+	userModel := models.NewUserModel(collections.Users)
+	noteModel := models.NewNoteModel(collections.Notes, collections.Users, collections.NoteRevisions, noteRevisionLimit)
+	notebookModel := models.NewNotebookModel(client, collections.Notebooks, collections.Notes)
+	sessionModel := models.NewSessionModel(collections.Sessions, refreshTokenTTL)
+	refreshTokenModel := models.NewRefreshTokenModel(collections.RefreshTokens, refreshTokenTTL)
+	revokedTokenModel := models.NewRevokedTokenModel(collections.RevokedTokens)
+	userTokenModel := models.NewUserTokenModel(collections.UserTokens)
+	personalAccessTokenModel := models.NewPersonalAccessTokenModel(collections.PersonalAccessTokens)
+	loginAttemptModel := models.NewLoginAttemptModel(collections.LoginAttempts, accountLockout, ipLockout)
+	rateLimitModel := models.NewRateLimitModel(collections.RateLimits)
+	oidcLoginModel := models.NewOIDCLoginModel(collections.OIDCLogins, oidcLoginTTL)
+	externalIdentityModel := models.NewExternalIdentityModel(collections.ExternalIdentities)
+	accountModel := models.NewAccountModel(client, collections.Users,
+		collections.Notes,
+		collections.Notebooks,
+		collections.NoteRevisions,
+		collections.Sessions,
+		collections.RefreshTokens,
+		collections.RevokedTokens,
+		collections.UserTokens,
+		collections.PersonalAccessTokens,
+		collections.ExternalIdentities,
+	)
+
+	if err := userModel.EnsureIndexes(); err != nil {
+		log.Fatal(err)
+	}
+	if err := noteModel.EnsureIndexes(); err != nil {
+		log.Fatal(err)
+	}
+	if err := notebookModel.EnsureIndexes(); err != nil {
+		log.Fatal(err)
+	}
+	if err := sessionModel.EnsureIndexes(); err != nil {
+		log.Fatal(err)
+	}
+	if err := refreshTokenModel.EnsureIndexes(); err != nil {
+		log.Fatal(err)
+	}
+	if err := revokedTokenModel.EnsureIndexes(); err != nil {
+		log.Fatal(err)
+	}
+	if err := userTokenModel.EnsureIndexes(); err != nil {
+		log.Fatal(err)
+	}
+	if err := personalAccessTokenModel.EnsureIndexes(); err != nil {
+		log.Fatal(err)
+	}
+	if err := loginAttemptModel.EnsureIndexes(); err != nil {
+		log.Fatal(err)
+	}
+	if err := rateLimitModel.EnsureIndexes(); err != nil {
+		log.Fatal(err)
+	}
+	if err := oidcLoginModel.EnsureIndexes(); err != nil {
+		log.Fatal(err)
+	}
+	if err := externalIdentityModel.EnsureIndexes(); err != nil {
+		log.Fatal(err)
+	}
+
+	// Operators listed in ADMIN_EMAILS are made admins on startup, once they have registered
+	for _, email := range strings.Split(os.Getenv("ADMIN_EMAILS"), ",") {
+		email = strings.TrimSpace(email)
+		if email == "" {
+			continue
+		}
+		granted, err := userModel.GrantRoleByEmail(email, models.RoleAdmin)
+		if err != nil {
+			log.Fatalf("Failed to grant admin role to %s: %v", email, err)
+		}
+		if granted {
+			log.Printf("Granted admin role to %s", email)
+		}
+	}
+
+	// Load the JWT signing and verification keys
+	keyring, err := utils.LoadKeyring()
+	if err != nil {
+		log.Fatalf("Failed to load JWT keys: %v", err)
+	}
 
-	// Define your JWT secret key (keep it safe and strong)
-	jwtSecret := []byte("your-secret-key") // Replace with a secure secret
+	// Outgoing email
+	mail, err := mailer.NewFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to configure mailer: %v", err)
+	}
+
+	// External identity providers for "Sign in with ..."
+	oidcProviders, err := oidc.ProvidersFromEnv(appBaseURL)
+	if err != nil {
+		log.Fatalf("Failed to configure OIDC providers: %v", err)
+	}
 
 	// Create handlers with JWT-based auth
-	authHandler := handlers.NewAuthHandler(userModel, jwtSecret)
-	noteHandler := handlers.NewNoteHandler(noteModel, jwtSecret)
+	emailVerificationHandler := handlers.NewEmailVerificationHandler(userModel, userTokenModel, mail, emailVerificationTTL, appBaseURL)
+	tokenIssuer := handlers.NewTokenIssuer(userModel, sessionModel, refreshTokenModel, keyring, accessTokenTTL)
+	authHandler := handlers.NewAuthHandler(userModel, sessionModel, refreshTokenModel, revokedTokenModel, loginAttemptModel, emailVerificationHandler, tokenIssuer, passwordPolicy)
+	accountHandler := handlers.NewAccountHandler(accountModel, userModel, userTokenModel, sessionModel, refreshTokenModel, personalAccessTokenModel, tokenIssuer, mail, passwordPolicy, emailVerificationTTL, appBaseURL)
+	mfaHandler := handlers.NewMFAHandler(userModel, sessionModel, revokedTokenModel, loginAttemptModel, tokenIssuer, keyring, utils.GetEnv("TOTP_ISSUER", "GoGoNotes"))
+	oidcHandler := handlers.NewOIDCHandler(oidcProviders, oidcLoginModel, externalIdentityModel, userModel, tokenIssuer)
+	sessionHandler := handlers.NewSessionHandler(sessionModel, refreshTokenModel)
+	personalAccessTokenHandler := handlers.NewPersonalAccessTokenHandler(personalAccessTokenModel)
+	jwksHandler := handlers.NewJWKSHandler(keyring)
+	passwordHandler := handlers.NewPasswordHandler(userModel, userTokenModel, sessionModel, refreshTokenModel, personalAccessTokenModel, loginAttemptModel, rateLimitModel, forgotPasswordEmailLimit, forgotPasswordIPLimit, mail, passwordPolicy, passwordResetTTL, frontendURL+"/reset-password")
+	adminHandler := handlers.NewAdminHandler(userModel, noteModel, sessionModel, refreshTokenModel, personalAccessTokenModel, accountModel, passwordHandler)
+	noteHandler := handlers.NewNoteHandler(noteModel, notebookModel)
+	tagHandler := handlers.NewTagHandler(noteModel)
+	notebookHandler := handlers.NewNotebookHandler(notebookModel)
+	trashHandler := handlers.NewTrashHandler(noteModel, trashRetention)
+	revisionHandler := handlers.NewRevisionHandler(noteModel)
+
+	// Auth middleware for protected routes
+	authMiddleware := middleware.NewAuthMiddleware(keyring, userModel, revokedTokenModel, sessionModel, personalAccessTokenModel, requireVerifiedEmail)
 
 	// configure router
 	r := mux.NewRouter()
-	routes.Setup(r, authHandler, noteHandler)
+	r.Use(middleware.RealIP(trustedProxies))
+	routes.Setup(r, authMiddleware, &routes.Handlers{
+		Auth:                authHandler,
+		JWKS:                jwksHandler,
+		Password:            passwordHandler,
+		EmailVerification:   emailVerificationHandler,
+		Account:             accountHandler,
+		MFA:                 mfaHandler,
+		OIDC:                oidcHandler,
+		Session:             sessionHandler,
+		PersonalAccessToken: personalAccessTokenHandler,
+		Admin:               adminHandler,
+		Note:                noteHandler,
+		Tag:                 tagHandler,
+		Notebook:            notebookHandler,
+		Trash:               trashHandler,
+		Revision:            revisionHandler,
+	})
+
+	go purgeTrash(noteModel, trashRetention, trashPurgeInterval)
 
 	// start server
 	log.Println("Server starting at port 8080...")
 	log.Fatal(http.ListenAndServe(":8080", r))
 }
+
+// purgeTrash permanently deletes notes that have been in the trash longer than
+// retention, checking every interval for as long as the server runs
+func purgeTrash(noteModel *models.NoteModel, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := noteModel.PurgeTrash(time.Now().Add(-retention))
+		if err != nil {
+			log.Printf("Failed to purge trash: %v", err)
+		} else if purged > 0 {
+			log.Printf("Purged %d notes from the trash", purged)
+		}
+		<-ticker.C
+	}
+}
@@ -0,0 +1,277 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/suraj/GoGoNotes/models"
+	"github.com/suraj/GoGoNotes/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type contextKey string
+
+const identityKey contextKey = "identity"
+
+// Identity describes the authenticated caller of a request
+type Identity struct {
+	UserID        primitive.ObjectID
+	TokenID       string             // jti of the session token, empty for personal access tokens
+	SessionID     primitive.ObjectID // zero for personal access tokens
+	ExpiresAt     time.Time
+	EmailVerified bool
+	Scopes        []string
+	Roles         []string
+	// PersonalAccessTokenID is set when the request was made with a personal access token
+	PersonalAccessTokenID primitive.ObjectID
+}
+
+// IsSession reports whether the caller used a session token rather than a personal access token
+func (i *Identity) IsSession() bool {
+	return i.PersonalAccessTokenID.IsZero()
+}
+
+func (i *Identity) HasScope(scope string) bool {
+	for _, s := range i.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func (i *Identity) HasRole(role string) bool {
+	for _, r := range i.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+type AuthMiddleware struct {
+	keyring                  *utils.Keyring
+	userModel                *models.UserModel
+	revokedTokenModel        *models.RevokedTokenModel
+	sessionModel             *models.SessionModel
+	personalAccessTokenModel *models.PersonalAccessTokenModel
+	requireVerifiedEmail     bool
+}
+
+func NewAuthMiddleware(keyring *utils.Keyring, userModel *models.UserModel, revokedTokenModel *models.RevokedTokenModel, sessionModel *models.SessionModel, personalAccessTokenModel *models.PersonalAccessTokenModel, requireVerifiedEmail bool) *AuthMiddleware {
+	return &AuthMiddleware{
+		keyring:                  keyring,
+		userModel:                userModel,
+		revokedTokenModel:        revokedTokenModel,
+		sessionModel:             sessionModel,
+		personalAccessTokenModel: personalAccessTokenModel,
+		requireVerifiedEmail:     requireVerifiedEmail,
+	}
+}
+
+// Authenticate validates the access token once and stores the caller's identity in the request context
+func (m *AuthMiddleware) Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, err := m.verify(r)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"status":  false,
+				"message": "Unauthorized: " + err.Error(),
+			})
+			return
+		}
+
+		ctx := WithIdentity(r.Context(), identity)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (m *AuthMiddleware) verify(r *http.Request) (*Identity, error) {
+	tokenString, err := utils.BearerToken(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.HasPrefix(tokenString, models.PersonalAccessTokenPrefix) {
+		return m.verifyPersonalAccessToken(tokenString)
+	}
+
+	claims, err := utils.ParseToken(tokenString, utils.TokenTypeAccess, m.keyring)
+	if err != nil {
+		return nil, err
+	}
+
+	revoked, err := m.revokedTokenModel.IsRevoked(claims.TokenID)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, errors.New("token has been revoked")
+	}
+
+	// "Log out all devices" bumps the generation, which retires every older token
+	user, err := m.userModel.GetByID(claims.UserID)
+	if err != nil {
+		return nil, errors.New("user not found")
+	}
+	if claims.Generation != user.TokenGeneration {
+		return nil, errors.New("token has been revoked")
+	}
+	if user.Disabled {
+		return nil, models.ErrAccountDisabled
+	}
+
+	// Signing out a device deletes its session, which ends its access tokens right away
+	if claims.SessionID.IsZero() {
+		return nil, errors.New("token has no session")
+	}
+	if _, err := m.sessionModel.Touch(claims.SessionID, claims.UserID, utils.ClientIP(r)); err != nil {
+		if err == models.ErrSessionNotFound {
+			return nil, errors.New("session has ended")
+		}
+		return nil, err
+	}
+
+	return &Identity{
+		UserID:        claims.UserID,
+		TokenID:       claims.TokenID,
+		SessionID:     claims.SessionID,
+		ExpiresAt:     claims.ExpiresAt,
+		EmailVerified: user.EmailVerified,
+		Scopes:        models.AllScopes,
+		Roles:         claims.Roles,
+	}, nil
+}
+
+func (m *AuthMiddleware) verifyPersonalAccessToken(tokenString string) (*Identity, error) {
+	pat, err := m.personalAccessTokenModel.Authenticate(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := m.userModel.GetByID(pat.UserID)
+	if err != nil {
+		return nil, errors.New("user not found")
+	}
+	if user.Disabled {
+		return nil, models.ErrAccountDisabled
+	}
+
+	identity := &Identity{
+		UserID:                pat.UserID,
+		EmailVerified:         user.EmailVerified,
+		Scopes:                pat.Scopes,
+		Roles:                 []string{models.RoleUser}, // personal access tokens never carry elevated roles
+		PersonalAccessTokenID: pat.ID,
+	}
+	if pat.ExpiresAt != nil {
+		identity.ExpiresAt = *pat.ExpiresAt
+	}
+	return identity, nil
+}
+
+// RequireScope rejects callers whose token wasn't granted the scope. Must be used behind Authenticate.
+func (m *AuthMiddleware) RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			identity, ok := IdentityFromContext(r.Context())
+			if !ok || !identity.HasScope(scope) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusForbidden)
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"status":  false,
+					"message": "Token is missing the " + scope + " scope",
+				})
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireRole rejects callers that don't have the role. Must be used behind Authenticate.
+func (m *AuthMiddleware) RequireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			identity, ok := IdentityFromContext(r.Context())
+			if !ok || !identity.HasRole(role) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusForbidden)
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"status":  false,
+					"message": "This endpoint requires the " + role + " role",
+				})
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireSession keeps personal access tokens away from account and security
+// settings, which need a real login. Must be used behind Authenticate.
+func (m *AuthMiddleware) RequireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, ok := IdentityFromContext(r.Context())
+		if !ok || !identity.IsSession() {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"status":  false,
+				"message": "This endpoint can't be used with a personal access token",
+			})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireVerifiedEmail rejects users who haven't confirmed their email address yet.
+// It only has an effect when the server is configured to require verification and
+// must be used behind Authenticate.
+func (m *AuthMiddleware) RequireVerifiedEmail(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, ok := IdentityFromContext(r.Context())
+		if m.requireVerifiedEmail && (!ok || !identity.EmailVerified) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"status":  false,
+				"message": "Please verify your email address first",
+			})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// WithIdentity returns a copy of ctx carrying identity, as Authenticate does for each request
+func WithIdentity(ctx context.Context, identity *Identity) context.Context {
+	return context.WithValue(ctx, identityKey, identity)
+}
+
+// IdentityFromContext returns the identity stored by Authenticate
+func IdentityFromContext(ctx context.Context) (*Identity, bool) {
+	identity, ok := ctx.Value(identityKey).(*Identity)
+	return identity, ok
+}
+
+// UserIDFromContext returns the authenticated user's ID, or primitive.NilObjectID
+// when called outside of a route protected by Authenticate
+func UserIDFromContext(ctx context.Context) primitive.ObjectID {
+	identity, ok := IdentityFromContext(ctx)
+	if !ok {
+		return primitive.NilObjectID
+	}
+	return identity.UserID
+}
@@ -0,0 +1,321 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/suraj/GoGoNotes/internal/testutil"
+	"github.com/suraj/GoGoNotes/models"
+	"github.com/suraj/GoGoNotes/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func testKeyring(t testing.TB) *utils.Keyring {
+	t.Helper()
+	return testKeyringWithSecret(t, "0123456789abcdef0123456789abcdef")
+}
+
+func testKeyringWithSecret(t testing.TB, secret string) *utils.Keyring {
+	t.Helper()
+	key, err := utils.NewHMACKey("test", []byte(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring, err := utils.NewKeyring([]*utils.Key{key}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyring
+}
+
+// accessToken signs an access token for the user's session, as the token issuer does
+func accessToken(t testing.TB, keyring *utils.Keyring, user models.User, sessionID primitive.ObjectID) string {
+	t.Helper()
+	return signToken(t, keyring, utils.TokenTypeAccess, user, sessionID)
+}
+
+func signToken(t testing.TB, keyring *utils.Keyring, tokenType string, user models.User, sessionID primitive.ObjectID) string {
+	t.Helper()
+	now := time.Now()
+	token, err := keyring.Sign(utils.AccessClaims{
+		UserID:     user.ID.Hex(),
+		Type:       tokenType,
+		Generation: user.TokenGeneration,
+		SessionID:  sessionID.Hex(),
+		Roles:      user.EffectiveRoles(),
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        "jti",
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func authTestMiddleware(mt *mtest.T, keyring *utils.Keyring) *AuthMiddleware {
+	return NewAuthMiddleware(keyring, models.NewUserModel(mt.Coll), models.NewRevokedTokenModel(mt.Coll),
+		models.NewSessionModel(mt.Coll, time.Hour), models.NewPersonalAccessTokenModel(mt.Coll), false)
+}
+
+// authenticate runs the request through Authenticate and returns the response and
+// the identity the next handler saw, if it was reached
+func authenticate(m *AuthMiddleware, r *http.Request) (*httptest.ResponseRecorder, *Identity) {
+	var identity *Identity
+	handler := m.Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, _ = IdentityFromContext(r.Context())
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w, identity
+}
+
+func TestAuthenticate(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	user := models.User{ID: primitive.NewObjectID(), Email: "bob@example.com", TokenGeneration: 2}
+	sessionID := primitive.NewObjectID()
+	session := models.Session{ID: sessionID, UserID: user.ID, IP: "192.0.2.1", LastSeenAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)}
+	notRevoked := mtest.CreateCursorResponse(0, "test.revoked_tokens", mtest.FirstBatch)
+	revoked := testutil.FindResponse(bson.D{{Key: "_id", Value: "jti"}})
+
+	olderGeneration := user
+	olderGeneration.TokenGeneration = 1
+	disabled := user
+	disabled.Disabled = true
+
+	tests := []struct {
+		name      string
+		token     func(keyring *utils.Keyring) string
+		responses []bson.D
+		wantError string
+	}{
+		{
+			"valid session token",
+			func(keyring *utils.Keyring) string { return accessToken(mt, keyring, user, sessionID) },
+			[]bson.D{notRevoked, testutil.FindResponse(user), testutil.FindResponse(session)},
+			"",
+		},
+		{
+			"revoked by logout",
+			func(keyring *utils.Keyring) string { return accessToken(mt, keyring, user, sessionID) },
+			[]bson.D{revoked},
+			"Unauthorized: token has been revoked",
+		},
+		{
+			"issued before logging out everywhere",
+			func(keyring *utils.Keyring) string { return accessToken(mt, keyring, olderGeneration, sessionID) },
+			[]bson.D{notRevoked, testutil.FindResponse(user)},
+			"Unauthorized: token has been revoked",
+		},
+		{
+			"device signed out",
+			func(keyring *utils.Keyring) string { return accessToken(mt, keyring, user, sessionID) },
+			[]bson.D{notRevoked, testutil.FindResponse(user), testutil.FindResponse()},
+			"Unauthorized: session has ended",
+		},
+		{
+			"no token",
+			func(keyring *utils.Keyring) string { return "" },
+			nil,
+			"Unauthorized: missing Authorization header",
+		},
+		{
+			"garbled token",
+			func(keyring *utils.Keyring) string { return "not.a.jwt" },
+			nil,
+			"Unauthorized: invalid or expired token",
+		},
+		{
+			"signed with another key",
+			func(keyring *utils.Keyring) string {
+				return accessToken(mt, testKeyringWithSecret(mt, "another secret of 32 bytes or more"), user, sessionID)
+			},
+			nil,
+			"Unauthorized: invalid or expired token",
+		},
+		{
+			"MFA challenge token",
+			func(keyring *utils.Keyring) string {
+				return signToken(mt, keyring, utils.TokenTypeMFA, user, sessionID)
+			},
+			nil,
+			"Unauthorized: wrong token type",
+		},
+		{
+			"disabled account",
+			func(keyring *utils.Keyring) string { return accessToken(mt, keyring, disabled, sessionID) },
+			[]bson.D{notRevoked, testutil.FindResponse(disabled)},
+			"Unauthorized: " + models.ErrAccountDisabled.Error(),
+		},
+	}
+
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			keyring := testKeyring(mt)
+			m := authTestMiddleware(mt, keyring)
+			mt.AddMockResponses(tt.responses...)
+
+			r := httptest.NewRequest(http.MethodGet, "/notes", nil)
+			r.RemoteAddr = "192.0.2.1:4000"
+			if token := tt.token(keyring); token != "" {
+				r.Header.Set("Authorization", "Bearer "+token)
+			}
+			w, identity := authenticate(m, r)
+
+			if tt.wantError != "" {
+				if w.Code != http.StatusUnauthorized || identity != nil || message(w) != tt.wantError {
+					mt.Errorf("status %d, identity %+v: %s", w.Code, identity, w.Body)
+				}
+				return
+			}
+			if w.Code != http.StatusOK || identity == nil || identity.UserID != user.ID || identity.SessionID != sessionID || identity.TokenID != "jti" || !identity.HasRole(models.RoleUser) {
+				mt.Errorf("status %d, identity %+v: %s", w.Code, identity, w.Body)
+			}
+		})
+	}
+}
+
+func TestUserIDFromContextOutsideAuthenticate(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if id := UserIDFromContext(r.Context()); id != primitive.NilObjectID {
+		t.Errorf("got %s", id.Hex())
+	}
+	if _, ok := IdentityFromContext(r.Context()); ok {
+		t.Error("a request that wasn't authenticated has no identity")
+	}
+}
+
+// message returns the message of a JSON error response
+func message(w *httptest.ResponseRecorder) string {
+	var body struct {
+		Message string `json:"message"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &body)
+	return body.Message
+}
+
+func TestAuthenticatePersonalAccessToken(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	admin := models.User{ID: primitive.NewObjectID(), Email: "bob@example.com", Roles: []string{models.RoleAdmin}, EmailVerified: true}
+	recently := time.Now()
+	expired := time.Now().Add(-time.Minute)
+	readOnly := models.PersonalAccessToken{ID: primitive.NewObjectID(), UserID: admin.ID, Scopes: []string{models.ScopeNotesRead}, LastUsedAt: &recently}
+	revoked := readOnly
+	revoked.RevokedAt = &recently
+	outdated := readOnly
+	outdated.ExpiresAt = &expired
+
+	tests := []struct {
+		name      string
+		responses []bson.D
+		wantError string
+	}{
+		{"valid", []bson.D{testutil.FindResponse(readOnly), testutil.FindResponse(admin)}, ""},
+		{"unknown", []bson.D{testutil.FindResponse()}, "Unauthorized: " + models.ErrPersonalAccessTokenInvalid.Error()},
+		{"revoked", []bson.D{testutil.FindResponse(revoked)}, "Unauthorized: " + models.ErrPersonalAccessTokenInvalid.Error()},
+		{"expired", []bson.D{testutil.FindResponse(outdated)}, "Unauthorized: " + models.ErrPersonalAccessTokenInvalid.Error()},
+	}
+
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			m := authTestMiddleware(mt, testKeyring(mt))
+			mt.AddMockResponses(tt.responses...)
+
+			r := httptest.NewRequest(http.MethodGet, "/notes", nil)
+			r.Header.Set("Authorization", "Bearer "+models.PersonalAccessTokenPrefix+"secret")
+			w, identity := authenticate(m, r)
+
+			if tt.wantError != "" {
+				if w.Code != http.StatusUnauthorized || identity != nil || message(w) != tt.wantError {
+					mt.Errorf("status %d, identity %+v: %s", w.Code, identity, w.Body)
+				}
+				return
+			}
+			if w.Code != http.StatusOK || identity == nil {
+				mt.Fatalf("status %d: %s", w.Code, w.Body)
+			}
+			if identity.IsSession() || identity.PersonalAccessTokenID != readOnly.ID || identity.UserID != admin.ID {
+				mt.Errorf("identity %+v is not the token's", identity)
+			}
+			if !identity.HasScope(models.ScopeNotesRead) || identity.HasScope(models.ScopeNotesWrite) {
+				mt.Errorf("scopes %v, want only the token's", identity.Scopes)
+			}
+			if identity.HasRole(models.RoleAdmin) {
+				mt.Error("personal access tokens must not carry the admin role")
+			}
+		})
+	}
+}
+
+func TestRequireScopeAndSession(t *testing.T) {
+	m := &AuthMiddleware{}
+	session := &Identity{UserID: primitive.NewObjectID(), SessionID: primitive.NewObjectID(), Scopes: models.AllScopes}
+	readOnlyToken := &Identity{UserID: primitive.NewObjectID(), Scopes: []string{models.ScopeNotesRead}, PersonalAccessTokenID: primitive.NewObjectID()}
+
+	tests := []struct {
+		name       string
+		middleware func(http.Handler) http.Handler
+		identity   *Identity
+		want       int
+	}{
+		{"session writes notes", m.RequireScope(models.ScopeNotesWrite), session, http.StatusOK},
+		{"read-only token reads notes", m.RequireScope(models.ScopeNotesRead), readOnlyToken, http.StatusOK},
+		{"read-only token writes notes", m.RequireScope(models.ScopeNotesWrite), readOnlyToken, http.StatusForbidden},
+		{"session changes settings", m.RequireSession, session, http.StatusOK},
+		{"token changes settings", m.RequireSession, readOnlyToken, http.StatusForbidden},
+		{"no identity", m.RequireScope(models.ScopeNotesRead), nil, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		handler := tt.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.identity != nil {
+			r = r.WithContext(WithIdentity(r.Context(), tt.identity))
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if w.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	m := &AuthMiddleware{}
+	tests := []struct {
+		name     string
+		identity *Identity
+		want     int
+	}{
+		{"admin", &Identity{Roles: []string{models.RoleUser, models.RoleAdmin}}, http.StatusOK},
+		{"user", &Identity{Roles: []string{models.RoleUser}}, http.StatusForbidden},
+		{"no roles", &Identity{}, http.StatusForbidden},
+		{"no identity", nil, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		handler := m.RequireRole(models.RoleAdmin)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		r := httptest.NewRequest(http.MethodGet, "/admin/users", nil)
+		if tt.identity != nil {
+			r = r.WithContext(WithIdentity(r.Context(), tt.identity))
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if w.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
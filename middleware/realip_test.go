@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRealIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies("10.0.0.0/8, 192.0.2.1, 2001:db8::/32")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		realIP     string
+		want       string
+	}{
+		{"direct client", "203.0.113.9:4000", nil, "", "203.0.113.9:4000"},
+		{"untrusted peer can't claim an address", "203.0.113.9:4000", []string{"198.51.100.7"}, "198.51.100.7", "203.0.113.9:4000"},
+		{"single proxy", "192.0.2.1:4000", []string{"198.51.100.7"}, "", "198.51.100.7:0"},
+		{"spoofed entries on the left are ignored", "192.0.2.1:4000", []string{"1.2.3.4, 5.6.7.8, 198.51.100.7"}, "", "198.51.100.7:0"},
+		{"chain of trusted proxies", "10.0.0.2:4000", []string{"6.6.6.6, 198.51.100.7, 10.0.0.5", "10.0.0.3"}, "", "198.51.100.7:0"},
+		{"only proxies", "10.0.0.2:4000", []string{"10.0.0.9, 10.0.0.5"}, "", "10.0.0.9:0"},
+		{"IPv6 client", "[2001:db8::1]:4000", []string{"2001:db9::7"}, "", "[2001:db9::7]:0"},
+		{"garbled entry", "192.0.2.1:4000", []string{"198.51.100.7, nonsense"}, "", "192.0.2.1:4000"},
+		{"X-Real-IP from a trusted proxy", "192.0.2.1:4000", nil, "198.51.100.7", "198.51.100.7:0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			handler := RealIP(proxies)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.RemoteAddr
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+
+			if got != tt.want {
+				t.Errorf("RemoteAddr = %s, want %s", got, tt.want)
+			}
+			if r.RemoteAddr != tt.remoteAddr {
+				t.Errorf("the caller's request was modified: %s", r.RemoteAddr)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	if proxies, err := ParseTrustedProxies(""); err != nil || len(proxies) != 0 {
+		t.Errorf("empty list = %v, %v", proxies, err)
+	}
+	for _, list := range []string{"10.0.0.0/33", "proxy.internal", "10.0.0.1,,nope"} {
+		if _, err := ParseTrustedProxies(list); err == nil {
+			t.Errorf("%q was accepted", list)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package models
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// AccountModel removes a user together with everything they own
+type AccountModel struct {
+	client           *mongo.Client
+	userCollection   *mongo.Collection
+	ownedCollections []*mongo.Collection
+}
+
+// NewAccountModel takes every collection whose documents belong to a user through
+// a user_id field. New collections of user data must be added here so deleting
+// an account never leaves anything behind.
+func NewAccountModel(client *mongo.Client, userCollection *mongo.Collection, ownedCollections ...*mongo.Collection) *AccountModel {
+	return &AccountModel{
+		client:           client,
+		userCollection:   userCollection,
+		ownedCollections: ownedCollections,
+	}
+}
+
+// Delete removes the user and all of their data in a single transaction, so an
+// interrupted deletion never leaves orphaned notes behind. Transactions need a
+// replica set or sharded cluster (Atlas always is one).
+func (m *AccountModel) Delete(userID primitive.ObjectID) error {
+	session, err := m.client.StartSession()
+	if err != nil {
+		return fmt.Errorf("failed to start session: %v", err)
+	}
+	defer session.EndSession(context.Background())
+
+	_, err = session.WithTransaction(context.Background(), func(ctx mongo.SessionContext) (interface{}, error) {
+		for _, collection := range m.ownedCollections {
+			if _, err := collection.DeleteMany(ctx, bson.M{"user_id": userID}); err != nil {
+				return nil, fmt.Errorf("failed to delete from %s: %v", collection.Name(), err)
+			}
+		}
+
+		result, err := m.userCollection.DeleteOne(ctx, bson.M{"_id": userID})
+		if err != nil {
+			return nil, fmt.Errorf("failed to delete user: %v", err)
+		}
+		if result.DeletedCount == 0 {
+			return nil, ErrUserNotFound
+		}
+
+		return nil, nil
+	})
+
+	return err
+}
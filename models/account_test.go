@@ -0,0 +1,49 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/suraj/GoGoNotes/internal/testutil"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestDeleteAccount(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	userID := primitive.NewObjectID()
+
+	mt.Run("owned documents go first", func(mt *mtest.T) {
+		notes := mt.Client.Database("test").Collection("notes")
+		sessions := mt.Client.Database("test").Collection("sessions")
+		m := NewAccountModel(mt.Client, mt.Coll, notes, sessions)
+		mt.AddMockResponses(testutil.DeleteResponse(5), testutil.DeleteResponse(2), testutil.DeleteResponse(1), mtest.CreateSuccessResponse())
+
+		if err := m.Delete(userID); err != nil {
+			mt.Fatal(err)
+		}
+
+		for _, collection := range []string{"notes", "sessions"} {
+			event := mt.GetStartedEvent()
+			if event.Command.Lookup("delete").StringValue() != collection || event.Command.Lookup("deletes", "0", "q", "user_id").ObjectID() != userID {
+				mt.Errorf("expected the user's %s to be deleted, got %s", collection, event.Command)
+			}
+		}
+		user := mt.GetStartedEvent()
+		if user.Command.Lookup("delete").StringValue() != mt.Coll.Name() || user.Command.Lookup("deletes", "0", "q", "_id").ObjectID() != userID {
+			mt.Errorf("expected the user to be deleted last, got %s", user.Command)
+		}
+		if commit := mt.GetStartedEvent(); commit == nil || commit.CommandName != "commitTransaction" {
+			mt.Error("the deletion must be committed as one transaction")
+		}
+	})
+
+	mt.Run("missing user", func(mt *mtest.T) {
+		m := NewAccountModel(mt.Client, mt.Coll, mt.Client.Database("test").Collection("notes"))
+		mt.AddMockResponses(testutil.DeleteResponse(0), testutil.DeleteResponse(0), mtest.CreateSuccessResponse())
+
+		if err := m.Delete(userID); err != ErrUserNotFound {
+			mt.Errorf("got %v", err)
+		}
+	})
+}
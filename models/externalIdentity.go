@@ -0,0 +1,81 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrIdentityLinked = errors.New("identity is already linked to an account")
+
+// ExternalIdentity links an account at an OpenID Connect provider (identified by
+// the provider's stable subject, never by email) to a local user
+type ExternalIdentity struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID    primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Provider  string             `bson:"provider" json:"provider"`
+	Subject   string             `bson:"subject" json:"subject"`
+	Email     string             `bson:"email" json:"email"` // email at the provider when the link was made
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+}
+
+type ExternalIdentityModel struct {
+	collection *mongo.Collection
+}
+
+func NewExternalIdentityModel(collection *mongo.Collection) *ExternalIdentityModel {
+	return &ExternalIdentityModel{collection: collection}
+}
+
+func (m *ExternalIdentityModel) EnsureIndexes() error {
+	_, err := m.collection.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "provider", Value: 1}, {Key: "subject", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create external identity indexes: %v", err)
+	}
+	return nil
+}
+
+// Get returns the identity for a provider subject, or mongo.ErrNoDocuments when it isn't linked yet
+func (m *ExternalIdentityModel) Get(provider, subject string) (*ExternalIdentity, error) {
+	var identity ExternalIdentity
+	err := m.collection.FindOne(context.Background(), bson.M{
+		"provider": provider,
+		"subject":  subject,
+	}).Decode(&identity)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &identity, nil
+}
+
+func (m *ExternalIdentityModel) Link(userID primitive.ObjectID, provider, subject, email string) (*ExternalIdentity, error) {
+	identity := &ExternalIdentity{
+		UserID:    userID,
+		Provider:  provider,
+		Subject:   subject,
+		Email:     email,
+		CreatedAt: time.Now(),
+	}
+
+	result, err := m.collection.InsertOne(context.Background(), identity)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrIdentityLinked
+		}
+		return nil, fmt.Errorf("failed to link identity: %v", err)
+	}
+
+	identity.ID = result.InsertedID.(primitive.ObjectID)
+	return identity, nil
+}
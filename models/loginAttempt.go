@@ -0,0 +1,167 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// LockoutPolicy decides when repeated failures lock a key out. Once Threshold
+// failures have piled up every further failure doubles the lockout, starting
+// at BaseDelay and never exceeding MaxDelay.
+type LockoutPolicy struct {
+	Threshold int
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+func (p LockoutPolicy) delay(failures int) time.Duration {
+	if failures < p.Threshold {
+		return 0
+	}
+
+	d := p.BaseDelay
+	for i := p.Threshold; i < failures && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	return min(d, p.MaxDelay)
+}
+
+// LoginAttempt counts recent failed logins for one account or one client IP
+type LoginAttempt struct {
+	Key           string    `bson:"_id" json:"key"`
+	Failures      int       `bson:"failures" json:"failures"`
+	LastFailureAt time.Time `bson:"last_failure_at" json:"last_failure_at"`
+	LockedUntil   time.Time `bson:"locked_until" json:"locked_until"`
+	ExpiresAt     time.Time `bson:"expires_at" json:"expires_at"`
+}
+
+// failureMemory is how long failures are remembered after the last one
+const failureMemory = 24 * time.Hour
+
+type LoginAttemptModel struct {
+	collection    *mongo.Collection
+	accountPolicy LockoutPolicy
+	ipPolicy      LockoutPolicy
+}
+
+func NewLoginAttemptModel(collection *mongo.Collection, accountPolicy, ipPolicy LockoutPolicy) *LoginAttemptModel {
+	return &LoginAttemptModel{
+		collection:    collection,
+		accountPolicy: accountPolicy,
+		ipPolicy:      ipPolicy,
+	}
+}
+
+func (m *LoginAttemptModel) EnsureIndexes() error {
+	_, err := m.collection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create login attempt indexes: %v", err)
+	}
+	return nil
+}
+
+func accountKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// LockedFor returns how much longer logins for this account or from this IP are locked out
+func (m *LoginAttemptModel) LockedFor(email, ip string) (time.Duration, error) {
+	cursor, err := m.collection.Find(context.Background(), bson.M{
+		"_id":          bson.M{"$in": []string{accountKey(email), ipKey(ip)}},
+		"locked_until": bson.M{"$gt": time.Now()},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to check login attempts: %v", err)
+	}
+	defer cursor.Close(context.Background())
+
+	var longest time.Duration
+	for cursor.Next(context.Background()) {
+		var attempt LoginAttempt
+		if err := cursor.Decode(&attempt); err != nil {
+			return 0, fmt.Errorf("failed to decode login attempt: %v", err)
+		}
+		if remaining := time.Until(attempt.LockedUntil); remaining > longest {
+			longest = remaining
+		}
+	}
+
+	return longest, nil
+}
+
+// RecordFailure counts a failed login against both the account and the IP and
+// returns the lockout that is now in effect, if any
+func (m *LoginAttemptModel) RecordFailure(email, ip string) (time.Duration, error) {
+	accountDelay, err := m.recordFailure(accountKey(email), m.accountPolicy)
+	if err != nil {
+		return 0, err
+	}
+
+	ipDelay, err := m.recordFailure(ipKey(ip), m.ipPolicy)
+	if err != nil {
+		return 0, err
+	}
+
+	if ipDelay > accountDelay {
+		return ipDelay, nil
+	}
+	return accountDelay, nil
+}
+
+func (m *LoginAttemptModel) recordFailure(key string, policy LockoutPolicy) (time.Duration, error) {
+	now := time.Now()
+
+	var attempt LoginAttempt
+	err := m.collection.FindOneAndUpdate(
+		context.Background(),
+		bson.M{"_id": key},
+		bson.M{
+			"$inc": bson.M{"failures": 1},
+			"$set": bson.M{"last_failure_at": now, "expires_at": now.Add(failureMemory)},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&attempt)
+	if err != nil {
+		return 0, fmt.Errorf("failed to record login attempt: %v", err)
+	}
+
+	delay := policy.delay(attempt.Failures)
+	if delay == 0 {
+		return 0, nil
+	}
+
+	lockedUntil := now.Add(delay)
+	_, err = m.collection.UpdateOne(
+		context.Background(),
+		bson.M{"_id": key},
+		bson.M{"$set": bson.M{"locked_until": lockedUntil, "expires_at": lockedUntil.Add(failureMemory)}},
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to lock login: %v", err)
+	}
+
+	return delay, nil
+}
+
+// Reset clears the failures of an account, after a successful login or a password reset.
+// IP counters are left alone so one good login can't launder an attacker's address.
+func (m *LoginAttemptModel) Reset(email string) error {
+	_, err := m.collection.DeleteOne(context.Background(), bson.M{"_id": accountKey(email)})
+	if err != nil {
+		return fmt.Errorf("failed to reset login attempts: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/suraj/GoGoNotes/internal/testutil"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestLockoutPolicyDelay(t *testing.T) {
+	policy := LockoutPolicy{Threshold: 3, BaseDelay: time.Minute, MaxDelay: 10 * time.Minute}
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{1, 0},
+		{2, 0},
+		{3, time.Minute},
+		{4, 2 * time.Minute},
+		{5, 4 * time.Minute},
+		{6, 8 * time.Minute},
+		{7, 10 * time.Minute},
+		{8, 10 * time.Minute},
+		{1000, 10 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		if got := policy.delay(tt.failures); got != tt.want {
+			t.Errorf("delay(%d) = %s, want %s", tt.failures, got, tt.want)
+		}
+	}
+
+	// The cap applies to the first lockout too
+	capped := LockoutPolicy{Threshold: 1, BaseDelay: time.Hour, MaxDelay: time.Minute}
+	if got := capped.delay(1); got != time.Minute {
+		t.Errorf("delay above the cap = %s, want %s", got, time.Minute)
+	}
+}
+
+// attemptResponse is the reply to the upsert in recordFailure, with the counter after the increment
+func attemptResponse(key string, failures int) bson.D {
+	return mtest.CreateSuccessResponse(bson.E{Key: "value", Value: bson.D{
+		{Key: "_id", Value: key},
+		{Key: "failures", Value: failures},
+	}})
+}
+
+func TestRecordFailure(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	accountPolicy := LockoutPolicy{Threshold: 5, BaseDelay: time.Minute, MaxDelay: time.Hour}
+	ipPolicy := LockoutPolicy{Threshold: 20, BaseDelay: time.Minute, MaxDelay: time.Hour}
+
+	mt.Run("below threshold", func(mt *mtest.T) {
+		m := NewLoginAttemptModel(mt.Coll, accountPolicy, ipPolicy)
+		mt.AddMockResponses(attemptResponse("account:bob@example.com", 4), attemptResponse("ip:10.0.0.1", 4))
+
+		delay, err := m.RecordFailure(" Bob@Example.com", "10.0.0.1")
+		if err != nil || delay != 0 {
+			mt.Fatalf("RecordFailure = %s, %v, want no lockout", delay, err)
+		}
+
+		if id := mt.GetStartedEvent().Command.Lookup("query", "_id").StringValue(); id != "account:bob@example.com" {
+			mt.Errorf("account key = %q", id)
+		}
+		if id := mt.GetStartedEvent().Command.Lookup("query", "_id").StringValue(); id != "ip:10.0.0.1" {
+			mt.Errorf("ip key = %q", id)
+		}
+		if event := mt.GetStartedEvent(); event != nil {
+			mt.Errorf("nothing should be locked, got %s", event.CommandName)
+		}
+	})
+
+	mt.Run("backs off exponentially", func(mt *mtest.T) {
+		m := NewLoginAttemptModel(mt.Coll, accountPolicy, ipPolicy)
+		mt.AddMockResponses(
+			attemptResponse("account:bob@example.com", 7), testutil.UpdateResponse(1),
+			attemptResponse("ip:10.0.0.1", 7),
+		)
+
+		before := time.Now()
+		delay, err := m.RecordFailure("bob@example.com", "10.0.0.1")
+		if err != nil || delay != 4*time.Minute {
+			mt.Fatalf("RecordFailure = %s, %v, want 4m", delay, err)
+		}
+
+		mt.GetStartedEvent()
+		lock := mt.GetStartedEvent()
+		if lock.CommandName != "update" {
+			mt.Fatalf("expected the account to be locked, got %s", lock.CommandName)
+		}
+		lockedUntil := lock.Command.Lookup("updates", "0", "u", "$set", "locked_until").Time()
+		if lockedUntil.Before(before.Add(4*time.Minute).Truncate(time.Millisecond)) || lockedUntil.After(time.Now().Add(4*time.Minute)) {
+			mt.Errorf("locked until %s, want 4m from now", lockedUntil)
+		}
+	})
+
+	mt.Run("longest lockout wins and is capped", func(mt *mtest.T) {
+		m := NewLoginAttemptModel(mt.Coll, accountPolicy, ipPolicy)
+		mt.AddMockResponses(
+			attemptResponse("account:bob@example.com", 6), testutil.UpdateResponse(1),
+			attemptResponse("ip:10.0.0.1", 500), testutil.UpdateResponse(1),
+		)
+
+		delay, err := m.RecordFailure("bob@example.com", "10.0.0.1")
+		if err != nil || delay != time.Hour {
+			mt.Errorf("RecordFailure = %s, %v, want the IP's capped 1h", delay, err)
+		}
+	})
+}
+
+func TestResetClearsOnlyTheAccount(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("reset", func(mt *mtest.T) {
+		m := NewLoginAttemptModel(mt.Coll, LockoutPolicy{}, LockoutPolicy{})
+		mt.AddMockResponses(testutil.DeleteResponse(1))
+
+		if err := m.Reset("Bob@Example.com "); err != nil {
+			mt.Fatal(err)
+		}
+
+		event := mt.GetStartedEvent()
+		if event.CommandName != "delete" {
+			mt.Fatalf("expected a delete, got %s", event.CommandName)
+		}
+		if id := event.Command.Lookup("deletes", "0", "q", "_id").StringValue(); id != "account:bob@example.com" {
+			mt.Errorf("deleted %q, want the account counter", id)
+		}
+	})
+}
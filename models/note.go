@@ -2,36 +2,92 @@ package models
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrNoteNotFound    = errors.New("note not found")
+	ErrNoteNotInTrash  = errors.New("note not found in trash")
+	ErrVersionMismatch = errors.New("note has been changed since it was read")
 )
 
 type Note struct {
-	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
-	UserID    primitive.ObjectID `bson:"user_id" json:"user_id"`
-	Title     string             `bson:"title" json:"title"`
-	Body      string             `bson:"body" json:"body"`
-	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
-	UpdatedAt time.Time          `bson:"updated_at" json:"updated_at"`
+	ID         primitive.ObjectID  `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID     primitive.ObjectID  `bson:"user_id" json:"user_id"`
+	Title      string              `bson:"title" json:"title"`
+	Body       string              `bson:"body" json:"body"`
+	Tags       []string            `bson:"tags" json:"tags"`
+	NotebookID *primitive.ObjectID `bson:"notebook_id" json:"notebook_id"`                   // nil outside any notebook
+	DeletedAt  *time.Time          `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"` // set while in the trash
+	CreatedAt  time.Time           `bson:"created_at" json:"created_at"`
+	UpdatedAt  time.Time           `bson:"updated_at" json:"updated_at"`
+	Version    int64               `bson:"version" json:"version"` // bumped on every change, sent as the ETag
 }
 
 type NoteModel struct {
-	collection     *mongo.Collection
-	userCollection *mongo.Collection
+	collection         *mongo.Collection
+	userCollection     *mongo.Collection
+	revisionCollection *mongo.Collection
+	revisionLimit      int
 }
 
-func NewNoteModel(noteCollection, userCollection *mongo.Collection) *NoteModel {
+// NewNoteModel takes the number of revisions kept per note, 0 keeps all of them
+func NewNoteModel(noteCollection, userCollection, revisionCollection *mongo.Collection, revisionLimit int) *NoteModel {
 	return &NoteModel{
-		collection:     noteCollection,
-		userCollection: userCollection,
+		collection:         noteCollection,
+		userCollection:     userCollection,
+		revisionCollection: revisionCollection,
+		revisionLimit:      revisionLimit,
 	}
 }
 
-func (m *NoteModel) Create(userID primitive.ObjectID, title, body string) (*Note, error) {
+// EnsureIndexes creates one compound index per sort order of List, each ending in _id
+// so paging through notes with the same sort value stays on the index, the tag and notebook
+// indexes and the text index.
+func (m *NoteModel) EnsureIndexes() error {
+	_, err := m.collection.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "updated_at", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "title", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "tags", Value: 1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "notebook_id", Value: 1}}},
+		// The trash listing and the purger; notes outside the trash have no deleted_at
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "deleted_at", Value: -1}}, Options: options.Index().SetSparse(true)},
+		{Keys: bson.D{{Key: "deleted_at", Value: 1}}, Options: options.Index().SetSparse(true)},
+		// Text index for Search, prefixed by user_id so a search only scans the user's own notes.
+		// A collection can only have one text index.
+		{
+			Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "title", Value: "text"}, {Key: "body", Value: "text"}},
+			Options: options.Index().SetName("note_text").SetWeights(bson.M{"title": 3, "body": 1}),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create note indexes: %v", err)
+	}
+
+	_, err = m.revisionCollection.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "note_id", Value: 1}, {Key: "number", Value: -1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create note revision indexes: %v", err)
+	}
+	return nil
+}
+
+// Create stores a new note, tags are expected to be normalized already and the
+// notebook (if any) to belong to the user
+func (m *NoteModel) Create(userID primitive.ObjectID, title, body string, tags []string, notebookID *primitive.ObjectID) (*Note, error) {
 	// Validate user exists (similar to Order model pattern)
 	var userExists struct {
 		ID primitive.ObjectID `bson:"_id"`
@@ -39,18 +95,21 @@ func (m *NoteModel) Create(userID primitive.ObjectID, title, body string) (*Note
 	err := m.userCollection.FindOne(context.Background(), bson.M{"_id": userID}).Decode(&userExists)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
-			return nil, fmt.Errorf("user not found")
+			return nil, ErrUserNotFound
 		}
 		return nil, fmt.Errorf("failed to find user: %v", err)
 	}
 
 	now := time.Now()
 	note := &Note{
-		UserID:    userID,
-		Title:     title,
-		Body:      body,
-		CreatedAt: now,
-		UpdatedAt: now,
+		UserID:     userID,
+		Title:      title,
+		Body:       body,
+		Tags:       tags,
+		NotebookID: notebookID,
+		Version:    1,
+		CreatedAt:  now,
+		UpdatedAt:  now,
 	}
 
 	result, err := m.collection.InsertOne(context.Background(), note)
@@ -59,43 +118,34 @@ func (m *NoteModel) Create(userID primitive.ObjectID, title, body string) (*Note
 	}
 
 	note.ID = result.InsertedID.(primitive.ObjectID)
-	return note, nil
-}
-
-func (m *NoteModel) GetAll(userID primitive.ObjectID) ([]Note, error) {
-	var notes []Note
 
-	cursor, err := m.collection.Find(context.Background(), bson.M{"user_id": userID})
-	if err != nil {
-		return []Note{}, fmt.Errorf("failed to fetch notes: %v", err)
+	if err := m.recordRevision(note); err != nil {
+		return nil, err
 	}
-	defer cursor.Close(context.Background())
 
-	for cursor.Next(context.Background()) {
-		var note Note
-		if err := cursor.Decode(&note); err != nil {
-			return []Note{}, fmt.Errorf("failed to decode note: %v", err)
-		}
-		notes = append(notes, note)
-	}
+	return note, nil
+}
 
-	if notes == nil {
-		return []Note{}, nil
+// CountForUser returns how many notes the user has outside the trash
+func (m *NoteModel) CountForUser(userID primitive.ObjectID) (int64, error) {
+	count, err := m.collection.CountDocuments(context.Background(), bson.M{"user_id": userID, "deleted_at": nil})
+	if err != nil {
+		return 0, fmt.Errorf("failed to count notes: %v", err)
 	}
-
-	return notes, nil
+	return count, nil
 }
 
 func (m *NoteModel) GetByID(id primitive.ObjectID, userID primitive.ObjectID) (*Note, error) {
 	var note Note
 	err := m.collection.FindOne(context.Background(), bson.M{
-		"_id":     id,
-		"user_id": userID,
+		"_id":        id,
+		"user_id":    userID,
+		"deleted_at": nil,
 	}).Decode(&note)
 
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
-			return nil, fmt.Errorf("note not found")
+			return nil, ErrNoteNotFound
 		}
 		return nil, fmt.Errorf("failed to fetch note: %v", err)
 	}
@@ -103,69 +153,137 @@ func (m *NoteModel) GetByID(id primitive.ObjectID, userID primitive.ObjectID) (*
 	return &note, nil
 }
 
-func (m *NoteModel) Update(id primitive.ObjectID, userID primitive.ObjectID, title, body string) (*Note, error) {
-	// First check if note exists and belongs to user
-	var existingNote Note
-	err := m.collection.FindOne(context.Background(), bson.M{
-		"_id":     id,
-		"user_id": userID,
-	}).Decode(&existingNote)
+// Update replaces the note's title and body, and its tags unless tags is nil, and
+// records the result as a new revision. With versions, the note is only updated
+// while its version is one of them, otherwise ErrVersionMismatch is returned; the
+// check and the write are a single atomic update, so concurrent edits can't both win.
+func (m *NoteModel) Update(id primitive.ObjectID, userID primitive.ObjectID, title, body string, tags []string, versions []int64) (*Note, error) {
+	fields := bson.M{
+		"title": title,
+		"body":  body,
+	}
+	if tags != nil {
+		fields["tags"] = tags
+	}
+	return m.save(id, userID, fields, versions)
+}
+
+// NotePatch holds the fields of a partial update, nil fields are left as they are
+type NotePatch struct {
+	Title *string
+	Body  *string
+	Tags  []string
+}
+
+// Patch updates only the fields set in patch, versions works like in Update
+func (m *NoteModel) Patch(id primitive.ObjectID, userID primitive.ObjectID, patch NotePatch, versions []int64) (*Note, error) {
+	fields := bson.M{}
+	if patch.Title != nil {
+		fields["title"] = *patch.Title
+	}
+	if patch.Body != nil {
+		fields["body"] = *patch.Body
+	}
+	if patch.Tags != nil {
+		fields["tags"] = patch.Tags
+	}
+	return m.save(id, userID, fields, versions)
+}
 
+// save sets fields on the note, bumps its version and records the new revision
+func (m *NoteModel) save(id, userID primitive.ObjectID, fields bson.M, versions []int64) (*Note, error) {
+	existingNote, err := m.GetByID(id, userID)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			return nil, fmt.Errorf("note not found")
-		}
-		return nil, fmt.Errorf("failed to find note: %v", err)
+		return nil, err
 	}
 
-	update := bson.M{
-		"$set": bson.M{
-			"title":      title,
-			"body":       body,
-			"updated_at": time.Now(),
-		},
+	if err := m.ensureBaseRevision(existingNote); err != nil {
+		return nil, err
 	}
 
-	result, err := m.collection.UpdateOne(
+	fields["updated_at"] = time.Now()
+
+	var note Note
+	err = m.collection.FindOneAndUpdate(
 		context.Background(),
-		bson.M{"_id": id, "user_id": userID},
-		update,
-	)
+		m.versionFilter(id, userID, versions),
+		bson.M{"$set": fields, "$inc": bson.M{"version": 1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&note)
 	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, m.preconditionError(id, userID)
+		}
 		return nil, fmt.Errorf("failed to update note: %v", err)
 	}
 
-	if result.ModifiedCount == 0 {
-		return nil, fmt.Errorf("no note was updated")
+	if err := m.recordRevision(&note); err != nil {
+		return nil, err
 	}
 
-	return m.GetByID(id, userID)
+	return &note, nil
 }
 
-func (m *NoteModel) Delete(id primitive.ObjectID, userID primitive.ObjectID) error {
-	filter := bson.M{
-		"_id":     id,
-		"user_id": userID,
+// Move puts the note into the notebook, or takes it out of any notebook when notebookID is nil
+func (m *NoteModel) Move(id, userID primitive.ObjectID, notebookID *primitive.ObjectID) (*Note, error) {
+	result, err := m.collection.UpdateOne(
+		context.Background(),
+		bson.M{"_id": id, "user_id": userID, "deleted_at": nil},
+		bson.M{"$set": bson.M{"notebook_id": notebookID, "updated_at": time.Now()}, "$inc": bson.M{"version": 1}},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to move note: %v", err)
 	}
 
-	// First check if note exists and belongs to user
-	var note Note
-	err := m.collection.FindOne(context.Background(), filter).Decode(&note)
-	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			return fmt.Errorf("note not found")
-		}
-		return fmt.Errorf("failed to find note: %v", err)
+	if result.MatchedCount == 0 {
+		return nil, ErrNoteNotFound
 	}
 
-	result, err := m.collection.DeleteOne(context.Background(), filter)
+	return m.GetByID(id, userID)
+}
+
+// Delete moves the note to the trash, Restore takes it back out and the purger
+// removes it for good once the retention period has passed. versions works like in Update.
+func (m *NoteModel) Delete(id primitive.ObjectID, userID primitive.ObjectID, versions []int64) error {
+	now := time.Now()
+	result, err := m.collection.UpdateOne(
+		context.Background(),
+		m.versionFilter(id, userID, versions),
+		bson.M{"$set": bson.M{"deleted_at": now, "updated_at": now}, "$inc": bson.M{"version": 1}},
+	)
 	if err != nil {
 		return fmt.Errorf("failed to delete note: %v", err)
 	}
 
-	if result.DeletedCount == 0 {
-		return fmt.Errorf("no note was deleted")
+	if result.MatchedCount == 0 {
+		return m.preconditionError(id, userID)
 	}
 
 	return nil
 }
+
+// versionFilter matches the note outside the trash, and with versions only at one
+// of those versions. Notes from before versioning have no version field, which
+// counts as version 0.
+func (m *NoteModel) versionFilter(id, userID primitive.ObjectID, versions []int64) bson.M {
+	filter := bson.M{"_id": id, "user_id": userID, "deleted_at": nil}
+	if versions != nil {
+		in := bson.A{}
+		for _, v := range versions {
+			in = append(in, v)
+			if v == 0 {
+				in = append(in, nil)
+			}
+		}
+		filter["version"] = bson.M{"$in": in}
+	}
+	return filter
+}
+
+// preconditionError tells apart why a versionFilter update matched nothing
+func (m *NoteModel) preconditionError(id, userID primitive.ObjectID) error {
+	if _, err := m.GetByID(id, userID); err != nil {
+		return err
+	}
+	return ErrVersionMismatch
+}
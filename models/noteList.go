@@ -0,0 +1,190 @@
+package models
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Fields notes can be sorted by
+const (
+	NoteSortCreatedAt = "created_at"
+	NoteSortUpdatedAt = "updated_at"
+	NoteSortTitle     = "title"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// NoteListOptions controls which page of notes List returns. Zero values mean
+// "no filter"; SortField defaults to updated_at.
+type NoteListOptions struct {
+	Limit         int
+	SortField     string
+	Ascending     bool
+	Cursor        string // next_cursor of the previous page
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	UpdatedAfter  *time.Time
+	UpdatedBefore *time.Time
+	Tags          []string // notes with any of these tags, or all of them with MatchAllTags
+	MatchAllTags  bool
+	NotebookIDs   []primitive.ObjectID // notes in any of these notebooks
+	Unfiled       bool                 // notes that aren't in any notebook
+}
+
+// noteCursor is the position after the last note of a page: its sort value and ID,
+// so notes with equal sort values are still paged through in a stable order. The
+// sort is recorded too, since a cursor is meaningless under a different one.
+type noteCursor struct {
+	SortField string             `json:"s"`
+	Ascending bool               `json:"a,omitempty"`
+	Time      int64              `json:"t,omitempty"` // unix milliseconds, the precision Mongo stores
+	Title     string             `json:"v,omitempty"`
+	ID        primitive.ObjectID `json:"id"`
+}
+
+func IsValidNoteSortField(field string) bool {
+	return field == NoteSortCreatedAt || field == NoteSortUpdatedAt || field == NoteSortTitle
+}
+
+// List returns a page of the user's notes and the cursor of the next page,
+// which is empty when this is the last page
+func (m *NoteModel) List(userID primitive.ObjectID, opts NoteListOptions) ([]Note, string, error) {
+	if opts.SortField == "" {
+		opts.SortField = NoteSortUpdatedAt
+	}
+	if !IsValidNoteSortField(opts.SortField) {
+		return []Note{}, "", fmt.Errorf("invalid sort field %q", opts.SortField)
+	}
+
+	conditions := bson.A{bson.M{"user_id": userID, "deleted_at": nil}}
+	if dates := dateRange(opts.CreatedAfter, opts.CreatedBefore); dates != nil {
+		conditions = append(conditions, bson.M{"created_at": dates})
+	}
+	if dates := dateRange(opts.UpdatedAfter, opts.UpdatedBefore); dates != nil {
+		conditions = append(conditions, bson.M{"updated_at": dates})
+	}
+	if len(opts.Tags) > 0 {
+		op := "$in"
+		if opts.MatchAllTags {
+			op = "$all"
+		}
+		conditions = append(conditions, bson.M{"tags": bson.M{op: opts.Tags}})
+	}
+	if len(opts.NotebookIDs) > 0 {
+		conditions = append(conditions, bson.M{"notebook_id": bson.M{"$in": opts.NotebookIDs}})
+	} else if opts.Unfiled {
+		// null also matches notes created before notebooks existed
+		conditions = append(conditions, bson.M{"notebook_id": nil})
+	}
+
+	if opts.Cursor != "" {
+		after, err := decodeNoteCursor(opts.Cursor)
+		if err != nil || after.SortField != opts.SortField || after.Ascending != opts.Ascending {
+			return []Note{}, "", ErrInvalidCursor
+		}
+		conditions = append(conditions, after.filter())
+	}
+
+	direction := -1
+	if opts.Ascending {
+		direction = 1
+	}
+
+	var notes []Note
+
+	// One extra note tells us whether there is another page
+	cursor, err := m.collection.Find(
+		context.Background(),
+		bson.M{"$and": conditions},
+		options.Find().
+			SetSort(bson.D{{Key: opts.SortField, Value: direction}, {Key: "_id", Value: direction}}).
+			SetLimit(int64(opts.Limit+1)),
+	)
+	if err != nil {
+		return []Note{}, "", fmt.Errorf("failed to fetch notes: %v", err)
+	}
+	defer cursor.Close(context.Background())
+
+	if err := cursor.All(context.Background(), &notes); err != nil {
+		return []Note{}, "", fmt.Errorf("failed to decode notes: %v", err)
+	}
+
+	if len(notes) <= opts.Limit {
+		if notes == nil {
+			return []Note{}, "", nil
+		}
+		return notes, "", nil
+	}
+
+	notes = notes[:opts.Limit]
+	next := encodeNoteCursor(opts.SortField, opts.Ascending, notes[len(notes)-1])
+	return notes, next, nil
+}
+
+func dateRange(after, before *time.Time) bson.M {
+	if after == nil && before == nil {
+		return nil
+	}
+	dates := bson.M{}
+	if after != nil {
+		dates["$gte"] = *after
+	}
+	if before != nil {
+		dates["$lt"] = *before
+	}
+	return dates
+}
+
+// filter matches the notes that come after the cursor in its sort order
+func (c *noteCursor) filter() bson.M {
+	var value interface{} = c.Title
+	if c.SortField != NoteSortTitle {
+		value = time.UnixMilli(c.Time)
+	}
+
+	op := "$lt"
+	if c.Ascending {
+		op = "$gt"
+	}
+
+	return bson.M{"$or": bson.A{
+		bson.M{c.SortField: bson.M{op: value}},
+		bson.M{c.SortField: value, "_id": bson.M{op: c.ID}},
+	}}
+}
+
+func encodeNoteCursor(sortField string, ascending bool, last Note) string {
+	c := noteCursor{SortField: sortField, Ascending: ascending, ID: last.ID}
+	switch sortField {
+	case NoteSortCreatedAt:
+		c.Time = last.CreatedAt.UnixMilli()
+	case NoteSortUpdatedAt:
+		c.Time = last.UpdatedAt.UnixMilli()
+	case NoteSortTitle:
+		c.Title = last.Title
+	}
+
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeNoteCursor(s string) (*noteCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var c noteCursor
+	if err := json.Unmarshal(b, &c); err != nil || c.ID.IsZero() {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
@@ -0,0 +1,193 @@
+package models
+
+import (
+	"encoding/base64"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/suraj/GoGoNotes/internal/testutil"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestNoteCursorRoundTrip(t *testing.T) {
+	created := time.Date(2024, 3, 1, 9, 30, 0, 123456789, time.UTC)
+	updated := created.Add(time.Hour)
+	note := Note{ID: primitive.NewObjectID(), Title: "Groceries", CreatedAt: created, UpdatedAt: updated}
+
+	tests := []struct {
+		sortField string
+		ascending bool
+		want      noteCursor
+	}{
+		{NoteSortUpdatedAt, false, noteCursor{SortField: NoteSortUpdatedAt, Time: updated.UnixMilli(), ID: note.ID}},
+		{NoteSortUpdatedAt, true, noteCursor{SortField: NoteSortUpdatedAt, Ascending: true, Time: updated.UnixMilli(), ID: note.ID}},
+		{NoteSortCreatedAt, false, noteCursor{SortField: NoteSortCreatedAt, Time: created.UnixMilli(), ID: note.ID}},
+		{NoteSortCreatedAt, true, noteCursor{SortField: NoteSortCreatedAt, Ascending: true, Time: created.UnixMilli(), ID: note.ID}},
+		{NoteSortTitle, false, noteCursor{SortField: NoteSortTitle, Title: "Groceries", ID: note.ID}},
+		{NoteSortTitle, true, noteCursor{SortField: NoteSortTitle, Ascending: true, Title: "Groceries", ID: note.ID}},
+	}
+
+	for _, tt := range tests {
+		got, err := decodeNoteCursor(encodeNoteCursor(tt.sortField, tt.ascending, note))
+		if err != nil {
+			t.Errorf("%s ascending=%v: %v", tt.sortField, tt.ascending, err)
+			continue
+		}
+		if *got != tt.want {
+			t.Errorf("%s ascending=%v: got %+v, want %+v", tt.sortField, tt.ascending, *got, tt.want)
+		}
+	}
+}
+
+func TestDecodeNoteCursorRejectsInvalidCursors(t *testing.T) {
+	encode := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+
+	tests := []struct {
+		name   string
+		cursor string
+	}{
+		{"not base64", "%%%"},
+		{"not JSON", encode("hello")},
+		{"truncated", encode(`{"s":"title","id":"65f1a2b3`)},
+		{"no ID", encode(`{"s":"title","v":"a"}`)},
+		{"zero ID", encode(`{"s":"title","id":"000000000000000000000000"}`)},
+		{"bad ID", encode(`{"s":"title","id":"not-an-object-id"}`)},
+		{"time of the wrong type", encode(`{"s":"updated_at","t":"yesterday","id":"65f1a2b3c4d5e6f708091a2b"}`)},
+	}
+
+	for _, tt := range tests {
+		if c, err := decodeNoteCursor(tt.cursor); err != ErrInvalidCursor {
+			t.Errorf("%s: got %+v, %v", tt.name, c, err)
+		}
+	}
+}
+
+func TestNoteCursorFilter(t *testing.T) {
+	id := primitive.NewObjectID()
+	at := time.UnixMilli(1709285400123)
+
+	tests := []struct {
+		name   string
+		cursor noteCursor
+		want   bson.M
+	}{
+		{
+			"newest first",
+			noteCursor{SortField: NoteSortUpdatedAt, Time: at.UnixMilli(), ID: id},
+			bson.M{"$or": bson.A{
+				bson.M{"updated_at": bson.M{"$lt": at}},
+				bson.M{"updated_at": at, "_id": bson.M{"$lt": id}},
+			}},
+		},
+		{
+			"oldest first",
+			noteCursor{SortField: NoteSortCreatedAt, Ascending: true, Time: at.UnixMilli(), ID: id},
+			bson.M{"$or": bson.A{
+				bson.M{"created_at": bson.M{"$gt": at}},
+				bson.M{"created_at": at, "_id": bson.M{"$gt": id}},
+			}},
+		},
+		{
+			"title A to Z",
+			noteCursor{SortField: NoteSortTitle, Ascending: true, Title: "Groceries", ID: id},
+			bson.M{"$or": bson.A{
+				bson.M{"title": bson.M{"$gt": "Groceries"}},
+				bson.M{"title": "Groceries", "_id": bson.M{"$gt": id}},
+			}},
+		},
+		{
+			"empty title Z to A",
+			noteCursor{SortField: NoteSortTitle, ID: id},
+			bson.M{"$or": bson.A{
+				bson.M{"title": bson.M{"$lt": ""}},
+				bson.M{"title": "", "_id": bson.M{"$lt": id}},
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		if got := tt.cursor.filter(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestListPagesThroughTies(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	userID := primitive.NewObjectID()
+	same := time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+	notes := []Note{
+		{ID: primitive.NewObjectID(), UserID: userID, Title: "c", UpdatedAt: same},
+		{ID: primitive.NewObjectID(), UserID: userID, Title: "b", UpdatedAt: same},
+		{ID: primitive.NewObjectID(), UserID: userID, Title: "a", UpdatedAt: same},
+	}
+
+	mt.Run("next cursor", func(mt *mtest.T) {
+		m := NewNoteModel(mt.Coll, mt.Coll, mt.Coll, 0)
+		mt.AddMockResponses(testutil.FindResponse(notes[0], notes[1], notes[2]))
+
+		page, next, err := m.List(userID, NoteListOptions{Limit: 2})
+		if err != nil {
+			mt.Fatal(err)
+		}
+		if len(page) != 2 || next == "" {
+			mt.Fatalf("got %d notes and cursor %q", len(page), next)
+		}
+
+		sort := mt.GetStartedEvent().Command.Lookup("sort").Document()
+		if sort.Index(0).Key() != "updated_at" || sort.Index(1).Key() != "_id" {
+			mt.Errorf("ties must be broken by _id, got %s", sort)
+		}
+
+		after, err := decodeNoteCursor(next)
+		if err != nil || after.ID != notes[1].ID || after.Time != same.UnixMilli() {
+			mt.Errorf("cursor %+v, %v does not point at the last note of the page", after, err)
+		}
+	})
+
+	mt.Run("last page", func(mt *mtest.T) {
+		m := NewNoteModel(mt.Coll, mt.Coll, mt.Coll, 0)
+		mt.AddMockResponses(testutil.FindResponse(notes[2]))
+
+		cursor := encodeNoteCursor(NoteSortUpdatedAt, false, notes[1])
+		page, next, err := m.List(userID, NoteListOptions{Limit: 2, Cursor: cursor})
+		if err != nil || len(page) != 1 || next != "" {
+			mt.Fatalf("got %d notes, cursor %q, %v", len(page), next, err)
+		}
+
+		filter := mt.GetStartedEvent().Command.Lookup("filter", "$and", "1", "$or", "1", "_id", "$lt")
+		if filter.ObjectID() != notes[1].ID {
+			mt.Errorf("notes with the same updated_at must continue after the cursor's _id, got %s", filter)
+		}
+	})
+}
+
+func TestListRejectsCursorsOfAnotherSort(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	note := Note{ID: primitive.NewObjectID(), Title: "Groceries", UpdatedAt: time.Now()}
+	tests := []struct {
+		name string
+		opts NoteListOptions
+	}{
+		{"other field", NoteListOptions{Limit: 10, SortField: NoteSortTitle, Cursor: encodeNoteCursor(NoteSortUpdatedAt, false, note)}},
+		{"other direction", NoteListOptions{Limit: 10, Ascending: true, Cursor: encodeNoteCursor(NoteSortUpdatedAt, false, note)}},
+		{"garbage", NoteListOptions{Limit: 10, Cursor: "garbage"}},
+	}
+
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			m := NewNoteModel(mt.Coll, mt.Coll, mt.Coll, 0)
+			if _, _, err := m.List(primitive.NewObjectID(), tt.opts); err != ErrInvalidCursor {
+				mt.Errorf("got %v", err)
+			}
+			if event := mt.GetStartedEvent(); event != nil {
+				mt.Errorf("an invalid cursor must not be queried, got %s", event.CommandName)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrRevisionNotFound = errors.New("revision not found")
+
+// NoteRevision is an immutable snapshot of a note's content. A revision is recorded
+// when a note is created and after every update, so the newest revision always
+// matches the note. A revision's number is the note version it captured, so numbers
+// grow per note but skip versions that only moved the note; notes written before
+// versioning have a base revision 0.
+type NoteRevision struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	NoteID    primitive.ObjectID `bson:"note_id" json:"note_id"`
+	UserID    primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Number    int                `bson:"number" json:"number"`
+	Title     string             `bson:"title" json:"title"`
+	Body      string             `bson:"body" json:"body"`
+	Tags      []string           `bson:"tags" json:"tags"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// GetRevisions returns the revisions of a note, newest first
+func (m *NoteModel) GetRevisions(noteID, userID primitive.ObjectID) ([]NoteRevision, error) {
+	var revisions []NoteRevision
+
+	cursor, err := m.revisionCollection.Find(
+		context.Background(),
+		bson.M{"note_id": noteID, "user_id": userID},
+		options.Find().SetSort(bson.M{"number": -1}),
+	)
+	if err != nil {
+		return []NoteRevision{}, fmt.Errorf("failed to fetch revisions: %v", err)
+	}
+	defer cursor.Close(context.Background())
+
+	if err := cursor.All(context.Background(), &revisions); err != nil {
+		return []NoteRevision{}, fmt.Errorf("failed to decode revisions: %v", err)
+	}
+
+	if revisions == nil {
+		return []NoteRevision{}, nil
+	}
+
+	return revisions, nil
+}
+
+func (m *NoteModel) GetRevision(noteID, userID primitive.ObjectID, number int) (*NoteRevision, error) {
+	var revision NoteRevision
+	err := m.revisionCollection.FindOne(context.Background(), bson.M{
+		"note_id": noteID,
+		"user_id": userID,
+		"number":  number,
+	}).Decode(&revision)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrRevisionNotFound
+		}
+		return nil, fmt.Errorf("failed to fetch revision: %v", err)
+	}
+	return &revision, nil
+}
+
+// recordRevision snapshots the note under its version, then drops the oldest
+// revisions beyond the cap. The version comes from the atomic update that wrote the
+// note, so concurrent writers never compete for a number; a revision that already
+// exists for the version holds the same content and is left as it is.
+func (m *NoteModel) recordRevision(note *Note) error {
+	_, err := m.revisionCollection.InsertOne(context.Background(), &NoteRevision{
+		NoteID:    note.ID,
+		UserID:    note.UserID,
+		Number:    int(note.Version),
+		Title:     note.Title,
+		Body:      note.Body,
+		Tags:      note.Tags,
+		CreatedAt: note.UpdatedAt,
+	})
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("failed to record revision: %v", err)
+	}
+
+	if m.revisionLimit <= 0 {
+		return nil
+	}
+
+	var oldest NoteRevision
+	err = m.revisionCollection.FindOne(
+		context.Background(),
+		bson.M{"note_id": note.ID},
+		options.FindOne().SetSort(bson.M{"number": -1}).SetSkip(int64(m.revisionLimit)).SetProjection(bson.M{"number": 1}),
+	).Decode(&oldest)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to fetch revisions: %v", err)
+	}
+
+	_, err = m.revisionCollection.DeleteMany(context.Background(), bson.M{
+		"note_id": note.ID,
+		"number":  bson.M{"$lte": oldest.Number},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to prune revisions: %v", err)
+	}
+
+	return nil
+}
+
+// ensureBaseRevision records the note as it is when it has no revisions yet, so
+// content written before revisions existed isn't lost on the first update
+func (m *NoteModel) ensureBaseRevision(note *Note) error {
+	count, err := m.revisionCollection.CountDocuments(
+		context.Background(),
+		bson.M{"note_id": note.ID},
+		options.Count().SetLimit(1),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to count revisions: %v", err)
+	}
+	if count > 0 {
+		return nil
+	}
+	return m.recordRevision(note)
+}
+
+// deleteRevisions removes the revisions of notes that are deleted for good
+func (m *NoteModel) deleteRevisions(noteIDs []primitive.ObjectID) error {
+	_, err := m.revisionCollection.DeleteMany(context.Background(), bson.M{"note_id": bson.M{"$in": noteIDs}})
+	if err != nil {
+		return fmt.Errorf("failed to delete revisions: %v", err)
+	}
+	return nil
+}
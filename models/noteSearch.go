@@ -0,0 +1,48 @@
+package models
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// NoteSearchResult is a note matched by Search along with its relevance
+type NoteSearchResult struct {
+	Note  `bson:",inline"`
+	Score float64 `bson:"score" json:"score"`
+}
+
+// Search runs a Mongo text search over the user's note titles and bodies. The query
+// supports the $text syntax: "exact phrases" and -negated words or phrases.
+// Results are ordered by relevance, best first.
+func (m *NoteModel) Search(userID primitive.ObjectID, query string, page, limit int) ([]NoteSearchResult, error) {
+	var results []NoteSearchResult
+
+	score := bson.M{"$meta": "textScore"}
+	cursor, err := m.collection.Find(
+		context.Background(),
+		bson.M{"user_id": userID, "deleted_at": nil, "$text": bson.M{"$search": query}},
+		options.Find().
+			SetProjection(bson.M{"score": score}).
+			SetSort(bson.D{{Key: "score", Value: score}, {Key: "_id", Value: -1}}).
+			SetSkip(int64((page-1)*limit)).
+			SetLimit(int64(limit)),
+	)
+	if err != nil {
+		return []NoteSearchResult{}, fmt.Errorf("failed to search notes: %v", err)
+	}
+	defer cursor.Close(context.Background())
+
+	if err := cursor.All(context.Background(), &results); err != nil {
+		return []NoteSearchResult{}, fmt.Errorf("failed to decode notes: %v", err)
+	}
+
+	if results == nil {
+		return []NoteSearchResult{}, nil
+	}
+
+	return results, nil
+}
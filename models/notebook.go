@@ -0,0 +1,355 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Limits on notebooks, deep trees are hard to navigate and make paths long
+const (
+	maxNotebookNameLength = 100
+	maxNotebookDepth      = 10
+)
+
+var (
+	ErrNotebookNotFound    = errors.New("notebook not found")
+	ErrNotebookNameTaken   = errors.New("a notebook with this name already exists here")
+	ErrNotebookCycle       = errors.New("a notebook can't be moved into itself or one of its sub-notebooks")
+	ErrNotebookTooDeep     = fmt.Errorf("notebooks can be nested at most %d levels deep", maxNotebookDepth)
+	ErrInvalidNotebookName = errors.New("notebook name must be 1 to 100 characters")
+)
+
+// Notebook is a folder of notes. Notebooks nest through ParentID; Path holds the IDs
+// of all ancestors from the top down (the materialized path), so a whole subtree can
+// be found with a single query on path.
+type Notebook struct {
+	ID        primitive.ObjectID   `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID    primitive.ObjectID   `bson:"user_id" json:"user_id"`
+	ParentID  *primitive.ObjectID  `bson:"parent_id" json:"parent_id"`
+	Path      []primitive.ObjectID `bson:"path" json:"path"`
+	Name      string               `bson:"name" json:"name"`
+	CreatedAt time.Time            `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time            `bson:"updated_at" json:"updated_at"`
+}
+
+type NotebookModel struct {
+	transactor     *transactor
+	collection     *mongo.Collection
+	noteCollection *mongo.Collection
+}
+
+func NewNotebookModel(client *mongo.Client, notebookCollection, noteCollection *mongo.Collection) *NotebookModel {
+	return &NotebookModel{
+		transactor:     newTransactor(client),
+		collection:     notebookCollection,
+		noteCollection: noteCollection,
+	}
+}
+
+// EnsureIndexes keeps names unique among siblings (parent_id is stored as null for
+// top-level notebooks so they are covered too) and indexes path for subtree lookups
+func (m *NotebookModel) EnsureIndexes() error {
+	_, err := m.collection.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "parent_id", Value: 1}, {Key: "name", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "path", Value: 1}}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create notebook indexes: %v", err)
+	}
+	return nil
+}
+
+// NormalizeNotebookName trims the name and checks its length
+func NormalizeNotebookName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > maxNotebookNameLength {
+		return "", ErrInvalidNotebookName
+	}
+	return name, nil
+}
+
+// Create adds a notebook under parentID, or at the top level when parentID is nil
+func (m *NotebookModel) Create(userID primitive.ObjectID, name string, parentID *primitive.ObjectID) (*Notebook, error) {
+	path, err := m.childPath(userID, parentID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	notebook := &Notebook{
+		UserID:    userID,
+		ParentID:  parentID,
+		Path:      path,
+		Name:      name,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	result, err := m.collection.InsertOne(context.Background(), notebook)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrNotebookNameTaken
+		}
+		return nil, fmt.Errorf("failed to create notebook: %v", err)
+	}
+
+	notebook.ID = result.InsertedID.(primitive.ObjectID)
+	return notebook, nil
+}
+
+// GetAll returns all of the user's notebooks as a flat list ordered by name;
+// parent_id links them into a tree
+func (m *NotebookModel) GetAll(userID primitive.ObjectID) ([]Notebook, error) {
+	var notebooks []Notebook
+
+	cursor, err := m.collection.Find(
+		context.Background(),
+		bson.M{"user_id": userID},
+		options.Find().SetSort(bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}}),
+	)
+	if err != nil {
+		return []Notebook{}, fmt.Errorf("failed to fetch notebooks: %v", err)
+	}
+	defer cursor.Close(context.Background())
+
+	if err := cursor.All(context.Background(), &notebooks); err != nil {
+		return []Notebook{}, fmt.Errorf("failed to decode notebooks: %v", err)
+	}
+
+	if notebooks == nil {
+		return []Notebook{}, nil
+	}
+
+	return notebooks, nil
+}
+
+func (m *NotebookModel) GetByID(id, userID primitive.ObjectID) (*Notebook, error) {
+	var notebook Notebook
+	err := m.collection.FindOne(context.Background(), bson.M{"_id": id, "user_id": userID}).Decode(&notebook)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrNotebookNotFound
+		}
+		return nil, fmt.Errorf("failed to fetch notebook: %v", err)
+	}
+	return &notebook, nil
+}
+
+// SubtreeIDs returns the notebook's ID followed by the IDs of all notebooks nested in it
+func (m *NotebookModel) SubtreeIDs(id, userID primitive.ObjectID) ([]primitive.ObjectID, error) {
+	cursor, err := m.collection.Find(
+		context.Background(),
+		bson.M{"user_id": userID, "path": id},
+		options.Find().SetProjection(bson.M{"_id": 1}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch notebooks: %v", err)
+	}
+	defer cursor.Close(context.Background())
+
+	var descendants []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := cursor.All(context.Background(), &descendants); err != nil {
+		return nil, fmt.Errorf("failed to decode notebooks: %v", err)
+	}
+
+	ids := []primitive.ObjectID{id}
+	for _, d := range descendants {
+		ids = append(ids, d.ID)
+	}
+	return ids, nil
+}
+
+// CountNotes returns how many notes outside the trash are directly in the notebook
+func (m *NotebookModel) CountNotes(id, userID primitive.ObjectID) (int64, error) {
+	count, err := m.noteCollection.CountDocuments(context.Background(), bson.M{"user_id": userID, "notebook_id": id, "deleted_at": nil})
+	if err != nil {
+		return 0, fmt.Errorf("failed to count notes: %v", err)
+	}
+	return count, nil
+}
+
+// Update renames the notebook and moves it under parentID (nil is the top level).
+// The paths of all sub-notebooks move along with it, in one transaction where the
+// deployment supports them.
+func (m *NotebookModel) Update(id, userID primitive.ObjectID, name string, parentID *primitive.ObjectID) (*Notebook, error) {
+	notebook, err := m.GetByID(id, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	path, err := m.childPath(userID, parentID)
+	if err != nil {
+		return nil, err
+	}
+	for _, ancestor := range path {
+		if ancestor == id {
+			return nil, ErrNotebookCycle
+		}
+	}
+
+	// The deepest sub-notebook must still fit once the subtree is moved
+	if depth, err := m.subtreeDepth(notebook); err != nil {
+		return nil, err
+	} else if len(path)+depth > maxNotebookDepth {
+		return nil, ErrNotebookTooDeep
+	}
+
+	err = m.transactor.run(func(ctx context.Context) error {
+		_, err := m.collection.UpdateOne(ctx, bson.M{"_id": id, "user_id": userID}, bson.M{"$set": bson.M{
+			"name":       name,
+			"parent_id":  parentID,
+			"path":       path,
+			"updated_at": time.Now(),
+		}})
+		if err != nil {
+			if mongo.IsDuplicateKeyError(err) {
+				return ErrNotebookNameTaken
+			}
+			return fmt.Errorf("failed to update notebook: %v", err)
+		}
+
+		// Sub-notebooks keep the part of their path from this notebook down and get the new prefix
+		_, err = m.collection.UpdateMany(ctx, bson.M{"user_id": userID, "path": id}, bson.A{bson.M{"$set": bson.M{
+			"path": bson.M{"$concatArrays": bson.A{
+				path,
+				bson.M{"$slice": bson.A{"$path", bson.M{"$indexOfArray": bson.A{"$path", id}}, bson.M{"$size": "$path"}}},
+			}},
+		}}})
+		if err != nil {
+			return fmt.Errorf("failed to move sub-notebooks: %v", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return m.GetByID(id, userID)
+}
+
+// Delete removes the notebook. With cascade, every sub-notebook is deleted too and
+// their notes go to the trash, taken out of the notebooks. Without it, the notebook's notes and sub-notebooks move up
+// to its parent (or the top level), so nothing but the notebook itself is lost. Like
+// Update, it runs in a transaction where the deployment supports them.
+func (m *NotebookModel) Delete(id, userID primitive.ObjectID, cascade bool) error {
+	notebook, err := m.GetByID(id, userID)
+	if err != nil {
+		return err
+	}
+
+	subtree, err := m.SubtreeIDs(id, userID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	return m.transactor.run(func(ctx context.Context) error {
+		if cascade {
+			// Notes already in the trash keep their deletion time
+			if _, err := m.noteCollection.UpdateMany(ctx,
+				bson.M{"user_id": userID, "notebook_id": bson.M{"$in": subtree}},
+				bson.A{bson.M{"$set": bson.M{
+					"notebook_id": nil,
+					"deleted_at":  bson.M{"$ifNull": bson.A{"$deleted_at", now}},
+					"updated_at":  now,
+					"version":     bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$version", 0}}, 1}},
+				}}},
+			); err != nil {
+				return fmt.Errorf("failed to delete notes: %v", err)
+			}
+			if _, err := m.collection.DeleteMany(ctx, bson.M{"user_id": userID, "_id": bson.M{"$in": subtree}}); err != nil {
+				return fmt.Errorf("failed to delete notebooks: %v", err)
+			}
+			return nil
+		}
+
+		if _, err := m.noteCollection.UpdateMany(ctx,
+			bson.M{"user_id": userID, "notebook_id": id},
+			bson.M{"$set": bson.M{"notebook_id": notebook.ParentID, "updated_at": now}, "$inc": bson.M{"version": 1}},
+		); err != nil {
+			return fmt.Errorf("failed to move notes: %v", err)
+		}
+
+		// Children take the notebook's place; names clash when the parent already has a
+		// notebook with the same name, which aborts the whole deletion
+		if _, err := m.collection.UpdateMany(ctx,
+			bson.M{"user_id": userID, "parent_id": id},
+			bson.M{"$set": bson.M{"parent_id": notebook.ParentID, "updated_at": now}},
+		); err != nil {
+			if mongo.IsDuplicateKeyError(err) {
+				return ErrNotebookNameTaken
+			}
+			return fmt.Errorf("failed to move sub-notebooks: %v", err)
+		}
+		if _, err := m.collection.UpdateMany(ctx,
+			bson.M{"user_id": userID, "path": id},
+			bson.M{"$pull": bson.M{"path": id}},
+		); err != nil {
+			return fmt.Errorf("failed to move sub-notebooks: %v", err)
+		}
+
+		if _, err := m.collection.DeleteOne(ctx, bson.M{"_id": id, "user_id": userID}); err != nil {
+			return fmt.Errorf("failed to delete notebook: %v", err)
+		}
+		return nil
+	})
+}
+
+// childPath returns the path of a notebook created under parentID
+func (m *NotebookModel) childPath(userID primitive.ObjectID, parentID *primitive.ObjectID) ([]primitive.ObjectID, error) {
+	if parentID == nil {
+		return []primitive.ObjectID{}, nil
+	}
+
+	parent, err := m.GetByID(*parentID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	path := append(append([]primitive.ObjectID{}, parent.Path...), parent.ID)
+	if len(path) >= maxNotebookDepth {
+		return nil, ErrNotebookTooDeep
+	}
+	return path, nil
+}
+
+// subtreeDepth returns how many levels the notebook and its sub-notebooks span
+func (m *NotebookModel) subtreeDepth(notebook *Notebook) (int, error) {
+	cursor, err := m.collection.Find(
+		context.Background(),
+		bson.M{"user_id": notebook.UserID, "path": notebook.ID},
+		options.Find().SetProjection(bson.M{"path": 1}),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch sub-notebooks: %v", err)
+	}
+	defer cursor.Close(context.Background())
+
+	var descendants []Notebook
+	if err := cursor.All(context.Background(), &descendants); err != nil {
+		return 0, fmt.Errorf("failed to decode sub-notebooks: %v", err)
+	}
+
+	depth := 1
+	for _, d := range descendants {
+		if levels := len(d.Path) - len(notebook.Path) + 1; levels > depth {
+			depth = levels
+		}
+	}
+	return depth, nil
+}
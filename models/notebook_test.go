@@ -0,0 +1,45 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/suraj/GoGoNotes/internal/testutil"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestDeleteNotebookOnStandaloneServer(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("notes move up with a new updated_at", func(mt *mtest.T) {
+		m := NewNotebookModel(mt.Client, mt.Coll, mt.Coll)
+		parentID := primitive.NewObjectID()
+		notebook := Notebook{ID: primitive.NewObjectID(), UserID: primitive.NewObjectID(), ParentID: &parentID, Path: []primitive.ObjectID{parentID}, Name: "Work"}
+		standalone := mtest.CreateSuccessResponse(bson.E{Key: "isWritablePrimary", Value: true})
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "test.notebooks", mtest.FirstBatch, testutil.ToDoc(notebook)),
+			mtest.CreateCursorResponse(0, "test.notebooks", mtest.FirstBatch),
+			standalone,
+			testutil.UpdateResponse(2), testutil.UpdateResponse(0), testutil.UpdateResponse(0), testutil.DeleteResponse(1),
+		)
+
+		before := time.Now()
+		if err := m.Delete(notebook.ID, notebook.UserID, false); err != nil {
+			mt.Fatal(err)
+		}
+
+		mt.GetStartedEvent()
+		mt.GetStartedEvent()
+		mt.GetStartedEvent()
+		notes := mt.GetStartedEvent()
+		if notes.Command.Lookup("txnNumber").Type != 0 {
+			mt.Errorf("a standalone server can't run transactions, got %s", notes.Command)
+		}
+		set := notes.Command.Lookup("updates", "0", "u", "$set")
+		if set.Document().Lookup("notebook_id").ObjectID() != parentID || set.Document().Lookup("updated_at").Time().Before(before.Truncate(time.Millisecond)) {
+			mt.Errorf("notes must move to the parent with a new updated_at, got %s", set)
+		}
+	})
+}
@@ -0,0 +1,178 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// PersonalAccessTokenPrefix marks personal access tokens so they can be told apart from session JWTs
+const PersonalAccessTokenPrefix = "ggn_pat_"
+
+// Scopes that can be granted to a personal access token. Session tokens have all of them.
+const (
+	ScopeNotesRead  = "notes:read"
+	ScopeNotesWrite = "notes:write"
+)
+
+var AllScopes = []string{ScopeNotesRead, ScopeNotesWrite}
+
+var (
+	ErrPersonalAccessTokenInvalid = errors.New("invalid, expired or revoked personal access token")
+	ErrTokenNotFound              = errors.New("token not found")
+)
+
+// PersonalAccessToken lets scripts and integrations call the API without the user's password.
+// Only the hash of the token is stored; Prefix is kept so users can recognise their tokens.
+type PersonalAccessToken struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID     primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Name       string             `bson:"name" json:"name"`
+	Prefix     string             `bson:"prefix" json:"prefix"`
+	TokenHash  string             `bson:"token_hash" json:"-"`
+	Scopes     []string           `bson:"scopes" json:"scopes"`
+	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+	ExpiresAt  *time.Time         `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
+	LastUsedAt *time.Time         `bson:"last_used_at,omitempty" json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time         `bson:"revoked_at,omitempty" json:"-"`
+}
+
+// lastUsedResolution limits how often last_used_at is written for a busy token
+const lastUsedResolution = time.Minute
+
+type PersonalAccessTokenModel struct {
+	collection *mongo.Collection
+}
+
+func NewPersonalAccessTokenModel(collection *mongo.Collection) *PersonalAccessTokenModel {
+	return &PersonalAccessTokenModel{collection: collection}
+}
+
+func (m *PersonalAccessTokenModel) EnsureIndexes() error {
+	_, err := m.collection.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create personal access token indexes: %v", err)
+	}
+	return nil
+}
+
+// Create issues a new token. The plain token is returned once and never stored.
+func (m *PersonalAccessTokenModel) Create(userID primitive.ObjectID, name string, scopes []string, expiresAt *time.Time) (string, *PersonalAccessToken, error) {
+	secret, err := generateToken(32)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to generate token: %v", err)
+	}
+	token := PersonalAccessTokenPrefix + secret
+
+	pat := &PersonalAccessToken{
+		UserID:    userID,
+		Name:      name,
+		Prefix:    token[:len(PersonalAccessTokenPrefix)+6],
+		TokenHash: hashToken(token),
+		Scopes:    scopes,
+		CreatedAt: time.Now(),
+		ExpiresAt: expiresAt,
+	}
+
+	result, err := m.collection.InsertOne(context.Background(), pat)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create token: %v", err)
+	}
+
+	pat.ID = result.InsertedID.(primitive.ObjectID)
+	return token, pat, nil
+}
+
+func (m *PersonalAccessTokenModel) GetAll(userID primitive.ObjectID) ([]PersonalAccessToken, error) {
+	var tokens []PersonalAccessToken
+
+	cursor, err := m.collection.Find(
+		context.Background(),
+		bson.M{"user_id": userID, "revoked_at": nil},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}),
+	)
+	if err != nil {
+		return []PersonalAccessToken{}, fmt.Errorf("failed to fetch tokens: %v", err)
+	}
+	defer cursor.Close(context.Background())
+
+	if err := cursor.All(context.Background(), &tokens); err != nil {
+		return []PersonalAccessToken{}, fmt.Errorf("failed to decode tokens: %v", err)
+	}
+
+	if tokens == nil {
+		return []PersonalAccessToken{}, nil
+	}
+
+	return tokens, nil
+}
+
+func (m *PersonalAccessTokenModel) Revoke(id, userID primitive.ObjectID) error {
+	result, err := m.collection.UpdateOne(
+		context.Background(),
+		bson.M{"_id": id, "user_id": userID, "revoked_at": nil},
+		bson.M{"$set": bson.M{"revoked_at": time.Now()}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to revoke token: %v", err)
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrTokenNotFound
+	}
+
+	return nil
+}
+
+// RevokeAllForUser revokes every token of the user, for when their password may have been compromised
+func (m *PersonalAccessTokenModel) RevokeAllForUser(userID primitive.ObjectID) error {
+	_, err := m.collection.UpdateMany(
+		context.Background(),
+		bson.M{"user_id": userID, "revoked_at": nil},
+		bson.M{"$set": bson.M{"revoked_at": time.Now()}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to revoke tokens: %v", err)
+	}
+	return nil
+}
+
+// Authenticate looks up a presented token and records that it was used
+func (m *PersonalAccessTokenModel) Authenticate(token string) (*PersonalAccessToken, error) {
+	var pat PersonalAccessToken
+	err := m.collection.FindOne(context.Background(), bson.M{"token_hash": hashToken(token)}).Decode(&pat)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrPersonalAccessTokenInvalid
+		}
+		return nil, fmt.Errorf("failed to find token: %v", err)
+	}
+
+	now := time.Now()
+	if pat.RevokedAt != nil || (pat.ExpiresAt != nil && now.After(*pat.ExpiresAt)) {
+		return nil, ErrPersonalAccessTokenInvalid
+	}
+
+	if pat.LastUsedAt == nil || now.Sub(*pat.LastUsedAt) > lastUsedResolution {
+		_, err = m.collection.UpdateOne(
+			context.Background(),
+			bson.M{"_id": pat.ID},
+			bson.M{"$set": bson.M{"last_used_at": now}},
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update token: %v", err)
+		}
+		pat.LastUsedAt = &now
+	}
+
+	return &pat, nil
+}
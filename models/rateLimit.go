@@ -0,0 +1,77 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RateLimit allows Limit requests per key in every Window
+type RateLimit struct {
+	Limit  int
+	Window time.Duration
+}
+
+// rateCounter counts the requests for one key in one window
+type rateCounter struct {
+	Key       string    `bson:"_id"`
+	Count     int       `bson:"count"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+// RateLimitModel keeps fixed window request counters, so they are shared by every
+// instance of the server and survive restarts
+type RateLimitModel struct {
+	collection *mongo.Collection
+}
+
+func NewRateLimitModel(collection *mongo.Collection) *RateLimitModel {
+	return &RateLimitModel{collection: collection}
+}
+
+func (m *RateLimitModel) EnsureIndexes() error {
+	_, err := m.collection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create rate limit indexes: %v", err)
+	}
+	return nil
+}
+
+// Allow counts a request for key and returns how long to wait when the limit of the
+// current window is used up. A zero limit or window disables the check.
+func (m *RateLimitModel) Allow(key string, limit RateLimit) (time.Duration, error) {
+	if limit.Limit <= 0 || limit.Window <= 0 {
+		return 0, nil
+	}
+
+	now := time.Now()
+	windowStart := now.Truncate(limit.Window)
+	windowEnd := windowStart.Add(limit.Window)
+
+	var counter rateCounter
+	err := m.collection.FindOneAndUpdate(
+		context.Background(),
+		bson.M{"_id": key + ":" + strconv.FormatInt(windowStart.Unix(), 10)},
+		bson.M{
+			"$inc":         bson.M{"count": 1},
+			"$setOnInsert": bson.M{"expires_at": windowEnd},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&counter)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count request: %v", err)
+	}
+
+	if counter.Count > limit.Limit {
+		return windowEnd.Sub(now), nil
+	}
+	return 0, nil
+}
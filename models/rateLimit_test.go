@@ -0,0 +1,63 @@
+package models
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// counterResponse is the reply to the upsert in Allow, with the count after the increment
+func counterResponse(count int) bson.D {
+	return mtest.CreateSuccessResponse(bson.E{Key: "value", Value: bson.D{
+		{Key: "_id", Value: "key"},
+		{Key: "count", Value: count},
+	}})
+}
+
+func TestRateLimitAllow(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	limit := RateLimit{Limit: 3, Window: time.Hour}
+
+	mt.Run("within the limit", func(mt *mtest.T) {
+		m := NewRateLimitModel(mt.Coll)
+		mt.AddMockResponses(counterResponse(3))
+
+		if wait, err := m.Allow("forgot:bob", limit); err != nil || wait != 0 {
+			mt.Fatalf("Allow = %s, %v, want allowed", wait, err)
+		}
+
+		command := mt.GetStartedEvent().Command
+		windowStart := time.Now().Truncate(time.Hour).Unix()
+		if key := command.Lookup("query", "_id").StringValue(); !strings.HasPrefix(key, "forgot:bob:") || !strings.HasSuffix(key, ":"+strconv.FormatInt(windowStart, 10)) {
+			mt.Errorf("counted under %q, want a key for the current window", key)
+		}
+		if !command.Lookup("upsert").Boolean() {
+			mt.Error("the counter must be created on the first request")
+		}
+	})
+
+	mt.Run("over the limit", func(mt *mtest.T) {
+		m := NewRateLimitModel(mt.Coll)
+		mt.AddMockResponses(counterResponse(4))
+
+		wait, err := m.Allow("forgot:bob", limit)
+		if err != nil || wait <= 0 || wait > time.Hour {
+			mt.Errorf("Allow = %s, %v, want to wait for the rest of the window", wait, err)
+		}
+	})
+
+	mt.Run("disabled", func(mt *mtest.T) {
+		m := NewRateLimitModel(mt.Coll)
+
+		if wait, err := m.Allow("forgot:bob", RateLimit{}); err != nil || wait != 0 {
+			mt.Errorf("Allow = %s, %v, want allowed", wait, err)
+		}
+		if event := mt.GetStartedEvent(); event != nil {
+			mt.Errorf("nothing should be counted, got %s", event.CommandName)
+		}
+	})
+}
@@ -0,0 +1,157 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrRefreshTokenInvalid = errors.New("invalid or expired refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected")
+)
+
+// RefreshToken is a single link in a refresh token family. Every rotation
+// marks the current token as used and issues a new one in the same family.
+type RefreshToken struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID    primitive.ObjectID `bson:"user_id" json:"user_id"`
+	FamilyID  primitive.ObjectID `bson:"family_id" json:"family_id"`
+	TokenHash string             `bson:"token_hash" json:"-"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	ExpiresAt time.Time          `bson:"expires_at" json:"expires_at"`
+	UsedAt    *time.Time         `bson:"used_at,omitempty" json:"used_at,omitempty"`
+	RevokedAt *time.Time         `bson:"revoked_at,omitempty" json:"revoked_at,omitempty"`
+}
+
+type RefreshTokenModel struct {
+	collection *mongo.Collection
+	ttl        time.Duration
+}
+
+func NewRefreshTokenModel(collection *mongo.Collection, ttl time.Duration) *RefreshTokenModel {
+	return &RefreshTokenModel{
+		collection: collection,
+		ttl:        ttl,
+	}
+}
+
+// EnsureIndexes creates the lookup indexes and lets Mongo expire old tokens on its own
+func (m *RefreshTokenModel) EnsureIndexes() error {
+	_, err := m.collection.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "family_id", Value: 1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create refresh token indexes: %v", err)
+	}
+	return nil
+}
+
+// Create issues a new refresh token for the user. Pass primitive.NilObjectID
+// as familyID to start a new family (i.e. a new login).
+func (m *RefreshTokenModel) Create(userID, familyID primitive.ObjectID) (string, *RefreshToken, error) {
+	token, err := generateToken(32)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to generate refresh token: %v", err)
+	}
+
+	if familyID.IsZero() {
+		familyID = primitive.NewObjectID()
+	}
+
+	now := time.Now()
+	refreshToken := &RefreshToken{
+		UserID:    userID,
+		FamilyID:  familyID,
+		TokenHash: hashToken(token),
+		CreatedAt: now,
+		ExpiresAt: now.Add(m.ttl),
+	}
+
+	result, err := m.collection.InsertOne(context.Background(), refreshToken)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to store refresh token: %v", err)
+	}
+
+	refreshToken.ID = result.InsertedID.(primitive.ObjectID)
+	return token, refreshToken, nil
+}
+
+// Rotate exchanges a refresh token for a new one in the same family. Presenting
+// a token that was already used revokes the whole family, since that means
+// either the client or an attacker is holding a stale copy.
+func (m *RefreshTokenModel) Rotate(token string) (string, *RefreshToken, error) {
+	var existing RefreshToken
+	err := m.collection.FindOne(context.Background(), bson.M{"token_hash": hashToken(token)}).Decode(&existing)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return "", nil, ErrRefreshTokenInvalid
+		}
+		return "", nil, fmt.Errorf("failed to find refresh token: %v", err)
+	}
+
+	if existing.RevokedAt != nil || time.Now().After(existing.ExpiresAt) {
+		return "", nil, ErrRefreshTokenInvalid
+	}
+
+	if existing.UsedAt != nil {
+		if err := m.RevokeFamily(existing.FamilyID); err != nil {
+			return "", nil, err
+		}
+		return "", nil, ErrRefreshTokenReused
+	}
+
+	// Claim the token atomically so two concurrent refreshes can't both succeed
+	result, err := m.collection.UpdateOne(
+		context.Background(),
+		bson.M{"_id": existing.ID, "used_at": nil, "revoked_at": nil},
+		bson.M{"$set": bson.M{"used_at": time.Now()}},
+	)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to rotate refresh token: %v", err)
+	}
+
+	if result.ModifiedCount == 0 {
+		if err := m.RevokeFamily(existing.FamilyID); err != nil {
+			return "", nil, err
+		}
+		return "", nil, ErrRefreshTokenReused
+	}
+
+	return m.Create(existing.UserID, existing.FamilyID)
+}
+
+// RevokeFamily revokes every token that descends from the same login
+func (m *RefreshTokenModel) RevokeFamily(familyID primitive.ObjectID) error {
+	_, err := m.collection.UpdateMany(
+		context.Background(),
+		bson.M{"family_id": familyID, "revoked_at": nil},
+		bson.M{"$set": bson.M{"revoked_at": time.Now()}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to revoke refresh tokens: %v", err)
+	}
+	return nil
+}
+
+// RevokeAllForUser revokes every refresh token the user holds, on every device
+func (m *RefreshTokenModel) RevokeAllForUser(userID primitive.ObjectID) error {
+	_, err := m.collection.UpdateMany(
+		context.Background(),
+		bson.M{"user_id": userID, "revoked_at": nil},
+		bson.M{"$set": bson.M{"revoked_at": time.Now()}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to revoke refresh tokens: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/suraj/GoGoNotes/internal/testutil"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// refreshTokenResponse is the reply to the lookup of a stored refresh token
+func refreshTokenResponse(token RefreshToken) bson.D {
+	return mtest.CreateCursorResponse(0, "test.refresh_tokens", mtest.FirstBatch, testutil.ToDoc(token))
+}
+
+func TestRotate(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	stored := func() RefreshToken {
+		return RefreshToken{
+			ID:        primitive.NewObjectID(),
+			UserID:    primitive.NewObjectID(),
+			FamilyID:  primitive.NewObjectID(),
+			TokenHash: hashToken("old"),
+			CreatedAt: time.Now().Add(-time.Hour),
+			ExpiresAt: time.Now().Add(time.Hour),
+		}
+	}
+
+	mt.Run("issues a new token in the same family", func(mt *mtest.T) {
+		m := NewRefreshTokenModel(mt.Coll, time.Hour)
+		existing := stored()
+		mt.AddMockResponses(refreshTokenResponse(existing), testutil.UpdateResponse(1), mtest.CreateSuccessResponse())
+
+		token, next, err := m.Rotate("old")
+		if err != nil {
+			mt.Fatal(err)
+		}
+		if token == "" || token == "old" || next.TokenHash != hashToken(token) {
+			mt.Errorf("got token %q with hash %q", token, next.TokenHash)
+		}
+		if next.FamilyID != existing.FamilyID || next.UserID != existing.UserID {
+			mt.Errorf("new token left the family: %+v", next)
+		}
+		if next.ID == existing.ID {
+			mt.Error("new token reuses the old ID")
+		}
+
+		mt.GetStartedEvent()
+		claim := mt.GetStartedEvent().Command.Lookup("updates", "0", "q")
+		if claim.Document().Lookup("_id").ObjectID() != existing.ID || claim.Document().Lookup("used_at").Type != bson.TypeNull {
+			mt.Errorf("old token must be claimed only if unused, got %s", claim)
+		}
+	})
+
+	mt.Run("reuse revokes the family", func(mt *mtest.T) {
+		m := NewRefreshTokenModel(mt.Coll, time.Hour)
+		existing := stored()
+		usedAt := time.Now().Add(-time.Minute)
+		existing.UsedAt = &usedAt
+		mt.AddMockResponses(refreshTokenResponse(existing), testutil.UpdateResponse(3))
+
+		if _, _, err := m.Rotate("old"); err != ErrRefreshTokenReused {
+			mt.Fatalf("Rotate = %v, want %v", err, ErrRefreshTokenReused)
+		}
+
+		mt.GetStartedEvent()
+		revoke := mt.GetStartedEvent()
+		if family := revoke.Command.Lookup("updates", "0", "q", "family_id").ObjectID(); family != existing.FamilyID {
+			mt.Errorf("revoked family %s, want %s", family.Hex(), existing.FamilyID.Hex())
+		}
+		if !revoke.Command.Lookup("updates", "0", "multi").Boolean() {
+			mt.Error("every token of the family must be revoked")
+		}
+	})
+
+	mt.Run("losing a concurrent rotation revokes the family", func(mt *mtest.T) {
+		m := NewRefreshTokenModel(mt.Coll, time.Hour)
+		existing := stored()
+		mt.AddMockResponses(refreshTokenResponse(existing), testutil.UpdateResponse(0), testutil.UpdateResponse(2))
+
+		if _, _, err := m.Rotate("old"); err != ErrRefreshTokenReused {
+			mt.Fatalf("Rotate = %v, want %v", err, ErrRefreshTokenReused)
+		}
+
+		mt.GetStartedEvent()
+		mt.GetStartedEvent()
+		if family := mt.GetStartedEvent().Command.Lookup("updates", "0", "q", "family_id").ObjectID(); family != existing.FamilyID {
+			mt.Errorf("revoked family %s, want %s", family.Hex(), existing.FamilyID.Hex())
+		}
+	})
+
+	mt.Run("revoked and expired tokens are invalid", func(mt *mtest.T) {
+		m := NewRefreshTokenModel(mt.Coll, time.Hour)
+
+		revoked := stored()
+		revokedAt := time.Now()
+		revoked.RevokedAt = &revokedAt
+		expired := stored()
+		expired.ExpiresAt = time.Now().Add(-time.Second)
+		mt.AddMockResponses(refreshTokenResponse(revoked), refreshTokenResponse(expired))
+
+		for _, name := range []string{"revoked", "expired"} {
+			if _, _, err := m.Rotate("old"); err != ErrRefreshTokenInvalid {
+				mt.Errorf("%s: Rotate = %v, want %v", name, err, ErrRefreshTokenInvalid)
+			}
+		}
+		mt.GetStartedEvent()
+		mt.GetStartedEvent()
+		if event := mt.GetStartedEvent(); event != nil {
+			mt.Errorf("nothing should be written, got %s", event.CommandName)
+		}
+	})
+
+	mt.Run("unknown token", func(mt *mtest.T) {
+		m := NewRefreshTokenModel(mt.Coll, time.Hour)
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "test.refresh_tokens", mtest.FirstBatch))
+
+		if _, _, err := m.Rotate("nope"); err != ErrRefreshTokenInvalid {
+			mt.Errorf("Rotate = %v, want %v", err, ErrRefreshTokenInvalid)
+		}
+	})
+}
@@ -0,0 +1,70 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RevokedToken records an access token (by jti) that must no longer be accepted.
+// The document only needs to live as long as the token itself would have.
+type RevokedToken struct {
+	ID        string             `bson:"_id" json:"id"`
+	UserID    primitive.ObjectID `bson:"user_id" json:"user_id"`
+	RevokedAt time.Time          `bson:"revoked_at" json:"revoked_at"`
+	ExpiresAt time.Time          `bson:"expires_at" json:"expires_at"`
+}
+
+type RevokedTokenModel struct {
+	collection *mongo.Collection
+}
+
+func NewRevokedTokenModel(collection *mongo.Collection) *RevokedTokenModel {
+	return &RevokedTokenModel{collection: collection}
+}
+
+// EnsureIndexes creates a TTL index so entries disappear once the token would have expired anyway
+func (m *RevokedTokenModel) EnsureIndexes() error {
+	_, err := m.collection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create revoked token indexes: %v", err)
+	}
+	return nil
+}
+
+func (m *RevokedTokenModel) Revoke(tokenID string, userID primitive.ObjectID, expiresAt time.Time) error {
+	_, err := m.collection.UpdateOne(
+		context.Background(),
+		bson.M{"_id": tokenID},
+		bson.M{"$setOnInsert": RevokedToken{
+			ID:        tokenID,
+			UserID:    userID,
+			RevokedAt: time.Now(),
+			ExpiresAt: expiresAt,
+		}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to revoke token: %v", err)
+	}
+	return nil
+}
+
+func (m *RevokedTokenModel) IsRevoked(tokenID string) (bool, error) {
+	err := m.collection.FindOne(context.Background(), bson.M{"_id": tokenID}).Err()
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to check token revocation: %v", err)
+	}
+	return true, nil
+}
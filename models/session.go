@@ -0,0 +1,185 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// lastSeenResolution limits how often a session's last_seen_at is written
+const lastSeenResolution = time.Minute
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// Session is a single login on a device. Its ID doubles as the family ID of the
+// session's refresh tokens and is carried in the sid claim of its access tokens,
+// so deleting the session ends both.
+type Session struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID     primitive.ObjectID `bson:"user_id" json:"user_id"`
+	UserAgent  string             `bson:"user_agent" json:"user_agent"`
+	IP         string             `bson:"ip" json:"ip"`
+	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+	LastSeenAt time.Time          `bson:"last_seen_at" json:"last_seen_at"`
+	ExpiresAt  time.Time          `bson:"expires_at" json:"expires_at"`
+}
+
+type SessionModel struct {
+	collection *mongo.Collection
+	ttl        time.Duration
+}
+
+// NewSessionModel takes the idle timeout of a session, which should match the refresh token lifetime
+func NewSessionModel(collection *mongo.Collection, ttl time.Duration) *SessionModel {
+	return &SessionModel{
+		collection: collection,
+		ttl:        ttl,
+	}
+}
+
+func (m *SessionModel) EnsureIndexes() error {
+	_, err := m.collection.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "last_seen_at", Value: -1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create session indexes: %v", err)
+	}
+	return nil
+}
+
+func (m *SessionModel) Create(userID primitive.ObjectID, userAgent, ip string) (*Session, error) {
+	now := time.Now()
+	session := &Session{
+		UserID:     userID,
+		UserAgent:  userAgent,
+		IP:         ip,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(m.ttl),
+	}
+
+	result, err := m.collection.InsertOne(context.Background(), session)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session: %v", err)
+	}
+
+	session.ID = result.InsertedID.(primitive.ObjectID)
+	return session, nil
+}
+
+// Touch checks that the session still exists and records that it was just used from ip.
+// It returns ErrSessionNotFound once the session was deleted or has expired.
+func (m *SessionModel) Touch(id, userID primitive.ObjectID, ip string) (*Session, error) {
+	now := time.Now()
+
+	var session Session
+	err := m.collection.FindOne(context.Background(), bson.M{
+		"_id":        id,
+		"user_id":    userID,
+		"expires_at": bson.M{"$gt": now},
+	}).Decode(&session)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrSessionNotFound
+		}
+		return nil, fmt.Errorf("failed to find session: %v", err)
+	}
+
+	if now.Sub(session.LastSeenAt) > lastSeenResolution || session.IP != ip {
+		_, err = m.collection.UpdateOne(
+			context.Background(),
+			bson.M{"_id": session.ID},
+			bson.M{"$set": bson.M{
+				"ip":           ip,
+				"last_seen_at": now,
+				"expires_at":   now.Add(m.ttl),
+			}},
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update session: %v", err)
+		}
+		session.IP = ip
+		session.LastSeenAt = now
+		session.ExpiresAt = now.Add(m.ttl)
+	}
+
+	return &session, nil
+}
+
+// Get returns one of the user's active sessions, or ErrSessionNotFound
+func (m *SessionModel) Get(id, userID primitive.ObjectID) (*Session, error) {
+	var session Session
+	err := m.collection.FindOne(context.Background(), bson.M{
+		"_id":        id,
+		"user_id":    userID,
+		"expires_at": bson.M{"$gt": time.Now()},
+	}).Decode(&session)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrSessionNotFound
+		}
+		return nil, fmt.Errorf("failed to find session: %v", err)
+	}
+	return &session, nil
+}
+
+// GetAll returns the user's active sessions, most recently used first
+func (m *SessionModel) GetAll(userID primitive.ObjectID) ([]Session, error) {
+	var sessions []Session
+
+	cursor, err := m.collection.Find(
+		context.Background(),
+		bson.M{"user_id": userID, "expires_at": bson.M{"$gt": time.Now()}},
+		options.Find().SetSort(bson.D{{Key: "last_seen_at", Value: -1}}),
+	)
+	if err != nil {
+		return []Session{}, fmt.Errorf("failed to fetch sessions: %v", err)
+	}
+	defer cursor.Close(context.Background())
+
+	if err := cursor.All(context.Background(), &sessions); err != nil {
+		return []Session{}, fmt.Errorf("failed to decode sessions: %v", err)
+	}
+
+	if sessions == nil {
+		return []Session{}, nil
+	}
+
+	return sessions, nil
+}
+
+func (m *SessionModel) Delete(id, userID primitive.ObjectID) error {
+	result, err := m.collection.DeleteOne(context.Background(), bson.M{"_id": id, "user_id": userID})
+	if err != nil {
+		return fmt.Errorf("failed to delete session: %v", err)
+	}
+	if result.DeletedCount == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// DeleteAllForUser ends every session of the user, on every device
+func (m *SessionModel) DeleteAllForUser(userID primitive.ObjectID) error {
+	_, err := m.collection.DeleteMany(context.Background(), bson.M{"user_id": userID})
+	if err != nil {
+		return fmt.Errorf("failed to delete sessions: %v", err)
+	}
+	return nil
+}
+
+// DeleteOthers ends every session of the user except the current one
+func (m *SessionModel) DeleteOthers(userID, currentID primitive.ObjectID) error {
+	_, err := m.collection.DeleteMany(context.Background(), bson.M{"user_id": userID, "_id": bson.M{"$ne": currentID}})
+	if err != nil {
+		return fmt.Errorf("failed to delete sessions: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/suraj/GoGoNotes/internal/testutil"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestTouchSession(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	userID := primitive.NewObjectID()
+	tests := []struct {
+		name       string
+		lastSeenAt time.Duration
+		ip         string
+		wantWrite  bool
+	}{
+		{"used a moment ago from the same address", -10 * time.Second, "192.0.2.1", false},
+		{"used a while ago", -2 * lastSeenResolution, "192.0.2.1", true},
+		{"used from a new address", -10 * time.Second, "198.51.100.7", true},
+	}
+
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			m := NewSessionModel(mt.Coll, time.Hour)
+			session := Session{ID: primitive.NewObjectID(), UserID: userID, IP: "192.0.2.1", LastSeenAt: time.Now().Add(tt.lastSeenAt), ExpiresAt: time.Now().Add(time.Minute)}
+			mt.AddMockResponses(testutil.FindResponse(session), testutil.UpdateResponse(1))
+
+			touched, err := m.Touch(session.ID, userID, tt.ip)
+			if err != nil {
+				mt.Fatal(err)
+			}
+
+			mt.GetStartedEvent()
+			write := mt.GetStartedEvent()
+			if (write != nil) != tt.wantWrite {
+				mt.Fatalf("wrote the session: %v, want %v", write != nil, tt.wantWrite)
+			}
+			if !tt.wantWrite {
+				return
+			}
+			if touched.IP != tt.ip || time.Until(touched.ExpiresAt) < 59*time.Minute {
+				mt.Errorf("session %+v was not extended", touched)
+			}
+			if write.Command.Lookup("updates", "0", "u", "$set", "ip").StringValue() != tt.ip {
+				mt.Errorf("got %s", write.Command)
+			}
+		})
+	}
+
+	mt.Run("signed out or expired", func(mt *mtest.T) {
+		m := NewSessionModel(mt.Coll, time.Hour)
+		mt.AddMockResponses(testutil.FindResponse())
+
+		if _, err := m.Touch(primitive.NewObjectID(), userID, "192.0.2.1"); err != ErrSessionNotFound {
+			mt.Errorf("got %v", err)
+		}
+		if filter := mt.GetStartedEvent().Command.Lookup("filter", "expires_at", "$gt"); filter.IsZero() {
+			mt.Error("expired sessions must not be found")
+		}
+	})
+}
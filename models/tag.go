@@ -0,0 +1,190 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Limits on tags, so a note's tag list stays something a person can read
+const (
+	maxTagLength   = 50
+	maxTagsPerNote = 20
+)
+
+// TagCount is a tag together with how many of the user's notes carry it
+type TagCount struct {
+	Name  string `bson:"_id" json:"name"`
+	Count int    `bson:"count" json:"count"`
+}
+
+// NormalizeTag lowercases a tag and turns runs of whitespace into a single dash, so
+// "Work Items" and "work-items" are the same tag. Only letters, digits and - _ . are allowed.
+func NormalizeTag(tag string) (string, error) {
+	tag = strings.ToLower(strings.Join(strings.Fields(tag), "-"))
+	if tag == "" {
+		return "", fmt.Errorf("tags can't be empty")
+	}
+	if utf8.RuneCountInString(tag) > maxTagLength {
+		return "", fmt.Errorf("tag %q is longer than %d characters", tag, maxTagLength)
+	}
+	for _, r := range tag {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' && r != '_' && r != '.' {
+			return "", fmt.Errorf("tag %q may only contain letters, digits, - _ and .", tag)
+		}
+	}
+	return tag, nil
+}
+
+// NormalizeTags normalizes every tag, drops duplicates and sorts them
+func NormalizeTags(tags []string) ([]string, error) {
+	seen := make(map[string]bool)
+	normalized := []string{}
+	for _, tag := range tags {
+		tag, err := NormalizeTag(tag)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+
+	if len(normalized) > maxTagsPerNote {
+		return nil, fmt.Errorf("a note can have at most %d tags", maxTagsPerNote)
+	}
+
+	sort.Strings(normalized)
+	return normalized, nil
+}
+
+// GetTags returns every tag on the user's notes outside the trash with its note count, sorted by name
+func (m *NoteModel) GetTags(userID primitive.ObjectID) ([]TagCount, error) {
+	var tags []TagCount
+
+	cursor, err := m.collection.Aggregate(context.Background(), bson.A{
+		bson.M{"$match": bson.M{"user_id": userID, "deleted_at": nil}},
+		bson.M{"$unwind": "$tags"},
+		bson.M{"$group": bson.M{"_id": "$tags", "count": bson.M{"$sum": 1}}},
+		bson.M{"$sort": bson.M{"_id": 1}},
+	})
+	if err != nil {
+		return []TagCount{}, fmt.Errorf("failed to fetch tags: %v", err)
+	}
+	defer cursor.Close(context.Background())
+
+	if err := cursor.All(context.Background(), &tags); err != nil {
+		return []TagCount{}, fmt.Errorf("failed to decode tags: %v", err)
+	}
+
+	if tags == nil {
+		return []TagCount{}, nil
+	}
+
+	return tags, nil
+}
+
+// ReplaceTags replaces the tags in from with the tag to on every note of the user
+// that has any of them, which covers both renaming a tag and merging several into one.
+// Notes in the trash are changed too, so they come back with the current tags.
+// It returns how many notes were changed.
+func (m *NoteModel) ReplaceTags(userID primitive.ObjectID, from []string, to string) (int64, error) {
+	return m.retag(bson.M{"user_id": userID, "tags": bson.M{"$in": from}}, func(tags []string) []string {
+		replaced := []string{to}
+		for _, tag := range tags {
+			if !slices.Contains(from, tag) && tag != to {
+				replaced = append(replaced, tag)
+			}
+		}
+		sort.Strings(replaced)
+		return replaced
+	})
+}
+
+// DeleteTag removes the tag from every note of the user and returns how many notes were changed
+func (m *NoteModel) DeleteTag(userID primitive.ObjectID, tag string) (int64, error) {
+	return m.retag(bson.M{"user_id": userID, "tags": tag}, func(tags []string) []string {
+		return slices.DeleteFunc(slices.Clone(tags), func(t string) bool { return t == tag })
+	})
+}
+
+// retagAttempts bounds how often a tag change is reapplied to a note whose tags are
+// being edited at the same time
+const retagAttempts = 3
+
+// retag rewrites the tags of the notes matching filter one note at a time, so every
+// note gets a new version, updated_at and revision like any other edit. A note is
+// only written if its tags are still the ones change was applied to. It returns how
+// many notes were changed.
+func (m *NoteModel) retag(filter bson.M, change func(tags []string) []string) (int64, error) {
+	ids, err := m.noteIDs(filter, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	var changed int64
+	for _, id := range ids {
+		ok, err := m.retagNote(id, filter, change)
+		if err != nil {
+			return changed, err
+		}
+		if ok {
+			changed++
+		}
+	}
+	return changed, nil
+}
+
+// retagNote applies change to the note if it still matches filter and reports whether it was changed
+func (m *NoteModel) retagNote(id primitive.ObjectID, filter bson.M, change func(tags []string) []string) (bool, error) {
+	filter = bson.M{"$and": bson.A{filter, bson.M{"_id": id}}}
+	for attempt := 0; attempt < retagAttempts; attempt++ {
+		var note Note
+		err := m.collection.FindOne(context.Background(), filter).Decode(&note)
+		if err == mongo.ErrNoDocuments {
+			// Deleted or retagged since the IDs were read
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("failed to fetch note: %v", err)
+		}
+
+		tags := change(note.Tags)
+		if slices.Equal(tags, note.Tags) {
+			return false, nil
+		}
+
+		if err := m.ensureBaseRevision(&note); err != nil {
+			return false, err
+		}
+
+		var updated Note
+		err = m.collection.FindOneAndUpdate(
+			context.Background(),
+			bson.M{"_id": note.ID, "tags": note.Tags},
+			bson.M{"$set": bson.M{"tags": tags, "updated_at": time.Now()}, "$inc": bson.M{"version": 1}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&updated)
+		if err == mongo.ErrNoDocuments {
+			continue
+		}
+		if err != nil {
+			return false, fmt.Errorf("failed to update tags: %v", err)
+		}
+
+		return true, m.recordRevision(&updated)
+	}
+
+	return false, fmt.Errorf("failed to update tags: note %s kept changing", id.Hex())
+}
@@ -0,0 +1,118 @@
+package models
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/suraj/GoGoNotes/internal/testutil"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestRetag(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	userID := primitive.NewObjectID()
+	noteID := primitive.NewObjectID()
+	note := func(version int64, tags ...string) Note {
+		return Note{ID: noteID, UserID: userID, Title: "title", Body: "body", Tags: tags, Version: version, UpdatedAt: time.Now().Add(-time.Hour)}
+	}
+	find := func(notes ...Note) bson.D {
+		docs := make([]bson.D, len(notes))
+		for i, n := range notes {
+			docs[i] = testutil.ToDoc(n)
+		}
+		return mtest.CreateCursorResponse(0, "test.notes", mtest.FirstBatch, docs...)
+	}
+	hasRevisions := mtest.CreateCursorResponse(0, "test.note_revisions", mtest.FirstBatch, bson.D{{Key: "n", Value: 1}})
+	saved := func(n Note) bson.D {
+		return mtest.CreateSuccessResponse(bson.E{Key: "value", Value: testutil.ToDoc(n)})
+	}
+	// stringArray reads a string array out of a command
+	stringArray := func(value bson.RawValue) []string {
+		values, _ := value.Array().Values()
+		out := make([]string, len(values))
+		for i, v := range values {
+			out[i] = v.StringValue()
+		}
+		return out
+	}
+
+	mt.Run("delete a tag", func(mt *mtest.T) {
+		m := NewNoteModel(mt.Coll, mt.Coll, mt.Coll, 0)
+		mt.AddMockResponses(find(note(2, "a", "b")), find(note(2, "a", "b")), hasRevisions, saved(note(3, "b")), mtest.CreateSuccessResponse())
+
+		changed, err := m.DeleteTag(userID, "a")
+		if err != nil || changed != 1 {
+			mt.Fatalf("DeleteTag = %d, %v", changed, err)
+		}
+
+		for range 3 {
+			mt.GetStartedEvent()
+		}
+		update := mt.GetStartedEvent().Command
+		if got := stringArray(update.Lookup("query", "tags")); !slices.Equal(got, []string{"a", "b"}) {
+			mt.Errorf("write must be conditional on the tags it was computed from, got %v", got)
+		}
+		if got := stringArray(update.Lookup("update", "$set", "tags")); !slices.Equal(got, []string{"b"}) {
+			mt.Errorf("tags set to %v", got)
+		}
+		if update.Lookup("update", "$set", "updated_at").IsZero() || update.Lookup("update", "$inc", "version").IsZero() {
+			mt.Errorf("tag changes must bump updated_at and the version, got %s", update)
+		}
+		revision := mt.GetStartedEvent().Command
+		if revision.Lookup("documents", "0", "number").Int32() != 3 || !slices.Equal(stringArray(revision.Lookup("documents", "0", "tags")), []string{"b"}) {
+			mt.Errorf("recorded revision %s", revision)
+		}
+	})
+
+	mt.Run("merge tags", func(mt *mtest.T) {
+		m := NewNoteModel(mt.Coll, mt.Coll, mt.Coll, 0)
+		mt.AddMockResponses(find(note(1, "a", "c", "z")), find(note(1, "a", "c", "z")), hasRevisions, saved(note(2, "b", "c")), mtest.CreateSuccessResponse())
+
+		if changed, err := m.ReplaceTags(userID, []string{"a", "z"}, "b"); err != nil || changed != 1 {
+			mt.Fatalf("ReplaceTags = %d, %v", changed, err)
+		}
+		for range 3 {
+			mt.GetStartedEvent()
+		}
+		if got := stringArray(mt.GetStartedEvent().Command.Lookup("update", "$set", "tags")); !slices.Equal(got, []string{"b", "c"}) {
+			mt.Errorf("tags set to %v, want [b c]", got)
+		}
+	})
+
+	mt.Run("nothing to change", func(mt *mtest.T) {
+		m := NewNoteModel(mt.Coll, mt.Coll, mt.Coll, 0)
+		mt.AddMockResponses(find(note(1, "x")), find(note(1, "x")))
+
+		if changed, err := m.ReplaceTags(userID, []string{"x", "y"}, "x"); err != nil || changed != 0 {
+			mt.Fatalf("ReplaceTags = %d, %v", changed, err)
+		}
+		mt.GetStartedEvent()
+		mt.GetStartedEvent()
+		if event := mt.GetStartedEvent(); event != nil {
+			mt.Errorf("an unchanged note must not be written, got %s", event.CommandName)
+		}
+	})
+
+	mt.Run("tags edited concurrently", func(mt *mtest.T) {
+		m := NewNoteModel(mt.Coll, mt.Coll, mt.Coll, 0)
+		mt.AddMockResponses(
+			find(note(1, "a")),
+			find(note(1, "a")), hasRevisions, mtest.CreateSuccessResponse(bson.E{Key: "value", Value: nil}),
+			find(note(2, "a", "d")), hasRevisions, saved(note(3, "d")), mtest.CreateSuccessResponse(),
+		)
+
+		if changed, err := m.DeleteTag(userID, "a"); err != nil || changed != 1 {
+			mt.Fatalf("DeleteTag = %d, %v", changed, err)
+		}
+		for range 6 {
+			mt.GetStartedEvent()
+		}
+		if got := stringArray(mt.GetStartedEvent().Command.Lookup("update", "$set", "tags")); !slices.Equal(got, []string{"d"}) {
+			mt.Errorf("retry set tags to %v, want the change reapplied to the new tags", got)
+		}
+	})
+}
@@ -0,0 +1,66 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// transactor runs multi-document writes in a transaction when the deployment supports
+// them. Only replica sets and sharded clusters do; on a standalone mongod, like a local
+// development server, the writes run one after another without a transaction, so a
+// crash halfway through can leave part of them applied.
+type transactor struct {
+	client *mongo.Client
+
+	once      sync.Once
+	supported bool
+	err       error
+}
+
+func newTransactor(client *mongo.Client) *transactor {
+	return &transactor{client: client}
+}
+
+// run calls fn inside a transaction, or directly when transactions aren't supported.
+// fn must do all of its reads and writes with the context it is given.
+func (t *transactor) run(fn func(ctx context.Context) error) error {
+	supported, err := t.transactionsSupported()
+	if err != nil {
+		return err
+	}
+	if !supported {
+		return fn(context.Background())
+	}
+
+	session, err := t.client.StartSession()
+	if err != nil {
+		return fmt.Errorf("failed to start session: %v", err)
+	}
+	defer session.EndSession(context.Background())
+
+	_, err = session.WithTransaction(context.Background(), func(ctx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(ctx)
+	})
+	return err
+}
+
+// transactionsSupported asks the server once whether it is part of a replica set or a mongos
+func (t *transactor) transactionsSupported() (bool, error) {
+	t.once.Do(func() {
+		var hello struct {
+			SetName string `bson:"setName"`
+			Msg     string `bson:"msg"`
+		}
+		err := t.client.Database("admin").RunCommand(context.Background(), bson.D{{Key: "hello", Value: 1}}).Decode(&hello)
+		if err != nil {
+			t.err = fmt.Errorf("failed to check for transaction support: %v", err)
+			return
+		}
+		t.supported = hello.SetName != "" || hello.Msg == "isdbgrid"
+	})
+	return t.supported, t.err
+}
@@ -0,0 +1,171 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// inTrash matches the user's notes that were deleted but not yet purged
+func inTrash(userID primitive.ObjectID) bson.M {
+	return bson.M{"user_id": userID, "deleted_at": bson.M{"$ne": nil}}
+}
+
+// GetTrash returns a page of the user's deleted notes, most recently deleted first
+func (m *NoteModel) GetTrash(userID primitive.ObjectID, page, limit int) ([]Note, error) {
+	var notes []Note
+
+	cursor, err := m.collection.Find(
+		context.Background(),
+		inTrash(userID),
+		options.Find().
+			SetSort(bson.D{{Key: "deleted_at", Value: -1}, {Key: "_id", Value: -1}}).
+			SetSkip(int64((page-1)*limit)).
+			SetLimit(int64(limit)),
+	)
+	if err != nil {
+		return []Note{}, fmt.Errorf("failed to fetch trash: %v", err)
+	}
+	defer cursor.Close(context.Background())
+
+	if err := cursor.All(context.Background(), &notes); err != nil {
+		return []Note{}, fmt.Errorf("failed to decode notes: %v", err)
+	}
+
+	if notes == nil {
+		return []Note{}, nil
+	}
+
+	return notes, nil
+}
+
+// Restore takes a note out of the trash
+func (m *NoteModel) Restore(id, userID primitive.ObjectID) (*Note, error) {
+	filter := inTrash(userID)
+	filter["_id"] = id
+
+	result, err := m.collection.UpdateOne(context.Background(), filter, bson.M{"$set": bson.M{"updated_at": time.Now()}, "$unset": bson.M{"deleted_at": ""}, "$inc": bson.M{"version": 1}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore note: %v", err)
+	}
+
+	if result.MatchedCount == 0 {
+		return nil, ErrNoteNotInTrash
+	}
+
+	return m.GetByID(id, userID)
+}
+
+// DeleteFromTrash permanently deletes a single note in the trash. The revisions go
+// first, so a failure leaves the note in the trash to be deleted again rather than
+// revisions pointing at a note that no longer exists.
+func (m *NoteModel) DeleteFromTrash(id, userID primitive.ObjectID) error {
+	filter := inTrash(userID)
+	filter["_id"] = id
+
+	ids, err := m.noteIDs(filter, 1)
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return ErrNoteNotInTrash
+	}
+
+	if err := m.deleteRevisions(ids); err != nil {
+		return err
+	}
+
+	result, err := m.collection.DeleteOne(context.Background(), filter)
+	if err != nil {
+		return fmt.Errorf("failed to delete note: %v", err)
+	}
+
+	if result.DeletedCount == 0 {
+		return ErrNoteNotInTrash
+	}
+
+	return nil
+}
+
+// EmptyTrash permanently deletes all of the user's notes in the trash and returns how many there were
+func (m *NoteModel) EmptyTrash(userID primitive.ObjectID) (int64, error) {
+	return m.deleteForGood(inTrash(userID))
+}
+
+// PurgeTrash permanently deletes every note, of all users, that went to the trash before the given time
+func (m *NoteModel) PurgeTrash(before time.Time) (int64, error) {
+	return m.deleteForGood(bson.M{"deleted_at": bson.M{"$lt": before}})
+}
+
+// purgeBatchSize bounds how many notes deleteForGood removes per round trip
+const purgeBatchSize = 500
+
+// deleteForGood deletes the matching notes along with their revisions, a batch at a
+// time. The filter is applied again on delete so a note restored in the meantime
+// stays, and only the revisions of notes that are really gone are removed.
+func (m *NoteModel) deleteForGood(filter bson.M) (int64, error) {
+	var deleted int64
+	for {
+		ids, err := m.noteIDs(filter, purgeBatchSize)
+		if err != nil {
+			return deleted, err
+		}
+		if len(ids) == 0 {
+			return deleted, nil
+		}
+
+		result, err := m.collection.DeleteMany(context.Background(), bson.M{"$and": bson.A{filter, bson.M{"_id": bson.M{"$in": ids}}}})
+		if err != nil {
+			return deleted, fmt.Errorf("failed to delete notes: %v", err)
+		}
+		deleted += result.DeletedCount
+
+		remaining, err := m.noteIDs(bson.M{"_id": bson.M{"$in": ids}}, 0)
+		if err != nil {
+			return deleted, err
+		}
+		kept := make(map[primitive.ObjectID]bool, len(remaining))
+		for _, id := range remaining {
+			kept[id] = true
+		}
+		removed := []primitive.ObjectID{}
+		for _, id := range ids {
+			if !kept[id] {
+				removed = append(removed, id)
+			}
+		}
+		if len(removed) > 0 {
+			if err := m.deleteRevisions(removed); err != nil {
+				return deleted, err
+			}
+		}
+
+		if len(ids) < purgeBatchSize {
+			return deleted, nil
+		}
+	}
+}
+
+// noteIDs returns the IDs of up to limit notes matching filter, 0 meaning no limit
+func (m *NoteModel) noteIDs(filter bson.M, limit int64) ([]primitive.ObjectID, error) {
+	cursor, err := m.collection.Find(context.Background(), filter, options.Find().SetProjection(bson.M{"_id": 1}).SetLimit(limit))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch trash: %v", err)
+	}
+	defer cursor.Close(context.Background())
+
+	var notes []Note
+	if err := cursor.All(context.Background(), &notes); err != nil {
+		return nil, fmt.Errorf("failed to decode notes: %v", err)
+	}
+
+	ids := make([]primitive.ObjectID, len(notes))
+	for i, note := range notes {
+		ids[i] = note.ID
+	}
+	return ids, nil
+}
@@ -0,0 +1,153 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/suraj/GoGoNotes/internal/testutil"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// inTrashResponse is the reply to noteIDs finding the given notes
+func inTrashResponse(ids ...primitive.ObjectID) bson.D {
+	docs := make([]bson.D, len(ids))
+	for i, id := range ids {
+		docs[i] = bson.D{{Key: "_id", Value: id}}
+	}
+	return mtest.CreateCursorResponse(0, "test.notes", mtest.FirstBatch, docs...)
+}
+
+func TestDeleteMovesToTrash(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("soft delete", func(mt *mtest.T) {
+		m := NewNoteModel(mt.Coll, mt.Coll, mt.Coll, 0)
+		mt.AddMockResponses(testutil.UpdateResponse(1))
+
+		if err := m.Delete(primitive.NewObjectID(), primitive.NewObjectID(), nil); err != nil {
+			mt.Fatal(err)
+		}
+
+		update := mt.GetStartedEvent().Command
+		if update.Lookup("updates", "0", "q", "deleted_at").Type != bson.TypeNull {
+			mt.Errorf("only notes outside the trash may be deleted, got %s", update)
+		}
+		if update.Lookup("updates", "0", "u", "$set", "deleted_at").Type != bson.TypeDateTime {
+			mt.Errorf("the note must be kept with deleted_at set, got %s", update)
+		}
+		if update.Lookup("updates", "0", "u", "$set", "updated_at").Type != bson.TypeDateTime {
+			mt.Errorf("sync clients must see the deletion as a change, got %s", update)
+		}
+	})
+}
+
+func TestPurgeTrash(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("restored in the meantime", func(mt *mtest.T) {
+		m := NewNoteModel(mt.Coll, mt.Coll, mt.Coll, 0)
+		purged, restored := primitive.NewObjectID(), primitive.NewObjectID()
+		mt.AddMockResponses(inTrashResponse(purged, restored), testutil.DeleteResponse(1), inTrashResponse(restored), testutil.DeleteResponse(4))
+
+		deleted, err := m.PurgeTrash(time.Now().Add(-30 * 24 * time.Hour))
+		if err != nil || deleted != 1 {
+			mt.Fatalf("PurgeTrash = %d, %v", deleted, err)
+		}
+
+		if mt.GetStartedEvent().Command.Lookup("filter", "deleted_at", "$lt").Type != bson.TypeDateTime {
+			mt.Error("only notes deleted before the retention period may be purged")
+		}
+		notes := mt.GetStartedEvent().Command
+		if notes.Lookup("deletes", "0", "q", "$and", "0", "deleted_at").IsZero() {
+			mt.Errorf("the purge filter must be applied again on delete, got %s", notes)
+		}
+		mt.GetStartedEvent()
+		revisions, _ := mt.GetStartedEvent().Command.Lookup("deletes", "0", "q", "note_id", "$in").Array().Values()
+		if len(revisions) != 1 || revisions[0].ObjectID() != purged {
+			mt.Errorf("only the purged note's revisions may be deleted, got %v", revisions)
+		}
+	})
+}
+
+func TestDeleteFromTrash(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	noteID := primitive.NewObjectID()
+	userID := primitive.NewObjectID()
+
+	mt.Run("revisions go before the note", func(mt *mtest.T) {
+		m := NewNoteModel(mt.Coll, mt.Coll, mt.Coll, 0)
+		mt.AddMockResponses(inTrashResponse(noteID), testutil.DeleteResponse(3), testutil.DeleteResponse(1))
+
+		if err := m.DeleteFromTrash(noteID, userID); err != nil {
+			mt.Fatal(err)
+		}
+
+		mt.GetStartedEvent()
+		revisions := mt.GetStartedEvent()
+		if revisions.CommandName != "delete" || revisions.Command.Lookup("deletes", "0", "q", "note_id", "$in", "0").ObjectID() != noteID {
+			mt.Errorf("expected the revisions to be deleted first, got %s", revisions.Command)
+		}
+		note := mt.GetStartedEvent()
+		if note.Command.Lookup("deletes", "0", "q", "_id").ObjectID() != noteID || note.Command.Lookup("deletes", "0", "q", "deleted_at").IsZero() {
+			mt.Errorf("note must only be deleted while in the trash, got %s", note.Command)
+		}
+	})
+
+	mt.Run("not in the trash", func(mt *mtest.T) {
+		m := NewNoteModel(mt.Coll, mt.Coll, mt.Coll, 0)
+		mt.AddMockResponses(inTrashResponse())
+
+		if err := m.DeleteFromTrash(noteID, userID); err != ErrNoteNotInTrash {
+			mt.Fatalf("DeleteFromTrash = %v, want %v", err, ErrNoteNotInTrash)
+		}
+		mt.GetStartedEvent()
+		if event := mt.GetStartedEvent(); event != nil {
+			mt.Errorf("nothing should be deleted, got %s", event.CommandName)
+		}
+	})
+
+	mt.Run("failed revision cleanup keeps the note", func(mt *mtest.T) {
+		m := NewNoteModel(mt.Coll, mt.Coll, mt.Coll, 0)
+		mt.AddMockResponses(inTrashResponse(noteID), mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 91, Message: "shutting down"}))
+
+		err := m.DeleteFromTrash(noteID, userID)
+		if err == nil || err == ErrNoteNotInTrash {
+			mt.Fatalf("DeleteFromTrash = %v, want the database error", err)
+		}
+		mt.GetStartedEvent()
+		mt.GetStartedEvent()
+		if event := mt.GetStartedEvent(); event != nil {
+			mt.Errorf("the note must stay when its revisions weren't deleted, got %s", event.CommandName)
+		}
+	})
+}
+
+func TestRestoreNotInTrash(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("restored note counts as changed", func(mt *mtest.T) {
+		m := NewNoteModel(mt.Coll, mt.Coll, mt.Coll, 0)
+		noteID, userID := primitive.NewObjectID(), primitive.NewObjectID()
+		mt.AddMockResponses(testutil.UpdateResponse(1), testutil.FindResponse(Note{ID: noteID, UserID: userID}))
+
+		if _, err := m.Restore(noteID, userID); err != nil {
+			mt.Fatal(err)
+		}
+		update := mt.GetStartedEvent().Command
+		if update.Lookup("updates", "0", "u", "$set", "updated_at").Type != bson.TypeDateTime {
+			mt.Errorf("sync clients must see the restore as a change, got %s", update)
+		}
+	})
+
+	mt.Run("restore", func(mt *mtest.T) {
+		m := NewNoteModel(mt.Coll, mt.Coll, mt.Coll, 0)
+		mt.AddMockResponses(testutil.UpdateResponse(0))
+
+		if _, err := m.Restore(primitive.NewObjectID(), primitive.NewObjectID()); err != ErrNoteNotInTrash {
+			mt.Errorf("Restore = %v, want %v", err, ErrNoteNotInTrash)
+		}
+	})
+}
@@ -3,21 +3,75 @@ package models
 import (
 	"context"
 	"errors"
+	"fmt"
+	"regexp"
+	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type User struct {
-	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
-	Email     string             `bson:"email" json:"email"`
-	Password  string             `bson:"passsword" json:"-"`
-	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	ID              primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Email           string             `bson:"email" json:"email"`
+	Password        string             `bson:"passsword" json:"-"`
+	EmailVerified   bool               `bson:"email_verified" json:"email_verified"`
+	EmailVerifiedAt *time.Time         `bson:"email_verified_at,omitempty" json:"email_verified_at,omitempty"`
+	TokenGeneration int                `bson:"token_generation" json:"-"` // bumped to invalidate every token issued so far
+	TOTPSecret      string             `bson:"totp_secret,omitempty" json:"-"`
+	TOTPEnabled     bool               `bson:"totp_enabled" json:"totp_enabled"`
+	TOTPLastStep    int64              `bson:"totp_last_step,omitempty" json:"-"` // last accepted time step, to stop codes being replayed
+	RecoveryCodes   []string           `bson:"recovery_codes,omitempty" json:"-"` // hashed, each can be used once
+	Roles           []string           `bson:"roles,omitempty" json:"roles"`
+	Disabled        bool               `bson:"disabled" json:"disabled"`
+	DisabledAt      *time.Time         `bson:"disabled_at,omitempty" json:"disabled_at,omitempty"`
+	LastLoginAt     *time.Time         `bson:"last_login_at,omitempty" json:"last_login_at,omitempty"`
+	CreatedAt       time.Time          `bson:"created_at" json:"created_at"`
 }
 
+// Roles a user can have. Every user has RoleUser, RoleAdmin grants access to the /admin routes.
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+var AllRoles = []string{RoleUser, RoleAdmin}
+
+// EffectiveRoles returns the user's roles, treating accounts created before roles existed as plain users
+func (u *User) EffectiveRoles() []string {
+	if len(u.Roles) == 0 {
+		return []string{RoleUser}
+	}
+	return u.Roles
+}
+
+func (u *User) HasRole(role string) bool {
+	for _, r := range u.EffectiveRoles() {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// recoveryCodeCount is how many recovery codes are issued when TOTP is enabled
+const recoveryCodeCount = 10
+
+var (
+	ErrUserExists      = errors.New("user already exists")
+	ErrEmailInUse      = errors.New("email already in use")
+	ErrAccountDisabled = errors.New("account is disabled")
+	ErrUserNotFound    = errors.New("user not found")
+)
+
+// emailCollation compares emails case-insensitively, so accounts created before
+// emails were lowercased still collide with and can be found by their lowercase form
+var emailCollation = &options.Collation{Locale: "en", Strength: 2}
+
 type UserModel struct {
 	collection *mongo.Collection
 }
@@ -26,14 +80,58 @@ func NewUserModel(collection *mongo.Collection) *UserModel {
 	return &UserModel{collection: collection}
 }
 
-func (m *UserModel) Create(email, password string) (*User, error) {
-	// check if user exists
-	var existingUser User
-	err := m.collection.FindOne(context.Background(), bson.M{"email": email}).Decode(&existingUser)
-	if err == nil {
-		return nil, errors.New("user already exists")
+// EnsureIndexes creates the unique email index that backs the duplicate account check
+// and the index the admin user listing is sorted by
+func (m *UserModel) EnsureIndexes() error {
+	_, err := m.collection.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "email", Value: 1}}, Options: options.Index().SetUnique(true).SetCollation(emailCollation)},
+		{Keys: bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}},
+	})
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return m.duplicateEmailsError()
+		}
+		return fmt.Errorf("failed to create user indexes: %v", err)
 	}
+	return nil
+}
 
+// duplicateEmailsError explains why the unique email index can't be built. Emails used
+// to be case sensitive, so older databases can hold accounts whose emails only differ
+// in case; an operator has to merge or rename them before the server can start.
+func (m *UserModel) duplicateEmailsError() error {
+	cursor, err := m.collection.Aggregate(context.Background(), mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{
+			"_id":    "$email",
+			"emails": bson.M{"$push": bson.M{"$concat": bson.A{"$email", " (", bson.M{"$toString": "$_id"}, ")"}}},
+			"count":  bson.M{"$sum": 1},
+		}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+		{{Key: "$limit", Value: 20}},
+	}, options.Aggregate().SetCollation(emailCollation))
+	if err != nil {
+		return fmt.Errorf("failed to create user indexes: duplicate emails, and failed to list them: %v", err)
+	}
+	defer cursor.Close(context.Background())
+
+	var groups []struct {
+		Emails []string `bson:"emails"`
+	}
+	if err := cursor.All(context.Background(), &groups); err != nil {
+		return fmt.Errorf("failed to create user indexes: duplicate emails, and failed to list them: %v", err)
+	}
+
+	conflicts := make([]string, len(groups))
+	for i, group := range groups {
+		conflicts[i] = strings.Join(group.Emails, ", ")
+	}
+	return fmt.Errorf("failed to create the unique email index, these accounts share an email that only differs in case: [%s]. Merge or rename them and restart",
+		strings.Join(conflicts, "; "))
+}
+
+// Create expects an already validated and normalized email. Uniqueness is
+// enforced by the unique index rather than by looking the email up first.
+func (m *UserModel) Create(email, password string) (*User, error) {
 	// Hash password
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
@@ -44,11 +142,15 @@ func (m *UserModel) Create(email, password string) (*User, error) {
 	user := &User{
 		Email:     email,
 		Password:  string(hashedPassword),
+		Roles:     []string{RoleUser},
 		CreatedAt: time.Now(),
 	}
 
 	result, err := m.collection.InsertOne(context.Background(), user)
 	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrUserExists
+		}
 		return nil, err
 	}
 
@@ -57,9 +159,37 @@ func (m *UserModel) Create(email, password string) (*User, error) {
 
 }
 
+// CreateWithoutPassword creates a user whose email was already verified by an identity
+// provider. They can't sign in with a password until they set one through a password reset.
+func (m *UserModel) CreateWithoutPassword(email string) (*User, error) {
+	now := time.Now()
+	user := &User{
+		Email:           email,
+		EmailVerified:   true,
+		EmailVerifiedAt: &now,
+		Roles:           []string{RoleUser},
+		CreatedAt:       now,
+	}
+
+	result, err := m.collection.InsertOne(context.Background(), user)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrUserExists
+		}
+		return nil, err
+	}
+
+	user.ID = result.InsertedID.(primitive.ObjectID)
+	return user, nil
+}
+
 func (m *UserModel) GetByEmail(email string) (*User, error) {
 	var user User
-	err := m.collection.FindOne(context.Background(), bson.M{"email": email}).Decode(&user)
+	err := m.collection.FindOne(
+		context.Background(),
+		bson.M{"email": email},
+		options.FindOne().SetCollation(emailCollation),
+	).Decode(&user)
 
 	if err != nil {
 		return nil, err
@@ -72,3 +202,330 @@ func (m *UserModel) VerifyPassword(user *User, password string) bool {
 	err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
 	return err == nil
 }
+
+func (m *UserModel) GetByID(id primitive.ObjectID) (*User, error) {
+	var user User
+	err := m.collection.FindOne(context.Background(), bson.M{"_id": id}).Decode(&user)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// IncrementTokenGeneration invalidates every access token issued to the user so far
+func (m *UserModel) IncrementTokenGeneration(id primitive.ObjectID) error {
+	result, err := m.collection.UpdateOne(
+		context.Background(),
+		bson.M{"_id": id},
+		bson.M{"$inc": bson.M{"token_generation": 1}},
+	)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+// UpdatePassword re-hashes and stores the password and retires every token issued so far
+func (m *UserModel) UpdatePassword(id primitive.ObjectID, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	result, err := m.collection.UpdateOne(
+		context.Background(),
+		bson.M{"_id": id},
+		bson.M{
+			"$set": bson.M{"passsword": string(hashedPassword)},
+			"$inc": bson.M{"token_generation": 1},
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+// SetRoles replaces the user's roles. Roles are carried in access tokens, so the token
+// generation is bumped to retire tokens with the old roles; the next refresh picks up the new ones.
+func (m *UserModel) SetRoles(id primitive.ObjectID, roles []string) error {
+	result, err := m.collection.UpdateOne(
+		context.Background(),
+		bson.M{"_id": id},
+		bson.M{
+			"$set": bson.M{"roles": roles},
+			"$inc": bson.M{"token_generation": 1},
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+// GrantRoleByEmail adds a role to the user with the given email, if they exist and don't have it yet.
+// It reports whether the role was added.
+func (m *UserModel) GrantRoleByEmail(email, role string) (bool, error) {
+	user, err := m.GetByEmail(email)
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if user.HasRole(role) {
+		return false, nil
+	}
+
+	if err := m.SetRoles(user.ID, append(user.EffectiveRoles(), role)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// List returns a page of users, newest first, optionally filtered by a case-insensitive
+// substring of their email, together with the total number of matching users
+func (m *UserModel) List(search string, page, limit int) ([]User, int64, error) {
+	filter := bson.M{}
+	if search != "" {
+		filter["email"] = primitive.Regex{Pattern: regexp.QuoteMeta(search), Options: "i"}
+	}
+
+	total, err := m.collection.CountDocuments(context.Background(), filter)
+	if err != nil {
+		return []User{}, 0, fmt.Errorf("failed to count users: %v", err)
+	}
+
+	var users []User
+
+	cursor, err := m.collection.Find(
+		context.Background(),
+		filter,
+		options.Find().
+			SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+			SetSkip(int64((page-1)*limit)).
+			SetLimit(int64(limit)),
+	)
+	if err != nil {
+		return []User{}, 0, fmt.Errorf("failed to fetch users: %v", err)
+	}
+	defer cursor.Close(context.Background())
+
+	if err := cursor.All(context.Background(), &users); err != nil {
+		return []User{}, 0, fmt.Errorf("failed to decode users: %v", err)
+	}
+
+	if users == nil {
+		return []User{}, total, nil
+	}
+
+	return users, total, nil
+}
+
+// RecordLogin remembers when the user last started a session
+func (m *UserModel) RecordLogin(id primitive.ObjectID) error {
+	_, err := m.collection.UpdateOne(
+		context.Background(),
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{"last_login_at": time.Now()}},
+	)
+	return err
+}
+
+// SetDisabled disables or re-enables an account. Disabling also retires every access token issued so far.
+func (m *UserModel) SetDisabled(id primitive.ObjectID, disabled bool) error {
+	update := bson.M{
+		"$set":   bson.M{"disabled": false},
+		"$unset": bson.M{"disabled_at": ""},
+	}
+	if disabled {
+		update = bson.M{
+			"$set": bson.M{"disabled": true, "disabled_at": time.Now()},
+			"$inc": bson.M{"token_generation": 1},
+		}
+	}
+
+	result, err := m.collection.UpdateOne(context.Background(), bson.M{"_id": id}, update)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+// ClearPassword removes the user's password so it can no longer be used to sign in,
+// and retires every token issued so far. A new one can only be set through a reset link.
+func (m *UserModel) ClearPassword(id primitive.ObjectID) error {
+	result, err := m.collection.UpdateOne(
+		context.Background(),
+		bson.M{"_id": id},
+		bson.M{
+			"$unset": bson.M{"passsword": ""},
+			"$inc":   bson.M{"token_generation": 1},
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+func (m *UserModel) MarkEmailVerified(id primitive.ObjectID) error {
+	result, err := m.collection.UpdateOne(
+		context.Background(),
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{"email_verified": true, "email_verified_at": time.Now()}},
+	)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+// UpdateEmail switches the user to a new, already confirmed email address
+func (m *UserModel) UpdateEmail(id primitive.ObjectID, email string) error {
+	result, err := m.collection.UpdateOne(
+		context.Background(),
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{
+			"email":             email,
+			"email_verified":    true,
+			"email_verified_at": time.Now(),
+		}},
+	)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrEmailInUse
+		}
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+// SetPendingTOTPSecret stores a new TOTP secret that only takes effect once EnableTOTP is called
+func (m *UserModel) SetPendingTOTPSecret(id primitive.ObjectID, secret string) error {
+	result, err := m.collection.UpdateOne(
+		context.Background(),
+		bson.M{"_id": id, "totp_enabled": bson.M{"$ne": true}},
+		bson.M{"$set": bson.M{"totp_secret": secret}},
+	)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return errors.New("two-factor authentication is already enabled")
+	}
+
+	return nil
+}
+
+// EnableTOTP turns on TOTP for the pending secret and returns a fresh set of recovery codes.
+// Only hashes of the codes are stored, so this is the only time they can be shown.
+func (m *UserModel) EnableTOTP(id primitive.ObjectID, step int64) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+		hashes[i] = hashToken(code)
+	}
+
+	result, err := m.collection.UpdateOne(
+		context.Background(),
+		bson.M{"_id": id, "totp_secret": bson.M{"$exists": true}},
+		bson.M{"$set": bson.M{
+			"totp_enabled":   true,
+			"totp_last_step": step,
+			"recovery_codes": hashes,
+		}},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if result.MatchedCount == 0 {
+		return nil, errors.New("no pending two-factor enrollment")
+	}
+
+	return codes, nil
+}
+
+func (m *UserModel) DisableTOTP(id primitive.ObjectID) error {
+	_, err := m.collection.UpdateOne(
+		context.Background(),
+		bson.M{"_id": id},
+		bson.M{
+			"$set":   bson.M{"totp_enabled": false},
+			"$unset": bson.M{"totp_secret": "", "totp_last_step": "", "recovery_codes": ""},
+		},
+	)
+	return err
+}
+
+// UseTOTPStep records that the code for this time step was used. It returns false
+// if the step (or a later one) was already used, which means the code is being replayed.
+func (m *UserModel) UseTOTPStep(id primitive.ObjectID, step int64) (bool, error) {
+	result, err := m.collection.UpdateOne(
+		context.Background(),
+		bson.M{"_id": id, "totp_last_step": bson.M{"$lt": step}},
+		bson.M{"$set": bson.M{"totp_last_step": step}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
+
+// UseRecoveryCode consumes a recovery code and reports whether it was valid
+func (m *UserModel) UseRecoveryCode(id primitive.ObjectID, code string) (bool, error) {
+	hash := hashToken(strings.ToLower(strings.TrimSpace(code)))
+	result, err := m.collection.UpdateOne(
+		context.Background(),
+		bson.M{"_id": id, "recovery_codes": hash},
+		bson.M{"$pull": bson.M{"recovery_codes": hash}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
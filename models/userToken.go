@@ -0,0 +1,118 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Purposes of single-use tokens that are emailed to users
+const (
+	TokenPurposePasswordReset     = "password_reset"
+	TokenPurposeEmailVerification = "email_verification"
+	TokenPurposeEmailChange       = "email_change"
+)
+
+var ErrUserTokenInvalid = errors.New("invalid or expired token")
+
+// UserToken is a single-use, time-limited token sent to a user by email.
+// Only the hash of the token is stored.
+type UserToken struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID    primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Purpose   string             `bson:"purpose" json:"purpose"`
+	Email     string             `bson:"email,omitempty" json:"email,omitempty"` // new address for email changes
+	TokenHash string             `bson:"token_hash" json:"-"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	ExpiresAt time.Time          `bson:"expires_at" json:"expires_at"`
+	UsedAt    *time.Time         `bson:"used_at,omitempty" json:"used_at,omitempty"`
+}
+
+type UserTokenModel struct {
+	collection *mongo.Collection
+}
+
+func NewUserTokenModel(collection *mongo.Collection) *UserTokenModel {
+	return &UserTokenModel{collection: collection}
+}
+
+func (m *UserTokenModel) EnsureIndexes() error {
+	_, err := m.collection.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "purpose", Value: 1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create user token indexes: %v", err)
+	}
+	return nil
+}
+
+// Create issues a new token and invalidates any earlier unused token with the same purpose
+func (m *UserTokenModel) Create(userID primitive.ObjectID, purpose string, ttl time.Duration) (string, error) {
+	return m.CreateForEmail(userID, purpose, "", ttl)
+}
+
+// CreateForEmail is like Create but also remembers an email address, e.g. the new address of an email change
+func (m *UserTokenModel) CreateForEmail(userID primitive.ObjectID, purpose, email string, ttl time.Duration) (string, error) {
+	token, err := generateToken(32)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate token: %v", err)
+	}
+
+	_, err = m.collection.DeleteMany(context.Background(), bson.M{
+		"user_id": userID,
+		"purpose": purpose,
+		"used_at": nil,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to invalidate previous tokens: %v", err)
+	}
+
+	now := time.Now()
+	_, err = m.collection.InsertOne(context.Background(), &UserToken{
+		UserID:    userID,
+		Purpose:   purpose,
+		Email:     email,
+		TokenHash: hashToken(token),
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to store token: %v", err)
+	}
+
+	return token, nil
+}
+
+// Consume marks the token as used and returns it. A token can only be consumed once,
+// and only if it was issued for one of the given purposes.
+func (m *UserTokenModel) Consume(token string, purposes ...string) (*UserToken, error) {
+	var userToken UserToken
+	err := m.collection.FindOneAndUpdate(
+		context.Background(),
+		bson.M{
+			"token_hash": hashToken(token),
+			"purpose":    bson.M{"$in": purposes},
+			"used_at":    nil,
+			"expires_at": bson.M{"$gt": time.Now()},
+		},
+		bson.M{"$set": bson.M{"used_at": time.Now()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&userToken)
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrUserTokenInvalid
+		}
+		return nil, fmt.Errorf("failed to consume token: %v", err)
+	}
+
+	return &userToken, nil
+}
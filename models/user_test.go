@@ -0,0 +1,89 @@
+package models
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/suraj/GoGoNotes/internal/testutil"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestEnsureIndexesNamesDuplicateEmails(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("duplicates", func(mt *mtest.T) {
+		m := NewUserModel(mt.Coll)
+		mt.AddMockResponses(
+			mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 11000, Name: "DuplicateKey", Message: "E11000 duplicate key error collection: GoGoNotes.users index: email_1"}),
+			mtest.CreateCursorResponse(0, "test.users", mtest.FirstBatch,
+				bson.D{{Key: "_id", Value: "bob@example.com"}, {Key: "emails", Value: bson.A{"bob@example.com (1)", "Bob@Example.com (2)"}}, {Key: "count", Value: 2}},
+			),
+		)
+
+		err := m.EnsureIndexes()
+		if err == nil || !strings.Contains(err.Error(), "bob@example.com (1), Bob@Example.com (2)") {
+			mt.Fatalf("EnsureIndexes = %v, want the conflicting emails", err)
+		}
+
+		mt.GetStartedEvent()
+		aggregate := mt.GetStartedEvent()
+		if aggregate.CommandName != "aggregate" || aggregate.Command.Lookup("collation", "strength").Int32() != 2 {
+			mt.Errorf("duplicates must be grouped with the index collation, got %s", aggregate.Command)
+		}
+	})
+
+	mt.Run("other errors", func(mt *mtest.T) {
+		m := NewUserModel(mt.Coll)
+		mt.AddMockResponses(mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 13, Name: "Unauthorized", Message: "not authorized"}))
+
+		if err := m.EnsureIndexes(); err == nil || !strings.Contains(err.Error(), "not authorized") {
+			mt.Errorf("EnsureIndexes = %v", err)
+		}
+		mt.GetStartedEvent()
+		if event := mt.GetStartedEvent(); event != nil {
+			mt.Errorf("no duplicate lookup expected, got %s", event.CommandName)
+		}
+	})
+}
+
+func TestGrantRoleByEmail(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	user := User{ID: primitive.NewObjectID(), Email: "bob@example.com"}
+	admin := User{ID: primitive.NewObjectID(), Email: "alice@example.com", Roles: []string{RoleUser, RoleAdmin}}
+
+	mt.Run("not registered yet", func(mt *mtest.T) {
+		mt.AddMockResponses(testutil.FindResponse())
+		if granted, err := NewUserModel(mt.Coll).GrantRoleByEmail("carol@example.com", RoleAdmin); granted || err != nil {
+			mt.Errorf("got %v, %v", granted, err)
+		}
+	})
+
+	mt.Run("already an admin", func(mt *mtest.T) {
+		mt.AddMockResponses(testutil.FindResponse(admin))
+		if granted, err := NewUserModel(mt.Coll).GrantRoleByEmail(admin.Email, RoleAdmin); granted || err != nil {
+			mt.Errorf("got %v, %v", granted, err)
+		}
+		mt.GetStartedEvent()
+		if event := mt.GetStartedEvent(); event != nil {
+			mt.Errorf("roles must not be rewritten, got %s", event.CommandName)
+		}
+	})
+
+	mt.Run("promoted", func(mt *mtest.T) {
+		mt.AddMockResponses(testutil.FindResponse(user), testutil.UpdateResponse(1))
+		if granted, err := NewUserModel(mt.Coll).GrantRoleByEmail("Bob@Example.com", RoleAdmin); !granted || err != nil {
+			mt.Fatalf("got %v, %v", granted, err)
+		}
+
+		if collation := mt.GetStartedEvent().Command.Lookup("collation", "strength"); collation.IsZero() {
+			mt.Error("emails must be matched case-insensitively")
+		}
+		roles, _ := mt.GetStartedEvent().Command.Lookup("updates", "0", "u", "$set", "roles").Array().Values()
+		if len(roles) != 2 || roles[0].StringValue() != RoleUser || roles[1].StringValue() != RoleAdmin {
+			mt.Errorf("roles = %v", roles)
+		}
+	})
+}
@@ -0,0 +1,49 @@
+package oidc
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ProvidersFromEnv reads the comma separated provider names in OIDC_PROVIDERS and,
+// for each name, these variables (with the name upper-cased, e.g. OIDC_SSO_ISSUER):
+//
+//	OIDC_<NAME>_ISSUER         issuer URL, discovery is fetched from <issuer>/.well-known/openid-configuration
+//	OIDC_<NAME>_CLIENT_ID      client ID registered with the provider
+//	OIDC_<NAME>_CLIENT_SECRET  optional, leave unset for public clients
+//	OIDC_<NAME>_REDIRECT_URL   defaults to <appBaseURL>/auth/oidc/<name>/callback
+//	OIDC_<NAME>_SCOPES         defaults to "openid email profile"
+//
+// Any issuer URL is accepted, including plain http on localhost, so a local mock provider can be used in development.
+func ProvidersFromEnv(appBaseURL string) (map[string]*Provider, error) {
+	providers := make(map[string]*Provider)
+
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		issuer := os.Getenv(prefix + "ISSUER")
+		clientID := os.Getenv(prefix + "CLIENT_ID")
+		if issuer == "" || clientID == "" {
+			return nil, fmt.Errorf("%sISSUER and %sCLIENT_ID are required for provider %q", prefix, prefix, name)
+		}
+
+		redirectURL := os.Getenv(prefix + "REDIRECT_URL")
+		if redirectURL == "" {
+			redirectURL = strings.TrimSuffix(appBaseURL, "/") + "/auth/oidc/" + name + "/callback"
+		}
+
+		scopes := strings.Fields(os.Getenv(prefix + "SCOPES"))
+		if len(scopes) == 0 {
+			scopes = []string{"openid", "email", "profile"}
+		}
+
+		providers[name] = NewProvider(name, issuer, clientID, os.Getenv(prefix+"CLIENT_SECRET"), redirectURL, scopes)
+	}
+
+	return providers, nil
+}
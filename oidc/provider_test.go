@@ -0,0 +1,338 @@
+package oidc
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testClientID     = "notes-app"
+	testClientSecret = "s3cret"
+	testVerifier     = "verifier-0123456789-0123456789-0123456789"
+	testNonce        = "nonce-123"
+)
+
+// mockIssuer is a minimal OpenID provider serving discovery, a JWKS with an RSA
+// and an Ed25519 key and a token endpoint that hands out idToken
+type mockIssuer struct {
+	*httptest.Server
+	t       *testing.T
+	rsaKey  *rsa.PrivateKey
+	edKey   ed25519.PrivateKey
+	idToken string
+
+	tokenRequest url.Values
+	tokenAuth    [2]string
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &mockIssuer{t: t, rsaKey: rsaKey, edKey: edKey}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.URL,
+			"authorization_endpoint": m.URL + "/authorize",
+			"token_endpoint":         m.URL + "/token",
+			"jwks_uri":               m.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{
+				{
+					"kty": "RSA", "kid": "rsa", "use": "sig", "alg": "RS256",
+					"n": base64.RawURLEncoding.EncodeToString(rsaKey.N.Bytes()),
+					"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(rsaKey.E)).Bytes()),
+				},
+				{
+					"kty": "OKP", "kid": "ed", "crv": "Ed25519",
+					"x": base64.RawURLEncoding.EncodeToString(edKey.Public().(ed25519.PublicKey)),
+				},
+			},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		m.tokenRequest = r.PostForm
+		m.tokenAuth[0], m.tokenAuth[1], _ = r.BasicAuth()
+
+		w.Header().Set("Content-Type", "application/json")
+		if CodeChallenge(r.PostForm.Get("code_verifier")) != CodeChallenge(testVerifier) {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant", "error_description": "PKCE verification failed"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "at", "token_type": "Bearer", "id_token": m.idToken})
+	})
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+	return m
+}
+
+func (m *mockIssuer) provider() *Provider {
+	return NewProvider("mock", m.URL, testClientID, testClientSecret, "https://notes.example.com/callback", []string{"openid", "email"})
+}
+
+// claims are valid ID token claims for the test client
+func (m *mockIssuer) claims() *Claims {
+	now := time.Now()
+	return &Claims{
+		Email:         "bob@example.com",
+		EmailVerified: true,
+		Nonce:         testNonce,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    m.URL,
+			Subject:   "user-1",
+			Audience:  jwt.ClaimStrings{testClientID},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(5 * time.Minute)),
+		},
+	}
+}
+
+func (m *mockIssuer) sign(method jwt.SigningMethod, kid string, key interface{}, claims *Claims) string {
+	m.t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	return signed
+}
+
+func (m *mockIssuer) signRS256(claims *Claims) string {
+	return m.sign(jwt.SigningMethodRS256, "rsa", m.rsaKey, claims)
+}
+
+func TestAuthCodeURLUsesPKCE(t *testing.T) {
+	issuer := newMockIssuer(t)
+
+	authURL, err := issuer.provider().AuthCodeURL("state-1", testNonce, testVerifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(authURL, issuer.URL+"/authorize?") {
+		t.Errorf("AuthCodeURL = %s, want the discovered authorization endpoint", authURL)
+	}
+
+	query := parsed.Query()
+	want := map[string]string{
+		"response_type":         "code",
+		"client_id":             testClientID,
+		"state":                 "state-1",
+		"nonce":                 testNonce,
+		"code_challenge_method": "S256",
+		"code_challenge":        CodeChallenge(testVerifier),
+		"scope":                 "openid email",
+	}
+	for key, value := range want {
+		if got := query.Get(key); got != value {
+			t.Errorf("%s = %q, want %q", key, got, value)
+		}
+	}
+	if query.Has("code_verifier") {
+		t.Error("the code verifier must never leave the server")
+	}
+}
+
+// RFC 7636 Appendix B
+func TestCodeChallenge(t *testing.T) {
+	if got := CodeChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"); got != "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM" {
+		t.Errorf("CodeChallenge = %s", got)
+	}
+}
+
+func TestExchange(t *testing.T) {
+	issuer := newMockIssuer(t)
+
+	t.Run("sends the code verifier and verifies the ID token", func(t *testing.T) {
+		issuer.idToken = issuer.signRS256(issuer.claims())
+
+		claims, err := issuer.provider().Exchange("code-1", testVerifier, testNonce)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if claims.Subject != "user-1" || claims.Email != "bob@example.com" || !claims.EmailVerified {
+			t.Errorf("claims = %+v", claims)
+		}
+
+		form := issuer.tokenRequest
+		if form.Get("grant_type") != "authorization_code" || form.Get("code") != "code-1" || form.Get("code_verifier") != testVerifier {
+			t.Errorf("token request = %v", form)
+		}
+		if issuer.tokenAuth != [2]string{testClientID, testClientSecret} || form.Has("client_secret") {
+			t.Errorf("confidential client must use client_secret_basic, got %v and %v", issuer.tokenAuth, form)
+		}
+	})
+
+	t.Run("public clients identify themselves in the form", func(t *testing.T) {
+		issuer.idToken = issuer.signRS256(issuer.claims())
+		p := NewProvider("mock", issuer.URL, testClientID, "", "https://notes.example.com/callback", []string{"openid"})
+
+		if _, err := p.Exchange("code-1", testVerifier, testNonce); err != nil {
+			t.Fatal(err)
+		}
+		if issuer.tokenRequest.Get("client_id") != testClientID || issuer.tokenAuth[0] != "" {
+			t.Errorf("token request = %v, basic auth %v", issuer.tokenRequest, issuer.tokenAuth)
+		}
+	})
+
+	t.Run("wrong code verifier", func(t *testing.T) {
+		issuer.idToken = issuer.signRS256(issuer.claims())
+
+		_, err := issuer.provider().Exchange("code-1", "another-verifier", testNonce)
+		if err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+			t.Errorf("Exchange = %v, want the token endpoint error", err)
+		}
+	})
+
+	t.Run("wrong nonce", func(t *testing.T) {
+		issuer.idToken = issuer.signRS256(issuer.claims())
+
+		if _, err := issuer.provider().Exchange("code-1", testVerifier, "another-nonce"); err == nil {
+			t.Error("ID token for another login was accepted")
+		}
+	})
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	issuer := newMockIssuer(t)
+
+	tests := []struct {
+		name  string
+		token func() string
+		valid bool
+	}{
+		{"RS256", func() string { return issuer.signRS256(issuer.claims()) }, true},
+		{"EdDSA", func() string { return issuer.sign(jwt.SigningMethodEdDSA, "ed", issuer.edKey, issuer.claims()) }, true},
+		{"wrong issuer", func() string {
+			c := issuer.claims()
+			c.Issuer = issuer.URL + "/other"
+			return issuer.signRS256(c)
+		}, false},
+		{"wrong audience", func() string {
+			c := issuer.claims()
+			c.Audience = jwt.ClaimStrings{"another-app"}
+			return issuer.signRS256(c)
+		}, false},
+		{"several audiences without azp", func() string {
+			c := issuer.claims()
+			c.Audience = jwt.ClaimStrings{testClientID, "another-app"}
+			return issuer.signRS256(c)
+		}, false},
+		{"several audiences with another azp", func() string {
+			c := issuer.claims()
+			c.Audience = jwt.ClaimStrings{testClientID, "another-app"}
+			c.AuthorizedParty = "another-app"
+			return issuer.signRS256(c)
+		}, false},
+		{"several audiences with our azp", func() string {
+			c := issuer.claims()
+			c.Audience = jwt.ClaimStrings{testClientID, "another-app"}
+			c.AuthorizedParty = testClientID
+			return issuer.signRS256(c)
+		}, true},
+		{"single audience with another azp", func() string {
+			c := issuer.claims()
+			c.AuthorizedParty = "another-app"
+			return issuer.signRS256(c)
+		}, false},
+		{"expired", func() string {
+			c := issuer.claims()
+			c.IssuedAt = jwt.NewNumericDate(time.Now().Add(-time.Hour))
+			c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-10 * time.Minute))
+			return issuer.signRS256(c)
+		}, false},
+		{"expired within the leeway", func() string {
+			c := issuer.claims()
+			c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-10 * time.Second))
+			return issuer.signRS256(c)
+		}, true},
+		{"no expiry", func() string {
+			c := issuer.claims()
+			c.ExpiresAt = nil
+			return issuer.signRS256(c)
+		}, false},
+		{"issued in the future", func() string {
+			c := issuer.claims()
+			c.IssuedAt = jwt.NewNumericDate(time.Now().Add(time.Hour))
+			return issuer.signRS256(c)
+		}, false},
+		{"missing subject", func() string {
+			c := issuer.claims()
+			c.Subject = ""
+			return issuer.signRS256(c)
+		}, false},
+		{"missing nonce", func() string {
+			c := issuer.claims()
+			c.Nonce = ""
+			return issuer.signRS256(c)
+		}, false},
+		{"unknown kid", func() string { return issuer.sign(jwt.SigningMethodRS256, "rolled", issuer.rsaKey, issuer.claims()) }, false},
+		{"key used with another algorithm", func() string { return issuer.sign(jwt.SigningMethodPS256, "rsa", issuer.rsaKey, issuer.claims()) }, false},
+		{"signed by someone else", func() string {
+			other, err := rsa.GenerateKey(rand.Reader, 2048)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return issuer.sign(jwt.SigningMethodRS256, "rsa", other, issuer.claims())
+		}, false},
+		{"HS256 with the client secret", func() string {
+			return issuer.sign(jwt.SigningMethodHS256, "rsa", []byte(testClientSecret), issuer.claims())
+		}, false},
+		{"alg none", func() string {
+			return issuer.sign(jwt.SigningMethodNone, "", jwt.UnsafeAllowNoneSignatureType, issuer.claims())
+		}, false},
+	}
+
+	p := issuer.provider()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := p.VerifyIDToken(tt.token(), testNonce)
+			if tt.valid && err != nil {
+				t.Errorf("VerifyIDToken = %v, want a valid token", err)
+			}
+			if !tt.valid && err == nil {
+				t.Errorf("VerifyIDToken accepted %+v", claims)
+			}
+		})
+	}
+}
+
+func TestDiscoveryRequiresMatchingIssuer(t *testing.T) {
+	issuer := newMockIssuer(t)
+
+	p := NewProvider("mock", issuer.URL+"/", testClientID, testClientSecret, "https://notes.example.com/callback", []string{"openid"})
+	if _, err := p.AuthCodeURL("state", testNonce, testVerifier); err == nil {
+		t.Error("discovery document for another issuer was accepted")
+	}
+}
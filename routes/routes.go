@@ -1,20 +1,116 @@
 package routes
 
 import (
+	"net/http"
+
 	"github.com/gorilla/mux"
 	"github.com/suraj/GoGoNotes/handlers"
+	"github.com/suraj/GoGoNotes/middleware"
+	"github.com/suraj/GoGoNotes/models"
 )
 
+// Handlers bundles every handler that gets a route
+type Handlers struct {
+	Auth                *handlers.AuthHandler
+	JWKS                *handlers.JWKSHandler
+	Password            *handlers.PasswordHandler
+	EmailVerification   *handlers.EmailVerificationHandler
+	Account             *handlers.AccountHandler
+	MFA                 *handlers.MFAHandler
+	OIDC                *handlers.OIDCHandler
+	Session             *handlers.SessionHandler
+	PersonalAccessToken *handlers.PersonalAccessTokenHandler
+	Admin               *handlers.AdminHandler
+	Note                *handlers.NoteHandler
+	Tag                 *handlers.TagHandler
+	Notebook            *handlers.NotebookHandler
+	Trash               *handlers.TrashHandler
+	Revision            *handlers.RevisionHandler
+}
+
 // setup configures all the routes for the application
-func Setup(r *mux.Router, authHandler *handlers.AuthHandler, noteHandler *handlers.NoteHandler) {
+func Setup(r *mux.Router, authMiddleware *middleware.AuthMiddleware, h *Handlers) {
 	//Auth routes
-	r.HandleFunc("/register", authHandler.Register).Methods("POST")
-	r.HandleFunc("/login", authHandler.Login).Methods("POST")
-	r.HandleFunc("/logout", authHandler.Logout).Methods("POST")
-
-	r.HandleFunc("/notes", noteHandler.GetAllNotes).Methods("GET")
-	r.HandleFunc("/notes", noteHandler.CreateNote).Methods("POST")
-	r.HandleFunc("/notes/{id}", noteHandler.GetNote).Methods("GET")
-	r.HandleFunc("/notes/{id}", noteHandler.UpdateNote).Methods("PUT")
-	r.HandleFunc("/notes/{id}", noteHandler.DeleteNote).Methods("DELETE")
+	r.HandleFunc("/register", h.Auth.Register).Methods("POST")
+	r.HandleFunc("/login", h.Auth.Login).Methods("POST")
+	r.HandleFunc("/login/mfa", h.MFA.VerifyLogin).Methods("POST")
+	r.HandleFunc("/auth/oidc/providers", h.OIDC.GetProviders).Methods("GET")
+	r.HandleFunc("/auth/oidc/{provider}", h.OIDC.Login).Methods("GET")
+	r.HandleFunc("/auth/oidc/{provider}/callback", h.OIDC.Callback).Methods("GET")
+	r.HandleFunc("/token/refresh", h.Auth.Refresh).Methods("POST")
+	r.HandleFunc("/.well-known/jwks.json", h.JWKS.GetJWKS).Methods("GET")
+	r.HandleFunc("/password/forgot", h.Password.ForgotPassword).Methods("POST")
+	r.HandleFunc("/password/reset", h.Password.ResetPassword).Methods("POST")
+	r.HandleFunc("/verify-email", h.EmailVerification.VerifyEmail).Methods("GET", "POST")
+
+	// Everything below requires a valid access token or personal access token
+	protected := r.NewRoute().Subrouter()
+	protected.Use(authMiddleware.Authenticate)
+
+	// Account and security settings need a real login, personal access tokens can't touch them
+	session := protected.NewRoute().Subrouter()
+	session.Use(authMiddleware.RequireSession)
+
+	session.HandleFunc("/logout", h.Auth.Logout).Methods("POST")
+	session.HandleFunc("/logout/all", h.Auth.LogoutAll).Methods("POST")
+	session.HandleFunc("/sessions", h.Session.GetAllSessions).Methods("GET")
+	session.HandleFunc("/sessions/{id}", h.Session.RevokeSession).Methods("DELETE")
+	session.HandleFunc("/verify-email/resend", h.EmailVerification.ResendVerification).Methods("POST")
+
+	session.HandleFunc("/account/password", h.Account.ChangePassword).Methods("PUT")
+	session.HandleFunc("/account/email", h.Account.ChangeEmail).Methods("PUT")
+	session.HandleFunc("/account", h.Account.DeleteAccount).Methods("DELETE")
+
+	session.HandleFunc("/mfa/totp/enroll", h.MFA.EnrollTOTP).Methods("POST")
+	session.HandleFunc("/mfa/totp/confirm", h.MFA.ConfirmTOTP).Methods("POST")
+	session.HandleFunc("/mfa/totp", h.MFA.DisableTOTP).Methods("DELETE")
+
+	session.HandleFunc("/tokens", h.PersonalAccessToken.GetAllTokens).Methods("GET")
+	session.HandleFunc("/tokens", h.PersonalAccessToken.CreateToken).Methods("POST")
+	session.HandleFunc("/tokens/{id}", h.PersonalAccessToken.RevokeToken).Methods("DELETE")
+
+	// Operator endpoints, only for admins signed in with a real session
+	admin := session.PathPrefix("/admin").Subrouter()
+	admin.Use(authMiddleware.RequireRole(models.RoleAdmin))
+
+	admin.HandleFunc("/users", h.Admin.ListUsers).Methods("GET")
+	admin.HandleFunc("/users/{id}", h.Admin.GetUser).Methods("GET")
+	admin.HandleFunc("/users/{id}", h.Admin.DeleteUser).Methods("DELETE")
+	admin.HandleFunc("/users/{id}/roles", h.Admin.SetUserRoles).Methods("PUT")
+	admin.HandleFunc("/users/{id}/disable", h.Admin.DisableUser).Methods("POST")
+	admin.HandleFunc("/users/{id}/enable", h.Admin.EnableUser).Methods("POST")
+	admin.HandleFunc("/users/{id}/password-reset", h.Admin.ForcePasswordReset).Methods("POST")
+
+	// Note routes, personal access tokens need the matching scope
+	notesRead := authMiddleware.RequireScope(models.ScopeNotesRead)
+	notesWrite := authMiddleware.RequireScope(models.ScopeNotesWrite)
+
+	protected.Handle("/notes", notesRead(http.HandlerFunc(h.Note.GetAllNotes))).Methods("GET")
+	protected.Handle("/notes", notesWrite(authMiddleware.RequireVerifiedEmail(http.HandlerFunc(h.Note.CreateNote)))).Methods("POST")
+	protected.Handle("/notes/search", notesRead(http.HandlerFunc(h.Note.SearchNotes))).Methods("GET")
+	protected.Handle("/notes/{id}", notesRead(http.HandlerFunc(h.Note.GetNote))).Methods("GET")
+	protected.Handle("/notes/{id}", notesWrite(http.HandlerFunc(h.Note.UpdateNote))).Methods("PUT")
+	protected.Handle("/notes/{id}", notesWrite(http.HandlerFunc(h.Note.PatchNote))).Methods("PATCH")
+	protected.Handle("/notes/{id}", notesWrite(http.HandlerFunc(h.Note.DeleteNote))).Methods("DELETE")
+	protected.Handle("/notes/{id}/notebook", notesWrite(http.HandlerFunc(h.Note.MoveNote))).Methods("PUT")
+	protected.Handle("/notes/{id}/restore", notesWrite(http.HandlerFunc(h.Trash.RestoreNote))).Methods("POST")
+	protected.Handle("/notes/{id}/revisions", notesRead(http.HandlerFunc(h.Revision.GetAllRevisions))).Methods("GET")
+	protected.Handle("/notes/{id}/revisions/diff", notesRead(http.HandlerFunc(h.Revision.DiffRevisions))).Methods("GET")
+	protected.Handle("/notes/{id}/revisions/{number}", notesRead(http.HandlerFunc(h.Revision.GetRevision))).Methods("GET")
+	protected.Handle("/notes/{id}/revisions/{number}/restore", notesWrite(http.HandlerFunc(h.Revision.RestoreRevision))).Methods("POST")
+
+	protected.Handle("/trash", notesRead(http.HandlerFunc(h.Trash.GetTrash))).Methods("GET")
+	protected.Handle("/trash", notesWrite(http.HandlerFunc(h.Trash.EmptyTrash))).Methods("DELETE")
+	protected.Handle("/trash/{id}", notesWrite(http.HandlerFunc(h.Trash.DeleteNote))).Methods("DELETE")
+
+	protected.Handle("/notebooks", notesRead(http.HandlerFunc(h.Notebook.GetAllNotebooks))).Methods("GET")
+	protected.Handle("/notebooks", notesWrite(http.HandlerFunc(h.Notebook.CreateNotebook))).Methods("POST")
+	protected.Handle("/notebooks/{id}", notesRead(http.HandlerFunc(h.Notebook.GetNotebook))).Methods("GET")
+	protected.Handle("/notebooks/{id}", notesWrite(http.HandlerFunc(h.Notebook.UpdateNotebook))).Methods("PUT")
+	protected.Handle("/notebooks/{id}", notesWrite(http.HandlerFunc(h.Notebook.DeleteNotebook))).Methods("DELETE")
+
+	protected.Handle("/tags", notesRead(http.HandlerFunc(h.Tag.GetAllTags))).Methods("GET")
+	protected.Handle("/tags/merge", notesWrite(http.HandlerFunc(h.Tag.MergeTags))).Methods("POST")
+	protected.Handle("/tags/{tag}", notesWrite(http.HandlerFunc(h.Tag.RenameTag))).Methods("PUT")
+	protected.Handle("/tags/{tag}", notesWrite(http.HandlerFunc(h.Tag.DeleteTag))).Methods("DELETE")
 }
@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestDiffLines(t *testing.T) {
+	eq := func(s string) DiffLine { return DiffLine{Op: DiffEqual, Text: s} }
+	ins := func(s string) DiffLine { return DiffLine{Op: DiffInsert, Text: s} }
+	del := func(s string) DiffLine { return DiffLine{Op: DiffDelete, Text: s} }
+
+	tests := []struct {
+		name string
+		a, b string
+		want []DiffLine
+	}{
+		{"both empty", "", "", []DiffLine{}},
+		{"identical", "a\nb\n", "a\nb", []DiffLine{eq("a"), eq("b")}},
+		{"from nothing", "", "a\nb", []DiffLine{ins("a"), ins("b")}},
+		{"to nothing", "a\nb", "", []DiffLine{del("a"), del("b")}},
+		{"line changed", "a\nb\nc", "a\nx\nc", []DiffLine{eq("a"), del("b"), ins("x"), eq("c")}},
+		{"line inserted", "a\nc", "a\nb\nc", []DiffLine{eq("a"), ins("b"), eq("c")}},
+		{"line removed", "a\nb\nc", "a\nc", []DiffLine{eq("a"), del("b"), eq("c")}},
+		{"lines moved", "a\nb\nc\nd", "c\nd\na\nb", []DiffLine{del("a"), del("b"), eq("c"), eq("d"), ins("a"), ins("b")}},
+		{"windows line endings", "a\r\nb\r\n", "a\nb", []DiffLine{eq("a"), eq("b")}},
+		{"blank lines", "a\n\nb", "a\nb", []DiffLine{eq("a"), del(""), eq("b")}},
+	}
+
+	for _, tt := range tests {
+		if got := DiffLines(tt.a, tt.b); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDiffLinesTooLargeIsAReplacement(t *testing.T) {
+	var a, b []string
+	for i := 0; i < 600; i++ {
+		a = append(a, "old "+strconv.Itoa(i))
+		b = append(b, "new "+strconv.Itoa(i))
+	}
+	// A shared line in the middle would be kept by the LCS but not by the fallback
+	a[300], b[300] = "same", "same"
+
+	diff := DiffLines("head\n"+strings.Join(a, "\n")+"\ntail", "head\n"+strings.Join(b, "\n")+"\ntail")
+	if len(diff) != 2+600+600 || diff[0] != (DiffLine{Op: DiffEqual, Text: "head"}) || diff[len(diff)-1] != (DiffLine{Op: DiffEqual, Text: "tail"}) {
+		t.Fatalf("got %d lines", len(diff))
+	}
+	for i, line := range diff[1 : 1+600] {
+		if line.Op != DiffDelete || line.Text != a[i] {
+			t.Fatalf("line %d: got %v, want the old text deleted", i+1, line)
+		}
+	}
+	for i, line := range diff[1+600 : len(diff)-1] {
+		if line.Op != DiffInsert || line.Text != b[i] {
+			t.Fatalf("line %d: got %v, want the new text inserted", i+601, line)
+		}
+	}
+}
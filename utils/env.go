@@ -0,0 +1,80 @@
+package utils
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// GetEnv reads a string from the environment, falling back to def when the variable is unset
+func GetEnv(key, def string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return def
+}
+
+// GetEnvDuration reads a duration such as "15m" or "720h" from the environment,
+// falling back to def when the variable is unset or malformed
+func GetEnvDuration(key string, def time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		log.Printf("Invalid duration for %s (%q), using default %s", key, value, def)
+		return def
+	}
+	return d
+}
+
+// GetEnvBool reads a boolean such as "true" or "0" from the environment,
+// falling back to def when the variable is unset or malformed
+func GetEnvBool(key string, def bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("Invalid boolean for %s (%q), using default %t", key, value, def)
+		return def
+	}
+	return b
+}
+
+// GetEnvInt reads a positive integer from the environment, falling back to def
+// when the variable is unset or malformed
+func GetEnvInt(key string, def int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		log.Printf("Invalid integer for %s (%q), using default %d", key, value, def)
+		return def
+	}
+	return n
+}
+
+// GetEnvNonNegativeInt is GetEnvInt for settings where 0 has a meaning of its own,
+// such as turning a limit off
+func GetEnvNonNegativeInt(key string, def int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		log.Printf("Invalid integer for %s (%q), using default %d", key, value, def)
+		return def
+	}
+	return n
+}
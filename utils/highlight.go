@@ -0,0 +1,151 @@
+package utils
+
+import (
+	"html"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// snippetContext is how much text is kept before the first match of a snippet, at
+// most a third of the snippet so short snippets still show the match
+const snippetContext = 40
+
+// wordChar is a letter, digit or underscore in any script
+const wordChar = `[\p{L}\p{N}_]`
+
+// SearchTerms extracts what a Mongo $text query would match: quoted phrases and
+// single words. Negated words and phrases ("-draft", -"old idea") are left out
+// since they never appear in results.
+func SearchTerms(query string) []string {
+	var terms []string
+	for len(query) > 0 {
+		query = strings.TrimLeft(query, " \t\r\n")
+		if query == "" {
+			break
+		}
+
+		negated := strings.HasPrefix(query, "-")
+		if negated {
+			query = query[1:]
+		}
+
+		var term string
+		if strings.HasPrefix(query, `"`) {
+			end := strings.Index(query[1:], `"`)
+			if end < 0 {
+				term, query = query[1:], ""
+			} else {
+				term, query = query[1:end+1], query[end+2:]
+			}
+		} else {
+			end := strings.IndexAny(query, " \t\r\n")
+			if end < 0 {
+				end = len(query)
+			}
+			term, query = query[:end], query[end:]
+		}
+
+		term = strings.TrimSpace(term)
+		if !negated && term != "" {
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// Highlight returns an HTML-escaped excerpt of text of at most maxLen characters,
+// starting a little before the first match, with every match wrapped in <mark>.
+// Words also match with a suffix ("note" highlights "notes"), which roughly
+// follows the stemming Mongo applies. A maxLen of 0 keeps the whole text.
+func Highlight(text string, terms []string, maxLen int) string {
+	var matches [][]int
+	if pattern := termPattern(terms); pattern != nil {
+		for _, loc := range pattern.FindAllStringSubmatchIndex(text, -1) {
+			matches = append(matches, loc[2:4])
+		}
+	}
+
+	start, end := 0, len(text)
+	if maxLen > 0 && utf8.RuneCountInString(text) > maxLen {
+		if len(matches) > 0 {
+			start = backRunes(text, matches[0][0], min(snippetContext, maxLen/3))
+		}
+		end = forwardRunes(text, start, maxLen)
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	last := start
+	for _, match := range matches {
+		if match[0] < start {
+			continue
+		}
+		if match[0] >= end {
+			break
+		}
+		matchEnd := min(match[1], end)
+		b.WriteString(html.EscapeString(text[last:match[0]]))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(text[match[0]:matchEnd]))
+		b.WriteString("</mark>")
+		last = matchEnd
+	}
+	b.WriteString(html.EscapeString(text[last:end]))
+	if end < len(text) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
+
+// termPattern matches any of the terms where a word starts. \b only knows ASCII, so
+// the boundary is spelled out with Unicode classes, which also lets terms that begin
+// with punctuation ("#todo") match. The term itself is the first submatch.
+func termPattern(terms []string) *regexp.Regexp {
+	if len(terms) == 0 {
+		return nil
+	}
+
+	// Longest first, so a phrase wins over a word it contains
+	sorted := append([]string(nil), terms...)
+	sort.Slice(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+
+	alternatives := make([]string, len(sorted))
+	for i, term := range sorted {
+		words := strings.Fields(term)
+		for j, word := range words {
+			words[j] = regexp.QuoteMeta(word)
+		}
+		alternatives[i] = strings.Join(words, `\s+`) + wordChar + `*`
+	}
+
+	return regexp.MustCompile(`(?i)(?:^|[^\p{L}\p{N}_])(` + strings.Join(alternatives, "|") + `)`)
+}
+
+// backRunes moves back up to n runes from byte offset i, then forward to the start
+// of the next word so the excerpt doesn't begin mid-word
+func backRunes(s string, i, n int) int {
+	from := i
+	for ; n > 0 && i > 0; n-- {
+		_, size := utf8.DecodeLastRuneInString(s[:i])
+		i -= size
+	}
+	if i > 0 && !strings.ContainsAny(s[i-1:i], " \t\r\n") {
+		if space := strings.IndexAny(s[i:from], " \t\r\n"); space >= 0 {
+			i += space + 1
+		}
+	}
+	return i
+}
+
+// forwardRunes moves forward up to n runes from byte offset i
+func forwardRunes(s string, i, n int) int {
+	for ; n > 0 && i < len(s); n-- {
+		_, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+	}
+	return i
+}
@@ -0,0 +1,89 @@
+package utils
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSearchTerms(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{"words", "meeting  notes", []string{"meeting", "notes"}},
+		{"phrase", `"shopping list" milk`, []string{"shopping list", "milk"}},
+		{"negated word", "recipe -draft", []string{"recipe"}},
+		{"negated phrase", `recipe -"old idea" soup`, []string{"recipe", "soup"}},
+		{"unterminated quote", `todo "call mom`, []string{"todo", "call mom"}},
+		{"empty quotes", `"" todo`, []string{"todo"}},
+		{"lone dash", "- todo", []string{"todo"}},
+		{"punctuation", "#todo c++", []string{"#todo", "c++"}},
+		{"non-ASCII", "café Ünïcode", []string{"café", "Ünïcode"}},
+		{"blank", "  \t ", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SearchTerms(tt.query); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SearchTerms(%q) = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	tests := []struct {
+		name   string
+		text   string
+		terms  []string
+		maxLen int
+		want   string
+	}{
+		{"word", "Meeting notes for today", []string{"notes"}, 0, "Meeting <mark>notes</mark> for today"},
+		{"suffix", "A note and more notes", []string{"note"}, 0, "A <mark>note</mark> and more <mark>notes</mark>"},
+		{"case insensitive", "NOTES", []string{"notes"}, 0, "<mark>NOTES</mark>"},
+		{"word start only", "keynote", []string{"note"}, 0, "keynote"},
+		{"phrase across whitespace", "the shopping\n list", []string{"shopping list"}, 0, "the <mark>shopping\n list</mark>"},
+		{"phrase before word", "shopping list", []string{"list", "shopping list"}, 0, "<mark>shopping list</mark>"},
+		{"no terms", "a < b", nil, 0, "a &lt; b"},
+		{"escapes HTML", `<b>notes</b> & "more"`, []string{"notes"}, 0, "&lt;b&gt;<mark>notes</mark>&lt;/b&gt; &amp; &#34;more&#34;"},
+		{"escapes inside match", "x a&b", []string{"a&b"}, 0, "x <mark>a&amp;b</mark>"},
+		{"hashtag", "call #todo now", []string{"#todo"}, 0, "call <mark>#todo</mark> now"},
+		{"hashtag needs a boundary", "a#todo", []string{"#todo"}, 0, "a#todo"},
+		{"c++", "I like c++ and c", []string{"c++"}, 0, "I like <mark>c++</mark> and c"},
+		{"non-ASCII term", "Ein schönes Café", []string{"café"}, 0, "Ein schönes <mark>Café</mark>"},
+		{"non-ASCII boundary", "éa ça", []string{"a"}, 0, "éa ça"},
+		{"non-ASCII suffix", "Überlegung", []string{"über"}, 0, "<mark>Überlegung</mark>"},
+		{"adjacent matches", "note note", []string{"note"}, 0, "<mark>note</mark> <mark>note</mark>"},
+		{"truncated", "one two three four", nil, 7, "one two…"},
+		{"truncated after match", "aaaa bbbb cccc", []string{"aaaa"}, 6, "<mark>aaaa</mark> b…"},
+		{"match cut by truncation", "xx aaaaaaaa", []string{"aa"}, 5, "…<mark>aaaaa</mark>…"},
+		{"starts before first match", strings.Repeat("word ", 20) + "target end", []string{"target"}, 20, "…word <mark>target</mark> end"},
+		{"lead-in before match", strings.Repeat("word ", 20) + "target end", []string{"target"}, 200, strings.Repeat("word ", 20) + "<mark>target</mark> end"},
+		{"long lead-in", strings.Repeat("word ", 20) + "target " + strings.Repeat("tail ", 20), []string{"target"}, 60, "…word word word word <mark>target</mark> tail tail tail tail tail tail tai…"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Highlight(tt.text, tt.terms, tt.maxLen); got != tt.want {
+				t.Errorf("Highlight(%q, %q, %d) = %q, want %q", tt.text, tt.terms, tt.maxLen, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHighlightTruncatesOnRuneBoundaries(t *testing.T) {
+	text := strings.Repeat("日本語", 10)
+	for maxLen := 1; maxLen < utf8.RuneCountInString(text); maxLen++ {
+		got := Highlight(text, []string{"本"}, maxLen)
+		if !utf8.ValidString(got) {
+			t.Fatalf("Highlight with maxLen %d returned invalid UTF-8: %q", maxLen, got)
+		}
+		if n := utf8.RuneCountInString(strings.Trim(got, "…")); n > maxLen {
+			t.Errorf("Highlight with maxLen %d kept %d runes", maxLen, n)
+		}
+	}
+}
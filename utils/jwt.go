@@ -4,40 +4,84 @@ import (
 	"errors"
 	"net/http"
 	"strings"
+	"time"
 
-	"github.com/golang-jwt/jwt/v4"
+	"github.com/golang-jwt/jwt/v5"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-var JwtSecret = []byte("your-secret-key") // Replace with actual secret key
+// Token types, carried in the typ claim so a token minted for one purpose
+// can't be presented for another
+const (
+	TokenTypeAccess = "access"
+	TokenTypeMFA    = "mfa"
+)
+
+// AccessClaims is the payload of the tokens we sign
+type AccessClaims struct {
+	UserID     string   `json:"user_id"`
+	Type       string   `json:"typ"`
+	Generation int      `json:"gen"`
+	SessionID  string   `json:"sid,omitempty"`
+	Roles      []string `json:"roles,omitempty"`
+	jwt.RegisteredClaims
+}
 
-// ExtractUserIDFromToken extracts user ID from the JWT token in the request
-func ExtractUserIDFromToken(r *http.Request) (primitive.ObjectID, error) {
+// TokenClaims are the claims of a verified token
+type TokenClaims struct {
+	UserID     primitive.ObjectID
+	TokenID    string
+	Generation int
+	SessionID  primitive.ObjectID // zero for tokens that don't belong to a session yet, e.g. MFA challenges
+	Roles      []string
+	ExpiresAt  time.Time
+}
+
+// BearerToken returns the raw token from the Authorization header
+func BearerToken(r *http.Request) (string, error) {
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
-		return primitive.NilObjectID, errors.New("missing Authorization header")
+		return "", errors.New("missing Authorization header")
 	}
+	return strings.TrimPrefix(authHeader, "Bearer "), nil
+}
 
-	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return JwtSecret, nil
-	}, jwt.WithValidMethods([]string{"HS256"}), jwt.WithoutClaimsValidation())
-
+// ParseToken verifies a token of the given type and returns its claims
+func ParseToken(tokenString, tokenType string, keyring *Keyring) (*TokenClaims, error) {
+	var claims AccessClaims
+	token, err := keyring.Parse(tokenString, &claims, jwt.WithExpirationRequired(), jwt.WithIssuedAt())
 	if err != nil || !token.Valid {
-		return primitive.NilObjectID, errors.New("invalid token")
+		return nil, errors.New("invalid or expired token")
+	}
+
+	if claims.Type != tokenType {
+		return nil, errors.New("wrong token type")
 	}
 
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok || claims["user_id"] == nil {
-		return primitive.NilObjectID, errors.New("invalid claims")
+	if claims.ID == "" {
+		return nil, errors.New("token has no id")
 	}
 
-	userIDHex := claims["user_id"].(string)
-	userID, err := primitive.ObjectIDFromHex(userIDHex)
+	userID, err := primitive.ObjectIDFromHex(claims.UserID)
 	if err != nil {
-		return primitive.NilObjectID, errors.New("invalid user ID format")
+		return nil, errors.New("invalid user ID format")
+	}
+
+	var sessionID primitive.ObjectID
+	if claims.SessionID != "" {
+		sessionID, err = primitive.ObjectIDFromHex(claims.SessionID)
+		if err != nil {
+			return nil, errors.New("invalid session ID format")
+		}
 	}
 
-	return userID, nil
+	return &TokenClaims{
+		UserID:     userID,
+		TokenID:    claims.ID,
+		Generation: claims.Generation,
+		SessionID:  sessionID,
+		Roles:      claims.Roles,
+		ExpiresAt:  claims.ExpiresAt.Time,
+	}, nil
 
 }
@@ -0,0 +1,355 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// minSecretLength is the shortest HMAC secret we accept (256 bits for HS256)
+const minSecretLength = 32
+
+// minRSABits is the smallest RSA modulus we accept
+const minRSABits = 2048
+
+// Key is a single JWT key, identified in token headers by its kid.
+// Verification-only keys (e.g. a retired public key) have a nil SignKey.
+type Key struct {
+	ID        string
+	Method    jwt.SigningMethod
+	SignKey   interface{}
+	VerifyKey interface{}
+}
+
+// JWK is the public half of an asymmetric key in RFC 7517 format
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// Keyring holds every key that is currently accepted for verification and the
+// one key that is used to sign new tokens. Rotating a secret means adding the new
+// key, making it the signing key, and removing the old one once the tokens it
+// signed have expired.
+type Keyring struct {
+	signingKey *Key
+	keys       map[string]*Key
+}
+
+func NewKeyring(keys []*Key, signingKeyID string) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("keyring has no keys")
+	}
+
+	keyring := &Keyring{keys: make(map[string]*Key)}
+	for _, key := range keys {
+		if key.ID == "" {
+			return nil, errors.New("every key needs a kid")
+		}
+		if _, exists := keyring.keys[key.ID]; exists {
+			return nil, fmt.Errorf("duplicate kid %q", key.ID)
+		}
+		keyring.keys[key.ID] = key
+	}
+
+	// A single key doesn't need to be named as the signing key
+	if signingKeyID == "" && len(keys) == 1 {
+		signingKeyID = keys[0].ID
+	}
+
+	signingKey, ok := keyring.keys[signingKeyID]
+	if !ok {
+		return nil, fmt.Errorf("signing key %q is not in the keyring", signingKeyID)
+	}
+	if signingKey.SignKey == nil {
+		return nil, fmt.Errorf("signing key %q has no private key", signingKeyID)
+	}
+	keyring.signingKey = signingKey
+
+	return keyring, nil
+}
+
+// NewHMACKey creates an HS256 key from a shared secret
+func NewHMACKey(kid string, secret []byte) (*Key, error) {
+	if len(secret) < minSecretLength {
+		return nil, fmt.Errorf("secret for key %q must be at least %d bytes", kid, minSecretLength)
+	}
+	return &Key{
+		ID:        kid,
+		Method:    jwt.SigningMethodHS256,
+		SignKey:   secret,
+		VerifyKey: secret,
+	}, nil
+}
+
+// NewRSAKey creates an RS256 key. Pass a nil private key for a verification-only key.
+func NewRSAKey(kid string, privateKey *rsa.PrivateKey, publicKey *rsa.PublicKey) (*Key, error) {
+	if privateKey != nil {
+		publicKey = &privateKey.PublicKey
+	}
+	if publicKey == nil {
+		return nil, fmt.Errorf("key %q has no RSA key material", kid)
+	}
+	if publicKey.N.BitLen() < minRSABits {
+		return nil, fmt.Errorf("RSA key %q must be at least %d bits", kid, minRSABits)
+	}
+
+	key := &Key{
+		ID:        kid,
+		Method:    jwt.SigningMethodRS256,
+		VerifyKey: publicKey,
+	}
+	if privateKey != nil {
+		key.SignKey = privateKey
+	}
+	return key, nil
+}
+
+// NewEdDSAKey creates an Ed25519 key. Pass a nil private key for a verification-only key.
+func NewEdDSAKey(kid string, privateKey ed25519.PrivateKey, publicKey ed25519.PublicKey) (*Key, error) {
+	if privateKey != nil {
+		publicKey = privateKey.Public().(ed25519.PublicKey)
+	}
+	if publicKey == nil {
+		return nil, fmt.Errorf("key %q has no Ed25519 key material", kid)
+	}
+
+	key := &Key{
+		ID:        kid,
+		Method:    jwt.SigningMethodEdDSA,
+		VerifyKey: publicKey,
+	}
+	if privateKey != nil {
+		key.SignKey = privateKey
+	}
+	return key, nil
+}
+
+type keyFile struct {
+	SigningKey string         `json:"signing_key"`
+	Keys       []keyFileEntry `json:"keys"`
+}
+
+// keyFileEntry describes one key. HS256 keys carry a secret, RS256 and EdDSA keys
+// point at PEM files; a key with only a public_key_file can verify but not sign.
+type keyFileEntry struct {
+	ID             string `json:"kid"`
+	Alg            string `json:"alg"`
+	Secret         string `json:"secret"`
+	PrivateKeyFile string `json:"private_key_file"`
+	PublicKeyFile  string `json:"public_key_file"`
+}
+
+// LoadKeyring builds the keyring from configuration. In order of precedence:
+//
+//	JWT_KEYS_FILE   path to a JSON file: {"signing_key": "k2", "keys": [{"kid": "k2", "alg": "EdDSA", "private_key_file": "k2.pem"}]}
+//	JWT_KEYS        comma separated kid:secret pairs, with JWT_SIGNING_KEY_ID naming the signing key
+//	JWT_SECRET      a single secret, used with kid "default"
+func LoadKeyring() (*Keyring, error) {
+	if path := os.Getenv("JWT_KEYS_FILE"); path != "" {
+		return loadKeyringFile(path)
+	}
+
+	if value := os.Getenv("JWT_KEYS"); value != "" {
+		var keys []*Key
+		for _, pair := range strings.Split(value, ",") {
+			kid, secret, ok := strings.Cut(strings.TrimSpace(pair), ":")
+			if !ok {
+				return nil, fmt.Errorf("JWT_KEYS entries must look like kid:secret")
+			}
+			key, err := NewHMACKey(kid, []byte(secret))
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, key)
+		}
+		return NewKeyring(keys, os.Getenv("JWT_SIGNING_KEY_ID"))
+	}
+
+	if secret := os.Getenv("JWT_SECRET"); secret != "" {
+		key, err := NewHMACKey("default", []byte(secret))
+		if err != nil {
+			return nil, err
+		}
+		return NewKeyring([]*Key{key}, "default")
+	}
+
+	return nil, errors.New("no JWT keys configured, set JWT_SECRET, JWT_KEYS or JWT_KEYS_FILE")
+}
+
+func loadKeyringFile(path string) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %v", err)
+	}
+
+	var file keyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse key file: %v", err)
+	}
+
+	// PEM paths are relative to the key file
+	dir := filepath.Dir(path)
+
+	var keys []*Key
+	for _, entry := range file.Keys {
+		key, err := loadKeyFileEntry(dir, entry)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return NewKeyring(keys, file.SigningKey)
+}
+
+func loadKeyFileEntry(dir string, entry keyFileEntry) (*Key, error) {
+	switch entry.Alg {
+	case "", jwt.SigningMethodHS256.Alg():
+		return NewHMACKey(entry.ID, []byte(entry.Secret))
+
+	case jwt.SigningMethodRS256.Alg():
+		var privateKey *rsa.PrivateKey
+		var publicKey *rsa.PublicKey
+		if entry.PrivateKeyFile != "" {
+			data, err := readPEM(dir, entry.PrivateKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			if privateKey, err = jwt.ParseRSAPrivateKeyFromPEM(data); err != nil {
+				return nil, fmt.Errorf("key %q: %v", entry.ID, err)
+			}
+		} else if entry.PublicKeyFile != "" {
+			data, err := readPEM(dir, entry.PublicKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			if publicKey, err = jwt.ParseRSAPublicKeyFromPEM(data); err != nil {
+				return nil, fmt.Errorf("key %q: %v", entry.ID, err)
+			}
+		}
+		return NewRSAKey(entry.ID, privateKey, publicKey)
+
+	case jwt.SigningMethodEdDSA.Alg():
+		var privateKey ed25519.PrivateKey
+		var publicKey ed25519.PublicKey
+		if entry.PrivateKeyFile != "" {
+			data, err := readPEM(dir, entry.PrivateKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			key, err := jwt.ParseEdPrivateKeyFromPEM(data)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: %v", entry.ID, err)
+			}
+			privateKey = key.(ed25519.PrivateKey)
+		} else if entry.PublicKeyFile != "" {
+			data, err := readPEM(dir, entry.PublicKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			key, err := jwt.ParseEdPublicKeyFromPEM(data)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: %v", entry.ID, err)
+			}
+			publicKey = key.(ed25519.PublicKey)
+		}
+		return NewEdDSAKey(entry.ID, privateKey, publicKey)
+	}
+
+	return nil, fmt.Errorf("key %q: unsupported alg %q", entry.ID, entry.Alg)
+}
+
+func readPEM(dir, path string) ([]byte, error) {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key: %v", err)
+	}
+	return data, nil
+}
+
+// Sign signs the claims with the current signing key and records its kid in the header
+func (k *Keyring) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(k.signingKey.Method, claims)
+	token.Header["kid"] = k.signingKey.ID
+	return token.SignedString(k.signingKey.SignKey)
+}
+
+// Parse verifies a token against the key named by its kid header
+func (k *Keyring) Parse(tokenString string, claims jwt.Claims, opts ...jwt.ParserOption) (*jwt.Token, error) {
+	opts = append(opts, jwt.WithValidMethods(k.algorithms()))
+	return jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := k.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown key id %q", kid)
+		}
+		// Never let the token pick an algorithm other than the one the key was made for
+		if token.Method.Alg() != key.Method.Alg() {
+			return nil, errors.New("unexpected signing method")
+		}
+		return key.VerifyKey, nil
+	}, opts...)
+}
+
+func (k *Keyring) algorithms() []string {
+	seen := make(map[string]bool)
+	var algs []string
+	for _, key := range k.keys {
+		if !seen[key.Method.Alg()] {
+			seen[key.Method.Alg()] = true
+			algs = append(algs, key.Method.Alg())
+		}
+	}
+	return algs
+}
+
+// PublicJWKs returns the public keys that other services need to verify our tokens.
+// HMAC secrets are never published.
+func (k *Keyring) PublicJWKs() []JWK {
+	jwks := []JWK{}
+	for _, key := range k.keys {
+		switch publicKey := key.VerifyKey.(type) {
+		case *rsa.PublicKey:
+			jwks = append(jwks, JWK{
+				Kty: "RSA",
+				Kid: key.ID,
+				Use: "sig",
+				Alg: key.Method.Alg(),
+				N:   base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			jwks = append(jwks, JWK{
+				Kty: "OKP",
+				Kid: key.ID,
+				Use: "sig",
+				Alg: key.Method.Alg(),
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(publicKey),
+			})
+		}
+	}
+
+	sort.Slice(jwks, func(i, j int) bool { return jwks[i].Kid < jwks[j].Kid })
+	return jwks
+}
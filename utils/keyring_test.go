@@ -0,0 +1,263 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func testClaims() AccessClaims {
+	now := time.Now()
+	return AccessClaims{
+		UserID: primitive.NewObjectID().Hex(),
+		Type:   TokenTypeAccess,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        "jti",
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+		},
+	}
+}
+
+func mustKeyring(t *testing.T, signingKeyID string, keys ...*Key) *Keyring {
+	t.Helper()
+	keyring, err := NewKeyring(keys, signingKeyID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyring
+}
+
+func mustHMACKey(t *testing.T, kid string) *Key {
+	t.Helper()
+	key, err := NewHMACKey(kid, []byte("0123456789abcdef0123456789abcdef-"+kid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestKeyringRotation(t *testing.T) {
+	oldKey, newKey := mustHMACKey(t, "k1"), mustHMACKey(t, "k2")
+
+	oldToken, err := mustKeyring(t, "k1", oldKey).Sign(testClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated := mustKeyring(t, "k2", oldKey, newKey)
+	newToken, err := rotated.Sign(testClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ParseToken(oldToken, TokenTypeAccess, rotated); err != nil {
+		t.Errorf("token signed by the retired key was rejected: %v", err)
+	}
+	if _, err := ParseToken(newToken, TokenTypeAccess, rotated); err != nil {
+		t.Errorf("token signed by the new key was rejected: %v", err)
+	}
+
+	// Once the old key is removed its tokens stop working
+	if _, err := ParseToken(oldToken, TokenTypeAccess, mustKeyring(t, "k2", newKey)); err == nil {
+		t.Error("token signed by a removed key was accepted")
+	}
+}
+
+func TestKeyringRetiredPublicKeyVerifies(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signing, err := NewRSAKey("rsa-1", privateKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := mustKeyring(t, "rsa-1", signing).Sign(testClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	retired, err := NewRSAKey("rsa-1", nil, &privateKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := NewEdDSAKey("ed-1", edKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ParseToken(token, TokenTypeAccess, mustKeyring(t, "ed-1", retired, current)); err != nil {
+		t.Errorf("token signed by a retired RSA key was rejected: %v", err)
+	}
+}
+
+func TestKeyringRejects(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := NewRSAKey("rsa-1", privateKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring := mustKeyring(t, "k1", mustHMACKey(t, "k1"), rsaKey)
+
+	sign := func(method jwt.SigningMethod, kid string, key interface{}) string {
+		t.Helper()
+		token := jwt.NewWithClaims(method, testClaims())
+		if kid != "" {
+			token.Header["kid"] = kid
+		}
+		s, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"unknown kid", sign(jwt.SigningMethodHS256, "k9", []byte("0123456789abcdef0123456789abcdef-k1"))},
+		{"missing kid", sign(jwt.SigningMethodHS256, "", []byte("0123456789abcdef0123456789abcdef-k1"))},
+		{"alg none", sign(jwt.SigningMethodNone, "k1", jwt.UnsafeAllowNoneSignatureType)},
+		{"HMAC signed with the RSA public key", sign(jwt.SigningMethodHS256, "rsa-1", []byte(base64.StdEncoding.EncodeToString(privateKey.PublicKey.N.Bytes())))},
+		{"wrong secret", sign(jwt.SigningMethodHS256, "k1", []byte("0123456789abcdef0123456789abcdef-xx"))},
+		{"RS256 under an HMAC kid", sign(jwt.SigningMethodRS256, "k1", privateKey)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseToken(tt.token, TokenTypeAccess, keyring); err == nil {
+				t.Error("token was accepted")
+			}
+		})
+	}
+}
+
+func TestParseTokenChecksClaims(t *testing.T) {
+	keyring := mustKeyring(t, "k1", mustHMACKey(t, "k1"))
+
+	expired := testClaims()
+	expired.IssuedAt = jwt.NewNumericDate(time.Now().Add(-time.Hour))
+	expired.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+
+	mfa := testClaims()
+	mfa.Type = TokenTypeMFA
+
+	noID := testClaims()
+	noID.ID = ""
+
+	noExpiry := testClaims()
+	noExpiry.ExpiresAt = nil
+
+	for name, claims := range map[string]AccessClaims{"expired": expired, "wrong type": mfa, "no jti": noID, "no expiry": noExpiry} {
+		t.Run(name, func(t *testing.T) {
+			token, err := keyring.Sign(claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := ParseToken(token, TokenTypeAccess, keyring); err == nil {
+				t.Error("token was accepted")
+			}
+		})
+	}
+}
+
+func TestNewKeyringValidation(t *testing.T) {
+	publicOnly, err := NewEdDSAKey("ed-1", nil, make(ed25519.PublicKey, ed25519.PublicKeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewKeyring(nil, ""); err == nil {
+		t.Error("empty keyring was accepted")
+	}
+	if _, err := NewKeyring([]*Key{mustHMACKey(t, "k1"), mustHMACKey(t, "k1")}, "k1"); err == nil {
+		t.Error("duplicate kid was accepted")
+	}
+	if _, err := NewKeyring([]*Key{mustHMACKey(t, "k1"), mustHMACKey(t, "k2")}, ""); err == nil {
+		t.Error("several keys without a signing key were accepted")
+	}
+	if _, err := NewKeyring([]*Key{publicOnly}, "ed-1"); err == nil {
+		t.Error("verification-only signing key was accepted")
+	}
+	if _, err := NewHMACKey("short", []byte("too short")); err == nil {
+		t.Error("short HMAC secret was accepted")
+	}
+	small, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewRSAKey("small", small, nil); err == nil {
+		t.Error("1024 bit RSA key was accepted")
+	}
+}
+
+func TestPublicJWKs(t *testing.T) {
+	rsaPrivate, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := NewRSAKey("b-rsa", rsaPrivate, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPublic, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edKey, err := NewEdDSAKey("a-ed", edPrivate, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyring := mustKeyring(t, "b-rsa", rsaKey, edKey, mustHMACKey(t, "c-hmac"))
+	jwks := keyring.PublicJWKs()
+
+	if len(jwks) != 2 {
+		t.Fatalf("got %d keys, want 2 without the HMAC secret: %+v", len(jwks), jwks)
+	}
+
+	ed := jwks[0]
+	if ed.Kid != "a-ed" || ed.Kty != "OKP" || ed.Crv != "Ed25519" || ed.Alg != "EdDSA" || ed.Use != "sig" {
+		t.Errorf("unexpected Ed25519 JWK: %+v", ed)
+	}
+	x, err := base64.RawURLEncoding.DecodeString(ed.X)
+	if err != nil || !edPublic.Equal(ed25519.PublicKey(x)) {
+		t.Errorf("x does not decode to the public key: %v", err)
+	}
+
+	rs := jwks[1]
+	if rs.Kid != "b-rsa" || rs.Kty != "RSA" || rs.Alg != "RS256" || rs.Use != "sig" || rs.E != "AQAB" {
+		t.Errorf("unexpected RSA JWK: %+v", rs)
+	}
+	n, err := base64.RawURLEncoding.DecodeString(rs.N)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A verifier that only has the published key can check our tokens
+	published := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: 65537}
+	token, err := keyring.Sign(testClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = jwt.Parse(token, func(*jwt.Token) (interface{}, error) { return published, nil }, jwt.WithValidMethods([]string{"RS256"}))
+	if err != nil {
+		t.Errorf("token does not verify with the published key: %v", err)
+	}
+}
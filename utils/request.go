@@ -0,0 +1,42 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ClientIP returns the IP address of the client that made the request
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// RetryAfter formats a wait as whole seconds for the Retry-After header
+func RetryAfter(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// QueryInt reads a positive integer query parameter, falling back to def when it is
+// missing and capping it at max. It fails when the value isn't a positive integer.
+func QueryInt(r *http.Request, key string, def, max int) (int, error) {
+	value := r.URL.Query().Get(key)
+	if value == "" {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("%s must be a positive integer", key)
+	}
+	if n > max {
+		return max, nil
+	}
+	return n, nil
+}
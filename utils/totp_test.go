@@ -0,0 +1,138 @@
+package utils
+
+import (
+	"encoding/base32"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 seed of RFC 6238 Appendix B, "12345678901234567890", in base32
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestValidateTOTPVectors(t *testing.T) {
+	// RFC 6238 Appendix B, SHA-1. The RFC lists 8 digit codes; with 6 digits the
+	// truncated value is taken mod 10^6, which keeps the last six of them.
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		step, ok := ValidateTOTP(rfc6238Secret, tt.code, time.Unix(tt.unix, 0))
+		if !ok {
+			t.Errorf("code %s was rejected at %d", tt.code, tt.unix)
+			continue
+		}
+		if want := tt.unix / totpPeriod; step != want {
+			t.Errorf("code %s matched step %d, want %d", tt.code, step, want)
+		}
+	}
+}
+
+func TestValidateTOTPSkew(t *testing.T) {
+	// "050471" is the code for step 37037037, which runs from 1111111110 to 1111111139
+	const code, step = "050471", 37037037
+
+	tests := []struct {
+		name string
+		unix int64
+		ok   bool
+	}{
+		{"two steps early", 1111111110 - 2*totpPeriod, false},
+		{"one step early", 1111111110 - totpPeriod, true},
+		{"start of step", 1111111110, true},
+		{"end of step", 1111111139, true},
+		{"one step late", 1111111139 + totpPeriod, true},
+		{"two steps late", 1111111139 + 2*totpPeriod, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matched, ok := ValidateTOTP(rfc6238Secret, code, time.Unix(tt.unix, 0))
+			if ok != tt.ok {
+				t.Fatalf("ValidateTOTP at %d = %t, want %t", tt.unix, ok, tt.ok)
+			}
+			// The matched step is the code's own, not the current one, so a replay in
+			// the next step is still caught by the caller's last-step check
+			if ok && matched != step {
+				t.Errorf("matched step %d, want %d", matched, step)
+			}
+		})
+	}
+}
+
+func TestValidateTOTPRejectsMalformedInput(t *testing.T) {
+	at := time.Unix(1111111111, 0)
+
+	tests := []struct {
+		name, secret, code string
+	}{
+		{"wrong code", rfc6238Secret, "050472"},
+		{"too short", rfc6238Secret, "50471"},
+		{"too long", rfc6238Secret, "0050471"},
+		{"8 digit RFC code", rfc6238Secret, "14050471"},
+		{"empty", rfc6238Secret, ""},
+		{"invalid secret", "not base32!", "050471"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := ValidateTOTP(tt.secret, tt.code, at); ok {
+				t.Error("code was accepted")
+			}
+		})
+	}
+
+	if _, ok := ValidateTOTP("gezdgnbvgy3tqojqgezdgnbvgy3tqojq", " 050471 ", at); !ok {
+		t.Error("lowercase secret and surrounding spaces should be accepted")
+	}
+}
+
+func TestGenerateTOTPSecret(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secret) != 32 {
+		t.Errorf("secret %q has %d characters, want 32", secret, len(secret))
+	}
+
+	now := time.Now()
+	code := hotp(mustDecodeSecret(t, secret), now.Unix()/totpPeriod)
+	if _, ok := ValidateTOTP(secret, code, now); !ok {
+		t.Error("code for a generated secret was rejected")
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri, err := url.Parse(TOTPURI("Go Go Notes", "bob@example.com", rfc6238Secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/Go Go Notes:bob@example.com" {
+		t.Errorf("unexpected URI %s", uri)
+	}
+	query := uri.Query()
+	for key, want := range map[string]string{"secret": rfc6238Secret, "issuer": "Go Go Notes", "algorithm": "SHA1", "digits": "6", "period": "30"} {
+		if got := query.Get(key); got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func mustDecodeSecret(t *testing.T, secret string) []byte {
+	t.Helper()
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
@@ -0,0 +1,75 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxEmailLength is the longest address SMTP allows (RFC 5321)
+const maxEmailLength = 254
+
+// maxPasswordBytes is the most bcrypt will hash, anything longer is rejected
+const maxPasswordBytes = 72
+
+// ValidationErrors maps a field name to what is wrong with it
+type ValidationErrors map[string]string
+
+// NormalizeEmail parses an address as RFC 5322 and returns it lowercased. Display
+// names ("Bob <bob@example.com>") and anything but a bare address are rejected.
+func NormalizeEmail(email string) (string, error) {
+	email = strings.TrimSpace(email)
+	if email == "" {
+		return "", errors.New("email is required")
+	}
+	if len(email) > maxEmailLength {
+		return "", errors.New("email is too long")
+	}
+
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Name != "" || address.Address != email {
+		return "", errors.New("email is not a valid address")
+	}
+
+	local, domain, _ := strings.Cut(address.Address, "@")
+	if local == "" || !strings.Contains(domain, ".") || strings.HasSuffix(domain, ".") {
+		return "", errors.New("email is not a valid address")
+	}
+
+	return strings.ToLower(address.Address), nil
+}
+
+// PasswordPolicy decides which passwords are acceptable
+type PasswordPolicy struct {
+	MinLength int
+	Breached  *BreachedPasswords // optional
+}
+
+// Validate returns a user-facing message when the password doesn't meet the policy.
+// err is only set when the policy couldn't be checked, such as the breached list
+// being unreadable, which is the server's fault rather than the password's.
+func (p *PasswordPolicy) Validate(password string) (problem string, err error) {
+	if password == "" {
+		return "password is required", nil
+	}
+	if utf8.RuneCountInString(password) < p.MinLength {
+		return fmt.Sprintf("password must be at least %d characters", p.MinLength), nil
+	}
+	if len(password) > maxPasswordBytes {
+		return fmt.Sprintf("password must be at most %d bytes", maxPasswordBytes), nil
+	}
+
+	if p.Breached != nil {
+		breached, err := p.Breached.Contains(password)
+		if err != nil {
+			return "", err
+		}
+		if breached {
+			return "password has appeared in a data breach, please choose another one", nil
+		}
+	}
+
+	return "", nil
+}
@@ -0,0 +1,169 @@
+package utils
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeEmail(t *testing.T) {
+	tests := []struct {
+		email   string
+		want    string
+		wantErr bool
+	}{
+		{"bob@example.com", "bob@example.com", false},
+		{"  Bob@Example.COM ", "bob@example.com", false},
+		{"bob+notes@mail.example.co.uk", "bob+notes@mail.example.co.uk", false},
+		{"", "", true},
+		{"   ", "", true},
+		{"bob", "", true},
+		{"bob@", "", true},
+		{"@example.com", "", true},
+		{"bob@localhost", "", true},
+		{"bob@example.", "", true},
+		{"Bob <bob@example.com>", "", true},
+		{"<bob@example.com>", "", true},
+		{"bob@example.com, eve@example.com", "", true},
+		{strings.Repeat("a", 250) + "@example.com", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := NormalizeEmail(tt.email)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("NormalizeEmail(%q) error = %v, wantErr %v", tt.email, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("NormalizeEmail(%q) = %q, want %q", tt.email, got, tt.want)
+		}
+	}
+}
+
+// writeRangeFile adds the password's hash to a Pwned Passwords style range directory
+func writeRangeFile(t *testing.T, dir, password string) {
+	t.Helper()
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	path := filepath.Join(dir, hash[:5])
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(hash[5:] + ":42\r\n"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBreachedPasswords(t *testing.T) {
+	dir := t.TempDir()
+	writeRangeFile(t, dir, "password123")
+	writeRangeFile(t, dir, "letmein")
+
+	// Entries may be lowercase and come without a count
+	sum := sha1.Sum([]byte("password1234"))
+	hash := hex.EncodeToString(sum[:])
+	if err := os.WriteFile(filepath.Join(dir, strings.ToUpper(hash[:5])), []byte(hash[5:]+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	breached, err := NewBreachedPasswords(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		password string
+		want     bool
+	}{
+		{"letmein", true},
+		{"password1234", true},
+		{"LETMEIN", false},
+		{"correct horse battery staple", false},
+	}
+	for _, tt := range tests {
+		got, err := breached.Contains(tt.password)
+		if err != nil {
+			t.Fatalf("Contains(%q) failed: %v", tt.password, err)
+		}
+		if got != tt.want {
+			t.Errorf("Contains(%q) = %t, want %t", tt.password, got, tt.want)
+		}
+	}
+}
+
+func TestNewBreachedPasswordsRejectsFiles(t *testing.T) {
+	if _, err := NewBreachedPasswords(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("expected an error for a missing directory")
+	}
+
+	file := filepath.Join(t.TempDir(), "list.txt")
+	if err := os.WriteFile(file, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewBreachedPasswords(file); err == nil {
+		t.Error("expected an error for a file")
+	}
+}
+
+func TestPasswordPolicyValidate(t *testing.T) {
+	dir := t.TempDir()
+	writeRangeFile(t, dir, "password123")
+	breached, err := NewBreachedPasswords(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := &PasswordPolicy{MinLength: 8, Breached: breached}
+
+	tests := []struct {
+		name     string
+		password string
+		problem  bool
+	}{
+		{"empty", "", true},
+		{"too short", "short", true},
+		{"short in bytes but long enough in runes", "пароль12", false},
+		{"too long for bcrypt", strings.Repeat("a", 73), true},
+		{"breached", "password123", true},
+		{"fine", "correct horse battery staple", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problem, err := policy.Validate(tt.password)
+			if err != nil {
+				t.Fatalf("Validate failed: %v", err)
+			}
+			if (problem != "") != tt.problem {
+				t.Errorf("Validate(%q) = %q, want a problem: %t", tt.password, problem, tt.problem)
+			}
+		})
+	}
+}
+
+func TestPasswordPolicyValidateReportsUnreadableList(t *testing.T) {
+	dir := t.TempDir()
+	breached, err := NewBreachedPasswords(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A directory where the range file should be can be opened but not read
+	sum := sha1.Sum([]byte("password123"))
+	if err := os.Mkdir(filepath.Join(dir, strings.ToUpper(hex.EncodeToString(sum[:]))[:5]), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	policy := &PasswordPolicy{MinLength: 8, Breached: breached}
+	problem, err := policy.Validate("password123")
+	if err == nil {
+		t.Fatalf("expected an error, got problem %q", problem)
+	}
+	if problem != "" {
+		t.Errorf("an unreadable list is not the password's fault, got problem %q", problem)
+	}
+}